@@ -0,0 +1,151 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	jaeger "github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-client-go/utils"
+)
+
+// SamplingBudgetBaggageKey is the baggage item carrying the sampling budget
+// set by SetSamplingBudget, as "rootService:tracesPerSecond".
+const SamplingBudgetBaggageKey = "sampling-budget"
+
+// maximum number of root services tracked by budgetSampler
+const maxBudgetServices = 100
+
+// SetSamplingBudget sets the sampling budget of rootService on the span active
+// in ctx, which is propagated to its descendants as baggage: services enabling
+// Options.SamplingBudgets sample at most perSecond traces per second among the
+// traces started with this baggage, across every trace of rootService.
+//
+// EXPERIMENTAL: the format of the baggage item and the accounting of the
+// budget may change.
+//
+// It does nothing if ctx carries no span.
+func SetSamplingBudget(ctx context.Context, rootService string, perSecond float64) {
+	SetBaggage(ctx, SamplingBudgetBaggageKey, rootService+":"+strconv.FormatFloat(perSecond, 'g', -1, 64))
+}
+
+// parseSamplingBudget returns the root service and rate of a baggage item set
+// by SetSamplingBudget.
+func parseSamplingBudget(value string) (string, float64, bool) {
+	i := strings.LastIndex(value, ":")
+	if i <= 0 {
+		return "", 0, false
+	}
+	perSecond, err := strconv.ParseFloat(value[i+1:], 64)
+	if err != nil || perSecond < 0 || math.IsInf(perSecond, 0) || math.IsNaN(perSecond) {
+		return "", 0, false
+	}
+	return value[:i], perSecond, true
+}
+
+// budget is the token bucket of a root service.
+type budget struct {
+	perSecond float64
+	limiter   *utils.ReconfigurableRateLimiter
+}
+
+// budgetSampler stops sampling traces carrying a sampling budget once the
+// budget of their root service is exhausted, and defers to base for every
+// other decision.
+//
+// jaeger only consults the sampler for the root span of a trace in this
+// process, so like baggageSampler it only sees budgets present when that span
+// is started, e.g. extracted from an inbound jaeger-baggage header. Spans
+// continuing a trace sampled upstream are always sampled.
+type budgetSampler struct {
+	jaeger.SamplerV2Base
+	base jaeger.SamplerV2
+
+	mu      sync.Mutex
+	budgets map[string]*budget
+}
+
+func newBudgetSampler(base jaeger.Sampler) *budgetSampler {
+	return &budgetSampler{base: samplerV2(base), budgets: make(map[string]*budget)}
+}
+
+// spend takes a token from the budget of rootService, and returns whether
+// there was one left. Services past maxBudgetServices are not limited.
+func (s *budgetSampler) spend(rootService string, perSecond float64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.budgets[rootService]
+	if b == nil {
+		if len(s.budgets) >= maxBudgetServices {
+			return true
+		}
+		b = &budget{perSecond: perSecond, limiter: utils.NewRateLimiter(perSecond, math.Max(perSecond, 1))}
+		s.budgets[rootService] = b
+	} else if b.perSecond != perSecond {
+		// the tokens spent already still count, so that traces alternating
+		// between two rates don't refill the budget
+		b.perSecond = perSecond
+		b.limiter.Update(perSecond, math.Max(perSecond, 1))
+	}
+	return b.limiter.CheckCredit(1)
+}
+
+// limit drops the traces sampled by d once the budget carried by span is
+// exhausted.
+func (s *budgetSampler) limit(span *jaeger.Span, d jaeger.SamplingDecision) jaeger.SamplingDecision {
+	if !d.Sample || span.SpanContext().IsSampled() {
+		return d
+	}
+	rootService, perSecond, ok := parseSamplingBudget(span.BaggageItem(SamplingBudgetBaggageKey))
+	if !ok || s.spend(rootService, perSecond) {
+		return d
+	}
+	return jaeger.SamplingDecision{}
+}
+
+// OnCreateSpan implements the OnCreateSpan() method of jaeger.SamplerV2.
+func (s *budgetSampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return s.limit(span, s.base.OnCreateSpan(span))
+}
+
+// OnSetOperationName implements the OnSetOperationName() method of jaeger.SamplerV2.
+func (s *budgetSampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	return s.limit(span, s.base.OnSetOperationName(span, operationName))
+}
+
+// OnSetTag implements the OnSetTag() method of jaeger.SamplerV2.
+func (s *budgetSampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	return s.limit(span, s.base.OnSetTag(span, key, value))
+}
+
+// OnFinishSpan implements the OnFinishSpan() method of jaeger.SamplerV2.
+func (s *budgetSampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return s.limit(span, s.base.OnFinishSpan(span))
+}
+
+// String describes the sampler for StatusHandler.
+func (s *budgetSampler) String() string {
+	return fmt.Sprintf("BudgetSampler(base=%s)", describeSampler(s.base))
+}
+
+// Close implements the Close() method of jaeger.SamplerV2.
+func (s *budgetSampler) Close() {
+	s.base.Close()
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSamplingBudgets(t *testing.T) {
+	defer configureRecording(t, &Options{SamplingBudgets: true}).Close()
+
+	for _, tt := range []struct {
+		baggage string
+		want    int
+	}{
+		{SamplingBudgetBaggageKey + "=frontend:3", 3},
+		// budgets are per root service
+		{SamplingBudgetBaggageKey + "=batch:2", 2},
+		// exhausted already
+		{SamplingBudgetBaggageKey + "=frontend:3", 0},
+		// a new rate applies to the tokens spent already
+		{SamplingBudgetBaggageKey + "=frontend:4", 0},
+		{SamplingBudgetBaggageKey + "=frontend:3", 0},
+		{"tenant=acme", 10},
+		{SamplingBudgetBaggageKey + "=malformed", 10},
+	} {
+		var sampled int
+		for i := 0; i < 10; i++ {
+			if startWithBaggage(t, tt.baggage) {
+				sampled++
+			}
+		}
+		if sampled != tt.want {
+			t.Errorf("%s: got %d of 10 traces sampled, want %d", tt.baggage, sampled, tt.want)
+		}
+	}
+}
+
+func TestSamplingBudgetsThrottled(t *testing.T) {
+	defer configureRecording(t, &Options{
+		SamplingBudgets:             true,
+		AdaptiveThresholdPerMinute:  1,
+		AdaptiveThrottledSampleRate: 1,
+	}).Close()
+
+	var sampled int
+	for i := 0; i < 10; i++ {
+		if startWithBaggage(t, SamplingBudgetBaggageKey+"=frontend:2") {
+			sampled++
+		}
+	}
+	if sampled != 2 {
+		t.Errorf("got %d of 10 traces sampled past the adaptive threshold, want the budget of 2", sampled)
+	}
+}
+
+func TestSetSamplingBudget(t *testing.T) {
+	defer configureRecording(t, &Options{}).Close()
+
+	span, ctx := StartSpan(context.Background(), "root")
+	defer span.Finish()
+	SetSamplingBudget(ctx, "frontend", 2.5)
+	rootService, perSecond, ok := parseSamplingBudget(span.BaggageItem(SamplingBudgetBaggageKey))
+	if !ok || rootService != "frontend" || perSecond != 2.5 {
+		t.Errorf("got budget %q, %v, %t", rootService, perSecond, ok)
+	}
+
+	for _, invalid := range []string{"", "frontend", ":1", "frontend:-1", "frontend:NaN", "frontend:+Inf", "frontend:x"} {
+		if _, _, ok := parseSamplingBudget(invalid); ok {
+			t.Errorf("parsed invalid budget %q", invalid)
+		}
+	}
+}
@@ -18,14 +18,17 @@ package tracing
 import (
 	"fmt"
 	"io"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
 	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	jaeger "github.com/uber/jaeger-client-go"
 	"github.com/uber/jaeger-client-go/transport"
 	"github.com/uber/jaeger-client-go/transport/zipkin"
-	zp "github.com/uber/jaeger-client-go/zipkin"
 )
 
 // Sample code for configuring & using tracing package
@@ -57,8 +60,20 @@ func setupTracing() {
 // https://github.com/istio/istio/blob/master/pkg/tracing/config.go
 
 type holder struct {
-	closer io.Closer
-	tracer ot.Tracer
+	closer   io.Closer
+	tracer   ot.Tracer
+	inflight *inflightObserver
+
+	// set when Options.DebugUnfinishedSpans
+	unfinished *unfinishedObserver
+
+	// reported by StatusHandler
+	backends           []string
+	samplerDescription string
+	stats              *collectorStats
+
+	// set when Options.RecentSpanBufferSize is positive
+	recent *recentReporter
 }
 
 var (
@@ -68,67 +83,380 @@ var (
 	logger      = spanLogger{}
 )
 
-// indirection for testing
+// process tags of Options.Environment, Options.ServiceNamespace and
+// Options.ServiceVersion
+const (
+	environmentTag      = "deployment.environment"
+	serviceNamespaceTag = "service.namespace"
+	serviceVersionTag   = "service.version"
+)
+
+// activeOptions holds a copy of the *Options passed to the last successful
+// Configure, for the helpers which don't take options of their own.
+var activeOptions atomic.Value
+
+func init() {
+	activeOptions.Store(&Options{})
+}
+
+// currentOptions returns the options of the tracer installed by Configure,
+// or zero options if there is none. The result must not be modified.
+func currentOptions() *Options {
+	return activeOptions.Load().(*Options)
+}
+
+// indirections for testing
 type newZipkin func(url string, options ...zipkin.HTTPOption) (*zipkin.HTTPTransport, error)
+type newJaeger func(url string, options ...transport.HTTPOption) *transport.HTTPTransport
 
 // Configure initializes Istio's tracing subsystem.
 //
-// You typically call this once at process startup.
+// You typically call this once at process startup. Nil options leave tracing
+// disabled.
 // Once this call returns, the tracing system is ready to accept data.
 func Configure(serviceName string, options *Options) (io.Closer, error) {
-	return configure(serviceName, options, zipkin.NewHTTPTransport)
+	return configure(serviceName, options, zipkin.NewHTTPTransport, transport.NewHTTPTransport)
 }
 
-func configure(serviceName string, options *Options, nz newZipkin) (io.Closer, error) {
+func configure(serviceName string, options *Options, nz newZipkin, nj newJaeger) (io.Closer, error) {
+	if options == nil {
+		// nil options leave tracing disabled, just like empty ones
+		discardEarlySpans()
+		return holder{}, nil
+	}
 	if err := options.Validate(); err != nil {
 		return nil, err
 	}
 
-	reporters := make([]jaeger.Reporter, 0, 3)
+	// built before any reporter starts, so that there is nothing to stop if
+	// one of them fails
+	propOpts, err := propagationOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	var red *redObserver
+	if options.REDMetrics != nil {
+		if red, err = newREDObserver(options.REDMetrics, options.OperationNameSanitizer, options.DurationBuckets); err != nil {
+			return nil, fmt.Errorf("could not register RED metrics: %v", err)
+		}
+	}
+	s, err := newSamplerChain(serviceName, options)
+	if err != nil {
+		return nil, err
+	}
+
+	reporters := make([]jaeger.Reporter, 0, 5)
+	// fail stops the sampler and the reporters built so far
+	fail := func(err error) (io.Closer, error) {
+		for _, rep := range reporters {
+			rep.Close()
+		}
+		s.Close()
+		return nil, err
+	}
+
+	roundTripper, err := newCollectorRoundTripper(options)
+	if err != nil {
+		return fail(fmt.Errorf("could not build collector transport: %v", err))
+	}
+	if options.ReporterMaxRetries > 0 {
+		roundTripper = newRetryingRoundTripper(roundTripper, options)
+	}
+
+	zipkinRoundTripper := newRejectHandlingRoundTripper(roundTripper, options, splitZipkinBatch)
+	jaegerRoundTripper := newRejectHandlingRoundTripper(roundTripper, options, splitJaegerBatch)
+
+	stats := &collectorStats{}
 
 	if options.ZipkinURL != "" {
-		trans, err := nz(options.ZipkinURL, zipkin.HTTPLogger(logger), zipkin.HTTPTimeout(httpTimeout))
+		zipkinOpts := []zipkin.HTTPOption{
+			zipkin.HTTPLogger(logger),
+			zipkin.HTTPTimeout(httpTimeout),
+			zipkin.HTTPRoundTripper(zipkinRoundTripper),
+		}
+		trans, err := newZipkinTransport(options, nz, zipkinOpts)
 		if err != nil {
-			return nil, fmt.Errorf("could not build zipkin reporter: %v", err)
+			return fail(err)
+		}
+		rep := newCollectorReporter(options, trans, stats)
+		if options.FlushPrioritySpans {
+			flushTrans, err := newZipkinTransport(options, nz, zipkinOpts)
+			if err != nil {
+				rep.Close()
+				return fail(err)
+			}
+			rep = &priorityFlushingReporter{Reporter: rep, trans: flushTrans}
 		}
-		reporters = append(reporters, jaeger.NewRemoteReporter(trans))
+		reporters = append(reporters, rep)
 	}
 
 	if options.JaegerURL != "" {
-		reporters = append(reporters, jaeger.NewRemoteReporter(transport.NewHTTPTransport(options.JaegerURL, transport.HTTPTimeout(httpTimeout))))
+		warnIfAgentPort(options.JaegerURL)
+		warnIfAgentPort(options.FallbackJaegerURL)
+		jaegerOpts := []transport.HTTPOption{
+			transport.HTTPTimeout(httpTimeout),
+			transport.HTTPRoundTripper(jaegerRoundTripper),
+		}
+		trans, err := newJaegerTransport(options, nj, jaegerOpts)
+		if err != nil {
+			return fail(err)
+		}
+		rep := newCollectorReporter(options, trans, stats)
+		if options.FlushPrioritySpans {
+			flushTrans, err := newJaegerTransport(options, nj, jaegerOpts)
+			if err != nil {
+				rep.Close()
+				return fail(err)
+			}
+			rep = &priorityFlushingReporter{Reporter: rep, trans: flushTrans}
+		}
+		reporters = append(reporters, rep)
 	}
 
-	if options.LogTraceSpans {
-		reporters = append(reporters, logger)
+	if options.DatadogAgentURL != "" {
+		trans := newDatadogTransport(options.DatadogAgentURL, serviceName, newRejectHandlingRoundTripper(roundTripper, options, nil))
+		reporters = append(reporters, newCollectorReporter(options, trans, stats))
 	}
 
-	var rep jaeger.Reporter
-	if len(reporters) == 0 {
+	if options.OTLPEndpoint != "" {
+		trans, err := newOTLPTransport(options.OTLPEndpoint, serviceName, options)
+		if err != nil {
+			return fail(fmt.Errorf("could not build OTLP reporter: %v", err))
+		}
+		reporters = append(reporters, newCollectorReporter(options, trans, stats))
+	}
+
+	if options.DualEncodeValidationURL != "" {
+		zipkinOpts := []zipkin.HTTPOption{
+			zipkin.HTTPLogger(logger),
+			zipkin.HTTPTimeout(httpTimeout),
+			zipkin.HTTPRoundTripper(zipkinRoundTripper),
+		}
+		trans, err := nz(options.DualEncodeValidationURL, zipkinOpts...)
+		if err != nil {
+			return fail(fmt.Errorf("could not build dual encode validation reporter: %v", err))
+		}
+		// kept out of the collector stats, which describe the primary collector
+		reporters = append(reporters, newCollectorReporter(options, trans, &collectorStats{}))
+	}
+
+	var console *consoleReporter
+	if options.ConsoleExporter {
+		console = newConsoleReporter(consoleOutput)
+		reporters = append(reporters, console)
+	}
+
+	if options.OpenCensusExporter != nil {
+		reporters = append(reporters, openCensusReporter{options.OpenCensusExporter})
+	}
+
+	if options.OTLPFile != "" {
+		otlp, err := newOTLPFileReporter(options.OTLPFile, serviceName)
+		if err != nil {
+			return fail(fmt.Errorf("could not open OTLP file: %v", err))
+		}
+		reporters = append(reporters, otlp)
+	}
+
+	var recent *recentReporter
+	if options.RecentSpanBufferSize > 0 {
+		recent = newRecentReporter(options.RecentSpanBufferSize)
+		reporters = append(reporters, recent)
+	}
+
+	if len(reporters) == 0 && !options.LogTraceSpans {
 		// leave the default NoopTracer in place since there's no place for tracing to go...
+		discardEarlySpans()
+		s.Close()
 		return holder{}, nil
-	} else if len(reporters) == 1 {
+	}
+	// always in the chain, so that SetLogSpans can turn logging on later
+	if len(options.LogSamplingRules) > 0 {
+		reporters = append(reporters, newRuleSampledReporter(logger, options.LogSamplingRules))
+	} else {
+		reporters = append(reporters, logger)
+	}
+
+	var rep jaeger.Reporter
+	if len(reporters) == 1 {
 		rep = reporters[0]
 	} else {
+		if options.IndependentReporterQueues {
+			for i, r := range reporters {
+				reporters[i] = newQueuedReporter(r)
+			}
+		}
 		rep = jaeger.NewCompositeReporter(reporters...)
 	}
+	rep = wrapReporter(serviceName, options, rep)
 
 	opts := []jaeger.TracerOption{poolSpans}
-	if options.ZipkinURL != "" {
-		// Setup zipkin style tracing
-		zipkinPropagator := zp.NewZipkinB3HTTPHeaderPropagator()
-		injector := jaeger.TracerOptions.Injector(ot.HTTPHeaders, zipkinPropagator)
-		extractor := jaeger.TracerOptions.Extractor(ot.HTTPHeaders, zipkinPropagator)
-		opts = append(opts, injector, extractor)
+	opts = append(opts, propOpts...)
+	opts = append(opts, processTags(options)...)
+	if options.RandomNumberFunc != nil {
+		opts = append(opts, jaeger.TracerOptions.RandomNumber(options.RandomNumberFunc))
+	}
+	if console != nil {
+		opts = append(opts, jaeger.TracerOptions.ContribObserver(console))
+	}
+	if options.PropagateSamplerTags {
+		samplerTags := newSamplerTagReporter(rep)
+		rep = samplerTags
+		opts = append(opts, jaeger.TracerOptions.ContribObserver(samplerTags))
+	}
+	if red != nil {
+		opts = append(opts, jaeger.TracerOptions.ContribObserver(red))
+	}
+	inflight := &inflightObserver{}
+	opts = append(opts, jaeger.TracerOptions.ContribObserver(inflight))
+	var unfinished *unfinishedObserver
+	if options.DebugUnfinishedSpans {
+		unfinished = newUnfinishedObserver()
+		opts = append(opts, jaeger.TracerOptions.ContribObserver(unfinished))
 	}
-	tracer, closer := jaeger.NewTracer(serviceName, sampler, rep, opts...)
+
+	if options.CloseTimeout > 0 {
+		rep = &timeoutReporter{Reporter: rep, timeout: options.CloseTimeout}
+	}
+	tracer, closer := jaeger.NewTracer(serviceName, s, rep, opts...)
 
 	// NOTE: global side effect!
-	ot.SetGlobalTracer(tracer)
+	global := ot.GlobalTracer()
+	if _, isNoop := global.(ot.NoopTracer); options.OnlySetGlobalIfUnset && !isNoop && !isEarlyTracer(global) {
+		glog.Infof("Not replacing the global tracer already installed for %s", serviceName)
+	} else {
+		ot.SetGlobalTracer(tracer)
+	}
+	replayEarlySpans(tracer)
+
+	h := holder{
+		closer:   &onceCloser{closer: closer},
+		tracer:   tracer,
+		inflight: inflight,
+
+		unfinished: unfinished,
+
+		backends:           options.backends(),
+		samplerDescription: describeSampler(s),
+		stats:              stats,
+
+		recent: recent,
+	}
+	activeMu.Lock()
+	active = &h
+	activeMu.Unlock()
+	atomic.StoreInt32(&quiescing, 0)
+	atomic.StoreInt32(&logSpans, boolToInt32(options.LogTraceSpans))
+	current := *options
+	activeOptions.Store(&current)
+
+	if options.TraceSelfInit {
+		traceSelfInit(tracer, h)
+	}
+	return h, nil
+}
+
+// newZipkinTransport returns the transport sending spans to the Zipkin
+// collector, failing over to the fallback collector and waiting for the
+// collector to be reachable as configured by options.
+func newZipkinTransport(options *Options, nz newZipkin, zipkinOpts []zipkin.HTTPOption) (jaeger.Transport, error) {
+	zipkinTrans, err := nz(options.ZipkinURL, zipkinOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not build zipkin reporter: %v", err)
+	}
+	var trans jaeger.Transport = zipkinTrans
+	if options.FallbackZipkinURL != "" {
+		fallback, err := nz(options.FallbackZipkinURL, zipkinOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("could not build fallback zipkin reporter: %v", err)
+		}
+		trans = newFailoverTransport(trans, fallback)
+	}
+	if options.RetryCollectorConnect {
+		if trans, err = newConnectingTransport(trans, options.ZipkinURL); err != nil {
+			return nil, fmt.Errorf("could not build zipkin reporter: %v", err)
+		}
+	}
+	return trans, nil
+}
 
-	return holder{
-		closer: closer,
-		tracer: tracer,
-	}, nil
+// newJaegerTransport returns the transport sending spans to the Jaeger
+// collector, failing over to the fallback collector and waiting for the
+// collector to be reachable as configured by options.
+func newJaegerTransport(options *Options, nj newJaeger, jaegerOpts []transport.HTTPOption) (jaeger.Transport, error) {
+	var trans jaeger.Transport = nj(options.JaegerURL, jaegerOpts...)
+	if options.FallbackJaegerURL != "" {
+		trans = newFailoverTransport(trans, nj(options.FallbackJaegerURL, jaegerOpts...))
+	}
+	if options.RetryCollectorConnect {
+		var err error
+		if trans, err = newConnectingTransport(trans, options.JaegerURL); err != nil {
+			return nil, fmt.Errorf("could not build jaeger reporter: %v", err)
+		}
+	}
+	return trans, nil
+}
+
+// traceSelfInit records a span for the configuration of tracer, forcing its
+// sampling so that it shows up in the backend. See Options.TraceSelfInit.
+func traceSelfInit(tracer ot.Tracer, h holder) {
+	span := tracer.StartSpan("tracing.configure")
+	// tags of unsampled spans are discarded, so the priority goes first
+	ext.SamplingPriority.Set(span, 1)
+	span.SetTag("tracing.backends", strings.Join(h.backends, ","))
+	span.SetTag("tracing.sampler", h.samplerDescription)
+	span.Finish()
+}
+
+// UDP ports of the jaeger agent, which JaegerURL is often mistakenly pointed at
+var jaegerAgentPorts = map[string]bool{"5775": true, "6831": true, "6832": true}
+
+// warnIfAgentPort logs a warning if the jaeger collector URL rawURL uses a
+// port of the jaeger agent. It only warns, as ports can be remapped.
+func warnIfAgentPort(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || !jaegerAgentPorts[u.Port()] {
+		return
+	}
+	glog.Warningf("Jaeger collector URL %s uses port %s of the jaeger agent, which doesn't accept spans over HTTP; "+
+		"the jaeger collector usually listens on port 14268", rawURL, u.Port())
+}
+
+// processTags returns the tracer options setting the process tags configured
+// by the options.
+func processTags(options *Options) []jaeger.TracerOption {
+	var opts []jaeger.TracerOption
+	if options.Environment != "" {
+		opts = append(opts, jaeger.TracerOptions.Tag(environmentTag, options.Environment))
+	}
+	if options.ServiceNamespace != "" {
+		opts = append(opts, jaeger.TracerOptions.Tag(serviceNamespaceTag, options.ServiceNamespace))
+	}
+	if options.ServiceVersion != "" {
+		opts = append(opts, jaeger.TracerOptions.Tag(serviceVersionTag, options.ServiceVersion))
+	}
+	return opts
+}
+
+// JaegerTracer returns the jaeger tracer installed by Configure, for APIs of
+// jaeger which ot.Tracer hides, or false if tracing is not enabled. The
+// io.Closer returned by Configure implements it too, for the tracer it
+// installed.
+func JaegerTracer() (*jaeger.Tracer, bool) {
+	activeMu.Lock()
+	h := active
+	activeMu.Unlock()
+	if h == nil {
+		return nil, false
+	}
+	return h.JaegerTracer()
+}
+
+// JaegerTracer returns the jaeger tracer of h, or false if tracing was left
+// disabled.
+func (h holder) JaegerTracer() (*jaeger.Tracer, bool) {
+	tracer, ok := h.tracer.(*jaeger.Tracer)
+	return tracer, ok
 }
 
 func (h holder) Close() error {
@@ -136,6 +464,16 @@ func (h holder) Close() error {
 		ot.SetGlobalTracer(ot.NoopTracer{})
 	}
 
+	activeMu.Lock()
+	if active != nil && active.tracer == h.tracer {
+		active = nil
+		activeOptions.Store(&Options{})
+	}
+	activeMu.Unlock()
+
+	if h.unfinished != nil {
+		h.unfinished.warn()
+	}
 	if h.closer != nil {
 		h.closer.Close()
 	}
@@ -143,10 +481,32 @@ func (h holder) Close() error {
 	return nil
 }
 
+// logSpans is non-zero while spanLogger logs the spans it is given, see
+// SetLogSpans.
+var logSpans int32
+
+// SetLogSpans turns the logging of every reported span on or off, e.g. to
+// look at spans during an incident without a restart, until the next
+// Configure sets it back to Options.LogTraceSpans.
+func SetLogSpans(enabled bool) {
+	atomic.StoreInt32(&logSpans, boolToInt32(enabled))
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 type spanLogger struct{}
 
-// Report implements the Report() method of jaeger.Reporter
+// Report implements the Report() method of jaeger.Reporter. Spans are only
+// logged while enabled by SetLogSpans.
 func (spanLogger) Report(span *jaeger.Span) {
+	if atomic.LoadInt32(&logSpans) == 0 {
+		return
+	}
 	glog.Infof("Reporting span operation: %s span: %s",
 		span.OperationName(), span.String())
 }
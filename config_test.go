@@ -0,0 +1,326 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/glog"
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-client-go/transport"
+	"github.com/uber/jaeger-client-go/transport/zipkin"
+)
+
+// loggedSpans returns the number of info lines logged while finishing a span.
+func loggedSpans() int64 {
+	before := glog.Stats.Info.Lines()
+	span, _ := StartSpan(context.Background(), "op")
+	span.Finish()
+	return glog.Stats.Info.Lines() - before
+}
+
+func TestSetLogSpans(t *testing.T) {
+	defer SetLogSpans(false)
+
+	closer := configureCollector(t, &Options{LogTraceSpans: true})
+	if n := loggedSpans(); n != 1 {
+		t.Errorf("got %d lines logged with LogTraceSpans, want 1", n)
+	}
+	SetLogSpans(false)
+	if n := loggedSpans(); n != 0 {
+		t.Errorf("got %d lines logged after SetLogSpans(false)", n)
+	}
+	SetLogSpans(true)
+	if n := loggedSpans(); n != 1 {
+		t.Errorf("got %d lines logged after SetLogSpans(true), want 1", n)
+	}
+	closer.Close()
+
+	defer configureCollector(t, &Options{}).Close()
+	if n := loggedSpans(); n != 0 {
+		t.Errorf("got %d lines logged without LogTraceSpans", n)
+	}
+	SetLogSpans(true)
+	if n := loggedSpans(); n != 1 {
+		t.Errorf("got %d lines logged after SetLogSpans(true) without LogTraceSpans, want 1", n)
+	}
+}
+
+func TestRandomNumberFunc(t *testing.T) {
+	var n uint64
+	defer configureCollector(t, &Options{RandomNumberFunc: func() uint64 { n++; return 1000 + n }}).Close()
+
+	parent, ctx := StartSpan(context.Background(), "parent")
+	child, _ := StartSpan(ctx, "child")
+	child.Finish()
+	parent.Finish()
+
+	parentContext := parent.Context().(jaeger.SpanContext)
+	childContext := child.Context().(jaeger.SpanContext)
+	if id := uint64(parentContext.SpanID()); id <= 1000 || id > 1000+n {
+		t.Errorf("got span ID %d, want one drawn from RandomNumberFunc", id)
+	}
+	if parentContext.TraceID().Low != uint64(parentContext.SpanID()) || childContext.SpanID() != parentContext.SpanID()+1 {
+		t.Errorf("IDs weren't drawn from RandomNumberFunc in order: %v, %v", parentContext, childContext)
+	}
+}
+
+func TestOnlySetGlobalIfUnset(t *testing.T) {
+	app, appCloser := jaeger.NewTracer("app", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer appCloser.Close()
+	ot.SetGlobalTracer(app)
+	defer ot.SetGlobalTracer(ot.NoopTracer{})
+
+	configured := configureCollector(t, &Options{OnlySetGlobalIfUnset: true})
+	if ot.GlobalTracer() != app {
+		t.Error("global tracer of the application was replaced")
+	}
+	if tracer, ok := configured.closer.(holder).JaegerTracer(); !ok || tracer == app {
+		t.Errorf("got tracer %v, want the one configured", tracer)
+	}
+	configured.Close()
+	if ot.GlobalTracer() != app {
+		t.Error("global tracer of the application was reset by Close")
+	}
+
+	ot.SetGlobalTracer(ot.NoopTracer{})
+	configured = configureCollector(t, &Options{OnlySetGlobalIfUnset: true})
+	defer configured.Close()
+	if tracer, _ := configured.closer.(holder).JaegerTracer(); ot.GlobalTracer() != tracer {
+		t.Error("global tracer wasn't set while unset")
+	}
+}
+
+func TestProcessTags(t *testing.T) {
+	configured := configureCollector(t, &Options{Environment: "staging", ServiceNamespace: "payments", ServiceVersion: "1.4.2"})
+	defer configured.Close()
+
+	tracer, _ := configured.closer.(holder).JaegerTracer()
+	tags := map[string]interface{}{}
+	for _, tag := range tracer.Tags() {
+		tags[tag.Key] = tag.Value
+	}
+	for key, want := range map[string]string{
+		"deployment.environment": "staging",
+		"service.namespace":      "payments",
+		"service.version":        "1.4.2",
+	} {
+		if got := tags[key]; got != want {
+			t.Errorf("got process tag %s=%v, want %q", key, got, want)
+		}
+	}
+}
+
+func TestConfigureNilOptions(t *testing.T) {
+	closer, err := Configure("svc", nil)
+	if err != nil {
+		t.Fatalf("Configure with nil options: %v", err)
+	}
+	if Enabled() {
+		t.Error("tracing enabled by nil options")
+	}
+	if err := closer.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestJaegerTracer(t *testing.T) {
+	configured := configureCollector(t, &Options{})
+	tracer, ok := JaegerTracer()
+	if !ok || ot.GlobalTracer() != tracer {
+		t.Errorf("got tracer %v, want the one installed", tracer)
+	}
+	if own, _ := configured.closer.(holder).JaegerTracer(); own != tracer {
+		t.Errorf("got tracer %v from the configured tracer, want %v", own, tracer)
+	}
+	configured.Close()
+	if _, ok := JaegerTracer(); ok {
+		t.Error("got a tracer once closed")
+	}
+
+	closer, err := Configure("svc", &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+	if _, ok := closer.(holder).JaegerTracer(); ok {
+		t.Error("got a tracer with tracing disabled")
+	}
+	if _, ok := JaegerTracer(); ok {
+		t.Error("got a global tracer with tracing disabled")
+	}
+}
+
+func TestTraceSelfInit(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		options := &Options{TraceSelfInit: enabled, SamplerType: "const", SamplerParam: 0}
+		collector := recordCollector(options)
+		closer := configureRecording(t, options)
+		spans := collector.GetSpans()
+		closer.Close()
+
+		if !enabled {
+			if len(spans) != 0 {
+				t.Errorf("got spans %v with TraceSelfInit unset", spans)
+			}
+			continue
+		}
+		if len(spans) != 1 {
+			t.Fatalf("got %d spans, want the one of Configure, forced past the sampler", len(spans))
+		}
+		span := spans[0].(*jaeger.Span)
+		tags := span.Tags()
+		if span.OperationName() != "tracing.configure" || !strings.HasPrefix(tags["tracing.backends"].(string), "jaeger") {
+			t.Errorf("got span %s with tags %v", span.OperationName(), tags)
+		}
+		if tags["tracing.sampler"] == "" {
+			t.Errorf("got no sampler tag: %v", tags)
+		}
+	}
+}
+
+func TestConfigureJaegerURL(t *testing.T) {
+	release := make(chan struct{})
+	var posts int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+		<-release
+	}))
+	defer collector.Close()
+	defer close(release)
+
+	defer func(timeout time.Duration) { httpTimeout = timeout }(httpTimeout)
+	httpTimeout = 50 * time.Millisecond
+
+	var urls []string
+	nj := func(url string, options ...transport.HTTPOption) *transport.HTTPTransport {
+		urls = append(urls, url)
+		return transport.NewHTTPTransport(url, options...)
+	}
+	closer, err := configure("svc", &Options{
+		JaegerURL:    collector.URL + "/api/traces",
+		SamplerType:  "const",
+		SamplerParam: 1,
+	}, zipkin.NewHTTPTransport, nj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0] != collector.URL+"/api/traces" {
+		t.Errorf("got jaeger transports for %v", urls)
+	}
+
+	span, _ := StartSpan(context.Background(), "op")
+	span.Finish()
+	start := time.Now()
+	closer.Close()
+	if n := atomic.LoadInt32(&posts); n != 1 {
+		t.Errorf("got %d uploads, want 1", n)
+	}
+	// the collector never answers, so the upload must time out
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("upload took %v with a timeout of %v", elapsed, httpTimeout)
+	}
+}
+
+func TestDualEncodeValidation(t *testing.T) {
+	var jaegerPosts, zipkinPosts int32
+	jaegerCollector := countingCollector(http.StatusAccepted, &jaegerPosts)
+	defer jaegerCollector.Close()
+	zipkinCollector := countingCollector(http.StatusAccepted, &zipkinPosts)
+	defer zipkinCollector.Close()
+
+	closer, err := Configure("svc", &Options{
+		JaegerURL:               jaegerCollector.URL,
+		DualEncodeValidationURL: zipkinCollector.URL,
+		SamplerType:             "const",
+		SamplerParam:            1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	span, _ := StartSpan(context.Background(), "op")
+	span.Finish()
+	closer.Close()
+
+	if n := atomic.LoadInt32(&jaegerPosts); n != 1 {
+		t.Errorf("got %d uploads to the jaeger collector, want 1", n)
+	}
+	if n := atomic.LoadInt32(&zipkinPosts); n != 1 {
+		t.Errorf("got %d uploads to the validation collector, want 1", n)
+	}
+}
+
+// closeCountingReporter counts the calls to its Close method.
+type closeCountingReporter struct {
+	closed *int32
+}
+
+func (r closeCountingReporter) Report(*jaeger.Span) {}
+
+func (r closeCountingReporter) Close() { atomic.AddInt32(r.closed, 1) }
+
+func TestConfigureFailure(t *testing.T) {
+	var closed int32
+	_, err := Configure("svc", &Options{
+		JaegerURL:     "http://127.0.0.1:14268/api/traces",
+		OTLPFile:      "/nonexistent/spans.otlp",
+		LogTraceSpans: true,
+		CollectorReporter: func(jaeger.Transport) jaeger.Reporter {
+			return closeCountingReporter{&closed}
+		},
+	})
+	if err == nil {
+		t.Fatal("Configure succeeded with an OTLP file in a missing directory")
+	}
+	if n := atomic.LoadInt32(&closed); n != 1 {
+		t.Errorf("got the collector reporter closed %d times, want once", n)
+	}
+	if _, ok := ot.GlobalTracer().(ot.NoopTracer); !ok {
+		t.Errorf("got global tracer %T installed by a failed Configure", ot.GlobalTracer())
+	}
+	if atomic.LoadInt32(&logSpans) != 0 {
+		t.Error("span logging turned on by a failed Configure")
+	}
+}
+
+func TestWarnIfAgentPort(t *testing.T) {
+	for url, want := range map[string]bool{
+		"http://jaeger-agent:6831/api/traces":      true,
+		"http://jaeger-agent:6832":                 true,
+		"http://jaeger-collector:14268/api/traces": false,
+		"http://jaeger-collector/api/traces":       false,
+		"":                                         false,
+	} {
+		before := glog.Stats.Warning.Lines()
+		warnIfAgentPort(url)
+		if warned := glog.Stats.Warning.Lines() > before; warned != want {
+			t.Errorf("%q: warned %t, want %t", url, warned, want)
+		}
+	}
+
+	// a warning, not an error
+	closer, err := Configure("svc", &Options{JaegerURL: "http://127.0.0.1:6831/api/traces"})
+	if err != nil {
+		t.Fatalf("Configure with an agent port: %v", err)
+	}
+	closer.Close()
+}
@@ -0,0 +1,228 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+var (
+	// bounds of the backoff between attempts to connect to the collector
+	collectorConnectMinBackoff = time.Second
+	collectorConnectMaxBackoff = time.Minute
+)
+
+// maximum number of spans buffered until the collector is reachable
+const pendingSpansLimit = 1000
+
+// connectingTransport buffers spans until the collector at addr accepts a
+// connection, which is tried in the background right away and then retried
+// with an exponential backoff. The buffer is handed to the wrapped transport
+// on its first use once the collector is reachable, so that the transport is
+// only ever used from the reporter's goroutine.
+type connectingTransport struct {
+	jaeger.Transport
+	addr  string
+	done  chan struct{}
+	tried chan struct{} // closed once the first attempt is over
+
+	mu        sync.Mutex
+	reachable bool
+	connected bool
+	pending   []*jaeger.Span
+	dropped   int
+}
+
+// newConnectingTransport wraps trans to buffer spans until the collector at
+// collectorURL can be connected to. It doesn't wait for the collector, so
+// that Configure doesn't block on an unreachable one.
+func newConnectingTransport(trans jaeger.Transport, collectorURL string) (jaeger.Transport, error) {
+	addr, err := collectorAddr(collectorURL)
+	if err != nil {
+		return nil, err
+	}
+	t := &connectingTransport{
+		Transport: trans,
+		addr:      addr,
+		done:      make(chan struct{}),
+		tried:     make(chan struct{}),
+	}
+	go t.connect()
+	return t, nil
+}
+
+// VerifyConnectivity checks that the Zipkin, Jaeger and Datadog collectors
+// configured by options accept TCP connections, e.g. to fail fast at startup
+// when the collector is misconfigured, as Configure succeeds regardless. It
+// returns an error naming the first unreachable collector, and nil if none
+// is configured. ctx bounds the time spent checking.
+func VerifyConnectivity(ctx context.Context, options *Options) error {
+	collectors := []struct{ name, url string }{
+		{"zipkin", options.ZipkinURL},
+		{"jaeger", options.JaegerURL},
+		{"datadog", options.DatadogAgentURL},
+	}
+	var dialer net.Dialer
+	for _, c := range collectors {
+		if c.url == "" {
+			continue
+		}
+		addr, err := collectorAddr(c.url)
+		if err != nil {
+			return fmt.Errorf("invalid %s collector URL %s: %v", c.name, c.url, err)
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return fmt.Errorf("%s collector at %s is unreachable: %v", c.name, addr, err)
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+// collectorAddr returns the host:port the collector at collectorURL listens on.
+func collectorAddr(collectorURL string) (string, error) {
+	u, err := url.Parse(collectorURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	}
+	return net.JoinHostPort(u.Hostname(), "80"), nil
+}
+
+func (t *connectingTransport) connect() {
+	backoff := collectorConnectMinBackoff
+	for attempt := 1; ; attempt++ {
+		conn, err := net.DialTimeout("tcp", t.addr, httpTimeout)
+		if err == nil {
+			conn.Close()
+			if attempt > 1 {
+				glog.Infof("Trace collector at %s is reachable, sending buffered spans", t.addr)
+			}
+			t.mu.Lock()
+			t.reachable = true
+			t.mu.Unlock()
+		} else if attempt == 1 {
+			glog.Warningf("Trace collector at %s is unreachable, buffering spans until it is: %v", t.addr, err)
+		} else {
+			glog.V(2).Infof("Trace collector at %s is still unreachable: %v", t.addr, err)
+		}
+		if attempt == 1 {
+			close(t.tried)
+		}
+		if err == nil {
+			return
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-t.done:
+			timer.Stop()
+			return
+		}
+		backoff *= 2
+		if backoff > collectorConnectMaxBackoff {
+			backoff = collectorConnectMaxBackoff
+		}
+	}
+}
+
+// ready returns whether spans can be sent through the wrapped transport,
+// handing it the buffered spans first if the collector just became reachable.
+func (t *connectingTransport) ready() (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.connected || !t.reachable {
+		return t.connected, nil
+	}
+
+	t.connected = true
+	if t.dropped > 0 {
+		glog.Warningf("Dropped %d spans while the trace collector at %s was unreachable", t.dropped, t.addr)
+	}
+	var firstErr error
+	for _, span := range t.pending {
+		if _, err := t.Transport.Append(span); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		span.Release()
+	}
+	t.pending = nil
+	return true, firstErr
+}
+
+// Append implements the Append() method of jaeger.Transport.
+func (t *connectingTransport) Append(span *jaeger.Span) (int, error) {
+	if ok, drainErr := t.ready(); ok {
+		n, err := t.Transport.Append(span)
+		if err == nil {
+			err = drainErr
+		}
+		return n, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) < pendingSpansLimit {
+		span.Retain()
+		t.pending = append(t.pending, span)
+	} else {
+		t.dropped++
+	}
+	return 0, nil
+}
+
+// Flush implements the Flush() method of jaeger.Transport.
+func (t *connectingTransport) Flush() (int, error) {
+	if ok, err := t.ready(); !ok || err != nil {
+		return 0, err
+	}
+	return t.Transport.Flush()
+}
+
+// Close implements the Close() method of jaeger.Transport.
+func (t *connectingTransport) Close() error {
+	close(t.done)
+	// a reachable collector gets the spans of short-lived processes too
+	<-t.tried
+	if ok, _ := t.ready(); ok {
+		t.Transport.Flush()
+	}
+
+	t.mu.Lock()
+	if !t.connected && len(t.pending) > 0 {
+		glog.Warningf("Dropping %d spans buffered for the unreachable trace collector at %s", len(t.pending)+t.dropped, t.addr)
+		for _, span := range t.pending {
+			span.Release()
+		}
+		t.pending = nil
+	}
+	t.mu.Unlock()
+	return t.Transport.Close()
+}
@@ -0,0 +1,170 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// recordingTransport is a jaeger.Transport recording the operation names of
+// the spans it is given.
+type recordingTransport struct {
+	mu       sync.Mutex
+	appended []string
+	flushes  int
+}
+
+func (t *recordingTransport) Append(span *jaeger.Span) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.appended = append(t.appended, span.OperationName())
+	return 0, nil
+}
+
+func (t *recordingTransport) Flush() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.flushes++
+	return len(t.appended), nil
+}
+
+func (t *recordingTransport) Close() error { return nil }
+
+func (t *recordingTransport) spans() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.appended...)
+}
+
+func finishedSpan(operation string) *jaeger.Span {
+	tracer, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	span := tracer.StartSpan(operation)
+	span.Finish()
+	return span.(*jaeger.Span)
+}
+
+func TestConnectingTransportReachable(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	inner := &recordingTransport{}
+	trans, err := newConnectingTransport(inner, "http://"+lis.Addr().String()+"/api/traces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trans.Append(finishedSpan("op"))
+	trans.Close()
+	if got := inner.spans(); len(got) != 1 || got[0] != "op" {
+		t.Errorf("got spans %q, want the one appended before Close", got)
+	}
+}
+
+func TestConnectingTransportRetries(t *testing.T) {
+	defer func(min, max time.Duration) {
+		collectorConnectMinBackoff, collectorConnectMaxBackoff = min, max
+	}(collectorConnectMinBackoff, collectorConnectMaxBackoff)
+	collectorConnectMinBackoff, collectorConnectMaxBackoff = 10*time.Millisecond, 20*time.Millisecond
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	inner := &recordingTransport{}
+	trans, err := newConnectingTransport(inner, "http://"+addr+"/api/traces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trans.Close()
+	trans.Append(finishedSpan("buffered"))
+	trans.Flush()
+	if got := inner.spans(); len(got) != 0 {
+		t.Fatalf("got spans %q sent to an unreachable collector", got)
+	}
+
+	if lis, err = net.Listen("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	deadline := time.Now().Add(time.Second)
+	for len(inner.spans()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		trans.Flush()
+	}
+	if got := inner.spans(); len(got) != 1 || got[0] != "buffered" {
+		t.Errorf("got spans %q once the collector is reachable, want the buffered one", got)
+	}
+}
+
+func TestVerifyConnectivity(t *testing.T) {
+	reachable, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reachable.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := closed.Addr().String()
+	closed.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, tt := range []struct {
+		name    string
+		options Options
+		want    string
+	}{
+		{"none", Options{}, ""},
+		{"reachable", Options{JaegerURL: "http://" + reachable.Addr().String() + "/api/traces"}, ""},
+		{"unreachable", Options{ZipkinURL: "http://" + unreachable + "/api/v1/spans"}, "zipkin collector at " + unreachable},
+		{"datadog", Options{JaegerURL: "http://" + reachable.Addr().String(), DatadogAgentURL: "http://" + unreachable}, "datadog collector"},
+		{"invalid", Options{JaegerURL: "http://[::1"}, "invalid jaeger collector URL"},
+	} {
+		err := VerifyConnectivity(ctx, &tt.options)
+		if tt.want == "" && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s: got %v, want an error about the %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCollectorAddr(t *testing.T) {
+	for collectorURL, want := range map[string]string{
+		"http://jaeger:14268/api/traces": "jaeger:14268",
+		"http://zipkin/api/v1/spans":     "zipkin:80",
+		"https://collector.example.com":  "collector.example.com:443",
+	} {
+		if got, err := collectorAddr(collectorURL); err != nil || got != want {
+			t.Errorf("collectorAddr(%q) = %q, %v, want %q", collectorURL, got, err, want)
+		}
+	}
+}
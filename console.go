@@ -0,0 +1,130 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// indirection for testing
+var consoleOutput io.Writer = os.Stdout
+
+// consoleReporter prints a compact, human readable line for every finished
+// span. It is meant for local development without any collector, so unlike
+// spanLogger its output is not mixed into the application's logs.
+//
+// The reporter doubles as a jaeger.ContribObserver so that it can record the
+// depth of each span when it is started; children finish before their
+// parents, so the depth can't be derived once spans are reported.
+type consoleReporter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	depths map[jaeger.SpanID]int
+}
+
+func newConsoleReporter(out io.Writer) *consoleReporter {
+	return &consoleReporter{
+		out:    out,
+		depths: make(map[jaeger.SpanID]int),
+	}
+}
+
+// OnStartSpan implements the OnStartSpan() method of jaeger.ContribObserver.
+func (r *consoleReporter) OnStartSpan(sp ot.Span, operationName string, options ot.StartSpanOptions) (jaeger.ContribSpanObserver, bool) {
+	sc, ok := sp.Context().(jaeger.SpanContext)
+	if !ok {
+		return nil, false
+	}
+	r.mu.Lock()
+	depth := 0
+	if d, ok := r.depths[sc.ParentID()]; ok {
+		depth = d + 1
+	}
+	r.depths[sc.SpanID()] = depth
+	r.mu.Unlock()
+	return consoleSpanObserver{r, sp}, true
+}
+
+type consoleSpanObserver struct {
+	r  *consoleReporter
+	sp ot.Span
+}
+
+func (consoleSpanObserver) OnSetOperationName(operationName string) {}
+
+func (consoleSpanObserver) OnSetTag(key string, value interface{}) {}
+
+// OnFinish forgets the depth of spans which will never be reported.
+func (o consoleSpanObserver) OnFinish(options ot.FinishOptions) {
+	if sc, ok := o.sp.Context().(jaeger.SpanContext); ok && !sc.IsSampled() {
+		o.r.mu.Lock()
+		delete(o.r.depths, sc.SpanID())
+		o.r.mu.Unlock()
+	}
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *consoleReporter) Report(span *jaeger.Span) {
+	sc := span.SpanContext()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	depth := r.depths[sc.SpanID()]
+	delete(r.depths, sc.SpanID())
+	io.WriteString(r.out, formatConsoleSpan(span, depth))
+}
+
+// Close implements the Close() method of jaeger.Reporter.
+func (r *consoleReporter) Close() {}
+
+// formatConsoleSpan renders a span as a single line indented by depth,
+// followed by one line per log record. Tags and log fields are sorted so the
+// output is stable.
+func formatConsoleSpan(span *jaeger.Span, depth int) string {
+	sc := span.SpanContext()
+	indent := strings.Repeat("  ", depth)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s%s trace=%s span=%s parent=%s duration=%v",
+		indent, span.OperationName(), sc.TraceID(), sc.SpanID(), sc.ParentID(), span.Duration())
+	tags := span.Tags()
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, tags[k])
+	}
+	b.WriteString("\n")
+
+	for _, l := range span.Logs() {
+		fmt.Fprintf(&b, "%s  log:", indent)
+		for _, f := range l.Fields {
+			fmt.Fprintf(&b, " %s=%v", f.Key(), f.Value())
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
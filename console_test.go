@@ -0,0 +1,54 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestConsoleReporter(t *testing.T) {
+	var out bytes.Buffer
+	console := newConsoleReporter(&out)
+	var id uint64
+	tracer, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(true), console,
+		jaeger.TracerOptions.ContribObserver(console),
+		jaeger.TracerOptions.RandomNumber(func() uint64 { id++; return id }))
+	defer closer.Close()
+	id = 0 // the tracer draws a number of its own when created
+
+	start := time.Unix(1500000000, 0)
+	root := tracer.StartSpan("root", ot.StartTime(start))
+	child := tracer.StartSpan("child", ot.ChildOf(root.Context()), ot.StartTime(start))
+	child.SetTag("b", 2)
+	child.SetTag("a", "1")
+	child.LogKV("event", "cache miss")
+	child.FinishWithOptions(ot.FinishOptions{FinishTime: start.Add(time.Millisecond)})
+	root.FinishWithOptions(ot.FinishOptions{FinishTime: start.Add(3 * time.Millisecond)})
+
+	want := "  child trace=1 span=2 parent=1 duration=1ms a=1 b=2\n" +
+		"    log: event=cache miss\n" +
+		"root trace=1 span=1 parent=0 duration=3ms sampler.param=true sampler.type=const\n"
+	if got := out.String(); got != want {
+		t.Errorf("got output\n%s\nwant\n%s", got, want)
+	}
+	if len(console.depths) != 0 {
+		t.Errorf("depths of reported spans weren't forgotten: %v", console.depths)
+	}
+}
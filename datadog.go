@@ -0,0 +1,177 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/opentracing/opentracing-go/ext"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// path of the traces endpoint of the Datadog agent accepting JSON
+const datadogTracesPath = "/v0.3/traces"
+
+// number of spans buffered by datadogTransport before it flushes on its own
+const datadogBatchSize = 100
+
+// tag naming the resource of a Datadog span, which defaults to the operation
+// name
+const datadogResourceTag = "resource.name"
+
+// datadogSpan is a span in the format of the traces endpoint of the Datadog
+// agent.
+type datadogSpan struct {
+	TraceID  uint64             `json:"trace_id"`
+	SpanID   uint64             `json:"span_id"`
+	ParentID uint64             `json:"parent_id"`
+	Name     string             `json:"name"`
+	Resource string             `json:"resource"`
+	Service  string             `json:"service"`
+	Type     string             `json:"type"`
+	Start    int64              `json:"start"`
+	Duration int64              `json:"duration"`
+	Error    int32              `json:"error"`
+	Meta     map[string]string  `json:"meta,omitempty"`
+	Metrics  map[string]float64 `json:"metrics,omitempty"`
+}
+
+// toDatadogSpan converts span to the Datadog span model. Datadog trace IDs
+// are 64 bits, so only the low half of 128 bit trace IDs is kept. Numeric tags
+// become metrics and the others meta.
+func toDatadogSpan(serviceName string, span *jaeger.Span) datadogSpan {
+	sc := span.SpanContext()
+	ds := datadogSpan{
+		TraceID:  sc.TraceID().Low,
+		SpanID:   uint64(sc.SpanID()),
+		ParentID: uint64(sc.ParentID()),
+		Name:     span.OperationName(),
+		Resource: span.OperationName(),
+		Service:  serviceName,
+		Type:     "custom",
+		Start:    span.StartTime().UnixNano(),
+		Duration: span.Duration().Nanoseconds(),
+		Meta:     make(map[string]string),
+		Metrics:  make(map[string]float64),
+	}
+	tags := span.Tags()
+	for k, v := range tags {
+		if k == string(ext.Error) {
+			if isErr, ok := v.(bool); ok && isErr {
+				ds.Error = 1
+			}
+			continue
+		}
+		if k == datadogResourceTag {
+			ds.Resource = fmt.Sprint(v)
+			continue
+		}
+		if f, ok := numeric(v); ok {
+			ds.Metrics[k] = f
+		} else {
+			ds.Meta[k] = fmt.Sprint(v)
+		}
+	}
+	switch {
+	case tags[string(ext.SpanKind)] == ext.SpanKindRPCServerEnum:
+		ds.Type = "web"
+	case tags[string(ext.DBType)] == "sql":
+		ds.Type = "sql"
+	case tags[string(ext.HTTPMethod)] != nil:
+		ds.Type = "http"
+	}
+	return ds
+}
+
+// datadogTransport is a jaeger.Transport sending spans to the traces
+// endpoint of a Datadog agent.
+type datadogTransport struct {
+	url         string
+	serviceName string
+	client      *http.Client
+
+	spans []datadogSpan
+}
+
+func newDatadogTransport(agentURL, serviceName string, roundTripper http.RoundTripper) *datadogTransport {
+	return &datadogTransport{
+		url:         strings.TrimSuffix(agentURL, "/") + datadogTracesPath,
+		serviceName: serviceName,
+		client:      &http.Client{Transport: roundTripper, Timeout: httpTimeout},
+	}
+}
+
+// Append implements the Append() method of jaeger.Transport.
+func (t *datadogTransport) Append(span *jaeger.Span) (int, error) {
+	t.spans = append(t.spans, toDatadogSpan(t.serviceName, span))
+	if len(t.spans) >= datadogBatchSize {
+		return t.Flush()
+	}
+	return 0, nil
+}
+
+// Flush implements the Flush() method of jaeger.Transport. Spans are grouped
+// by trace, as the agent expects.
+func (t *datadogTransport) Flush() (int, error) {
+	n := len(t.spans)
+	if n == 0 {
+		return 0, nil
+	}
+	var traces [][]datadogSpan
+	index := make(map[uint64]int)
+	for _, span := range t.spans {
+		i, ok := index[span.TraceID]
+		if !ok {
+			i = len(traces)
+			index[span.TraceID] = i
+			traces = append(traces, nil)
+		}
+		traces[i] = append(traces[i], span)
+	}
+	t.spans = t.spans[:0]
+
+	body, err := json.Marshal(traces)
+	if err != nil {
+		return n, err
+	}
+	req, err := http.NewRequest(http.MethodPut, t.url, bytes.NewReader(body))
+	if err != nil {
+		return n, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Datadog-Trace-Count", fmt.Sprint(len(traces)))
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return n, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return n, fmt.Errorf("error from datadog agent: %s", resp.Status)
+	}
+	return n, nil
+}
+
+// Close implements the Close() method of jaeger.Transport.
+func (t *datadogTransport) Close() error {
+	_, err := t.Flush()
+	return err
+}
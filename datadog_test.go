@@ -0,0 +1,83 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+func TestDatadogAgentURL(t *testing.T) {
+	var method, path, traceCount string
+	var traces [][]datadogSpan
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, traceCount = r.Method, r.URL.Path, r.Header.Get("X-Datadog-Trace-Count")
+		if err := json.NewDecoder(r.Body).Decode(&traces); err != nil {
+			t.Errorf("decoding the traces: %v", err)
+		}
+	}))
+	defer agent.Close()
+
+	closer, err := Configure("svc", &Options{
+		DatadogAgentURL: agent.URL,
+		Propagation:     PropagationB3,
+		SamplerType:     "const",
+		SamplerParam:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, ctx := StartSpan(context.Background(), "GET /users", ext.SpanKindRPCServer)
+	root.SetTag(datadogResourceTag, "GET /users/{id}")
+	child, _ := StartSpan(ctx, "query")
+	ext.DBType.Set(child, "sql")
+	ext.Error.Set(child, true)
+	child.SetTag("rows", 3)
+	child.Finish()
+	root.Finish()
+	closer.Close()
+
+	if method != http.MethodPut || path != datadogTracesPath || traceCount != "1" {
+		t.Errorf("got %s %s with trace count %q", method, path, traceCount)
+	}
+	if len(traces) != 1 || len(traces[0]) != 2 {
+		t.Fatalf("got traces %+v, want one with both spans", traces)
+	}
+	spans := map[string]datadogSpan{}
+	for _, span := range traces[0] {
+		if span.Service != "svc" {
+			t.Errorf("%s: got service %q", span.Name, span.Service)
+		}
+		spans[span.Name] = span
+	}
+	server, query := spans["GET /users"], spans["query"]
+	if server.Resource != "GET /users/{id}" || server.Type != "web" || server.Error != 0 {
+		t.Errorf("got server span %+v", server)
+	}
+	if query.Resource != "query" || query.Type != "sql" || query.Error != 1 {
+		t.Errorf("got query span %+v", query)
+	}
+	if query.TraceID != server.TraceID || query.ParentID != server.SpanID {
+		t.Errorf("query span %+v isn't a child of %+v", query, server)
+	}
+	if query.Metrics["rows"] != 3 || query.Meta[string(ext.DBType)] != "sql" {
+		t.Errorf("got metrics %v and meta %v", query.Metrics, query.Meta)
+	}
+}
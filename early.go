@@ -0,0 +1,248 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// maximum number of spans buffered by BufferEarlySpans
+const earlySpansLimit = 1000
+
+var (
+	earlyMu sync.Mutex
+	early   *earlyReporter
+)
+
+// BufferEarlySpans installs a temporary global tracer which records spans
+// started before Configure, e.g. by libraries during init, instead of losing
+// them to the no-op tracer. Configure replays them into the tracer it
+// installs, and spans of the temporary tracer finished later on are sent
+// there too. The traces started by the temporary tracer are sampled again by
+// the installed tracer, and dropped unless it samples them. If Configure
+// leaves tracing disabled, the spans are discarded.
+//
+// At most 1000 spans are buffered. It does nothing if a tracer is already
+// installed.
+func BufferEarlySpans() {
+	earlyMu.Lock()
+	defer earlyMu.Unlock()
+	if _, isNoop := ot.GlobalTracer().(ot.NoopTracer); early != nil || !isNoop {
+		return
+	}
+
+	r := &earlyReporter{traces: make(map[jaeger.TraceID]earlyDecision)}
+	r.tracer, _ = jaeger.NewTracer("early", earlySampler{r: r}, r, poolSpans)
+	early = r
+	ot.SetGlobalTracer(r.tracer)
+}
+
+// isEarlyTracer returns whether t was installed by BufferEarlySpans.
+func isEarlyTracer(t ot.Tracer) bool {
+	earlyMu.Lock()
+	defer earlyMu.Unlock()
+	return early != nil && early.tracer == t
+}
+
+// takeEarlyReporter returns the reporter installed by BufferEarlySpans, if
+// any, and uninstalls it.
+func takeEarlyReporter() *earlyReporter {
+	earlyMu.Lock()
+	defer earlyMu.Unlock()
+	r := early
+	early = nil
+	return r
+}
+
+// replayEarlySpans sends the spans buffered by BufferEarlySpans to target.
+func replayEarlySpans(target ot.Tracer) {
+	if r := takeEarlyReporter(); r != nil {
+		r.replayTo(target)
+	}
+}
+
+// discardEarlySpans drops the spans buffered by BufferEarlySpans and
+// uninstalls its tracer.
+func discardEarlySpans() {
+	r := takeEarlyReporter()
+	if r == nil {
+		return
+	}
+	if ot.GlobalTracer() == r.tracer {
+		ot.SetGlobalTracer(ot.NoopTracer{})
+	}
+	r.replayTo(ot.NoopTracer{})
+}
+
+// earlyReporter buffers the spans of the tracer installed by
+// BufferEarlySpans until it is given a tracer to replay them into.
+type earlyReporter struct {
+	tracer ot.Tracer
+
+	mu      sync.Mutex
+	spans   []*jaeger.Span
+	dropped int
+	target  ot.Tracer
+
+	// traces started by tracer, by trace ID, with the decision of target
+	tracesMu sync.Mutex
+	traces   map[jaeger.TraceID]earlyDecision
+}
+
+// earlyDecision is the sampling decision of the tracer installed by
+// Configure for a trace started by the tracer of BufferEarlySpans.
+type earlyDecision struct {
+	sampled bool
+	final   bool
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *earlyReporter) Report(span *jaeger.Span) {
+	r.mu.Lock()
+	if target := r.target; target != nil {
+		r.mu.Unlock()
+		r.replay(target, span)
+		return
+	}
+	defer r.mu.Unlock()
+	if len(r.spans) < earlySpansLimit {
+		span.Retain()
+		r.spans = append(r.spans, span)
+	} else {
+		r.dropped++
+	}
+}
+
+// Close implements the Close() method of jaeger.Reporter.
+func (r *earlyReporter) Close() {}
+
+func (r *earlyReporter) replayTo(target ot.Tracer) {
+	r.mu.Lock()
+	r.target = target
+	spans, dropped := r.spans, r.dropped
+	r.spans = nil
+	r.mu.Unlock()
+
+	if dropped > 0 {
+		glog.Warningf("Dropped %d spans started before tracing was configured", dropped)
+	}
+	// replay root spans first, so that the sampler decides on them and tags
+	// them, as it would have if it had started them
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].SpanContext().ParentID() == 0 && spans[j].SpanContext().ParentID() != 0
+	})
+	for _, span := range spans {
+		r.replay(target, span)
+		span.Release()
+	}
+}
+
+// replay records span again with target. Spans of traces started by the
+// tracer of BufferEarlySpans are sampled by target, until it samples their
+// trace or finalizes its decision not to, rather than by the const sampler
+// of that tracer. Spans of traces sampled upstream are kept as they are.
+func (r *earlyReporter) replay(target ot.Tracer, span *jaeger.Span) {
+	sc := span.SpanContext()
+	r.tracesMu.Lock()
+	defer r.tracesMu.Unlock()
+	decision, local := r.traces[sc.TraceID()]
+	switch {
+	case !local || decision.sampled:
+		replaySpan(target, span, sc)
+	case !decision.final:
+		baggage := make(map[string]string)
+		sc.ForeachBaggageItem(func(k, v string) bool {
+			baggage[k] = v
+			return true
+		})
+		unsampled := jaeger.NewSpanContext(sc.TraceID(), sc.SpanID(), sc.ParentID(), false, baggage)
+		decision.sampled, decision.final = replaySpan(target, span, unsampled)
+		r.traces[sc.TraceID()] = decision
+	}
+}
+
+// replaySpan records span again with target under the identity of sc,
+// keeping its timing, and returns the sampling state of the replayed span.
+func replaySpan(target ot.Tracer, span *jaeger.Span, sc jaeger.SpanContext) (sampled, final bool) {
+	opts := []ot.StartSpanOption{
+		jaeger.SelfRef(sc),
+		ot.StartTime(span.StartTime()),
+		ot.Tags(span.Tags()),
+	}
+	for _, ref := range span.References() {
+		opts = append(opts, ref)
+	}
+	replayed := target.StartSpan(span.OperationName(), opts...)
+	if js, ok := replayed.(*jaeger.Span); ok {
+		js.Retain()
+		defer func() {
+			replayedContext := js.SpanContext()
+			sampled, final = replayedContext.IsSampled(), replayedContext.IsSamplingFinalized()
+			js.Release()
+		}()
+	}
+	replayed.FinishWithOptions(ot.FinishOptions{
+		FinishTime: span.StartTime().Add(span.Duration()),
+		LogRecords: span.Logs(),
+	})
+	return true, true
+}
+
+// earlySampler samples every trace started by the tracer of
+// BufferEarlySpans, without tagging the spans, and records them for
+// earlyReporter to sample again once the real sampler is configured.
+type earlySampler struct {
+	jaeger.SamplerV2Base
+	r *earlyReporter
+}
+
+// OnCreateSpan implements the OnCreateSpan() method of jaeger.SamplerV2. It
+// is only called for the root span of a new trace.
+func (s earlySampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	if sc := span.SpanContext(); !sc.IsDebug() {
+		s.r.tracesMu.Lock()
+		if len(s.r.traces) >= earlySpansLimit {
+			// forget an older trace, whose spans are then kept as they are
+			for id := range s.r.traces {
+				delete(s.r.traces, id)
+				break
+			}
+		}
+		s.r.traces[sc.TraceID()] = earlyDecision{}
+		s.r.tracesMu.Unlock()
+	}
+	return jaeger.SamplingDecision{Sample: true}
+}
+
+// OnSetOperationName implements the OnSetOperationName() method of
+// jaeger.SamplerV2.
+func (s earlySampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	return jaeger.SamplingDecision{Sample: true}
+}
+
+// OnSetTag implements the OnSetTag() method of jaeger.SamplerV2.
+func (s earlySampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	return jaeger.SamplingDecision{Sample: true}
+}
+
+// OnFinishSpan implements the OnFinishSpan() method of jaeger.SamplerV2.
+func (s earlySampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return jaeger.SamplingDecision{Sample: true}
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"testing"
+
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// bufferEarlyTrace buffers a trace of two spans with BufferEarlySpans, the
+// child of a remote parent if any, and returns the early tracer.
+func bufferEarlyTrace(t *testing.T, parent ot.SpanContext) ot.Tracer {
+	t.Helper()
+	BufferEarlySpans()
+	tracer := ot.GlobalTracer()
+	if !isEarlyTracer(tracer) {
+		t.Fatal("BufferEarlySpans didn't install its tracer")
+	}
+	var opts []ot.StartSpanOption
+	if parent != nil {
+		opts = append(opts, ot.ChildOf(parent))
+	}
+	root := tracer.StartSpan("early-root", opts...)
+	tracer.StartSpan("early-child", ot.ChildOf(root.Context())).Finish()
+	root.Finish()
+	return tracer
+}
+
+// replayEarlyTo replays the buffered spans into a tracer using sampler, and
+// returns the spans it reported.
+func replayEarlyTo(sampler jaeger.Sampler) []*jaeger.Span {
+	defer ot.SetGlobalTracer(ot.NoopTracer{})
+	reporter := jaeger.NewInMemoryReporter()
+	target, closer := jaeger.NewTracer("svc", sampler, reporter)
+	defer closer.Close()
+	replayEarlySpans(target)
+
+	var spans []*jaeger.Span
+	for _, span := range reporter.GetSpans() {
+		spans = append(spans, span.(*jaeger.Span))
+	}
+	return spans
+}
+
+func TestBufferEarlySpansSampledByConfiguredSampler(t *testing.T) {
+	bufferEarlyTrace(t, nil)
+	spans := replayEarlyTo(jaeger.NewConstSampler(false))
+	if len(spans) != 0 {
+		t.Errorf("got %d spans of a trace the configured sampler doesn't sample", len(spans))
+	}
+
+	bufferEarlyTrace(t, nil)
+	sampler, _ := jaeger.NewProbabilisticSampler(1)
+	spans = replayEarlyTo(sampler)
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	root, child := spans[0], spans[1]
+	if root.OperationName() != "early-root" {
+		root, child = child, root
+	}
+	if child.SpanContext().ParentID() != root.SpanContext().SpanID() {
+		t.Errorf("replayed spans lost their identity: %v, %v", child.SpanContext(), root.SpanContext())
+	}
+	if got := root.Tags()["sampler.type"]; got != "probabilistic" {
+		t.Errorf("got root sampler.type %v, want the one of the configured sampler", got)
+	}
+	if _, ok := child.Tags()["sampler.type"]; ok {
+		t.Errorf("child span tagged by a sampler: %v", child.Tags())
+	}
+}
+
+func TestBufferEarlySpansKeepsUpstreamDecision(t *testing.T) {
+	upstream, closer := jaeger.NewTracer("upstream", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	parent := upstream.StartSpan("upstream")
+
+	bufferEarlyTrace(t, parent.Context())
+	spans := replayEarlyTo(jaeger.NewConstSampler(false))
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans of a trace sampled upstream, want 2", len(spans))
+	}
+	for _, span := range spans {
+		if span.SpanContext().TraceID() != parent.Context().(jaeger.SpanContext).TraceID() {
+			t.Errorf("span %q left the upstream trace", span.OperationName())
+		}
+	}
+}
+
+func TestBufferEarlySpansAfterReplay(t *testing.T) {
+	early := bufferEarlyTrace(t, nil)
+	defer ot.SetGlobalTracer(ot.NoopTracer{})
+	reporter := jaeger.NewInMemoryReporter()
+	target, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	replayEarlySpans(target)
+
+	early.StartSpan("late").Finish()
+	if n := reporter.SpansSubmitted(); n != 3 {
+		t.Errorf("got %d spans, want the 2 buffered and the late one", n)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+var (
+	// number of consecutive failures of the primary collector after which
+	// spans are sent to the fallback
+	failoverThreshold = 3
+
+	// bounds of the time spent on the fallback before the primary is tried
+	// again; it doubles every time the primary is still failing
+	failoverMinBackoff = 30 * time.Second
+	failoverMaxBackoff = 5 * time.Minute
+)
+
+// failoverTransport sends spans through the primary transport until it fails
+// failoverThreshold times in a row, then through the fallback. After a
+// backoff, the next batch of spans is sent through the primary again to check
+// its health; it is used from then on if that succeeds, and the fallback for
+// a longer backoff otherwise.
+//
+// Failover happens below the remote reporter so that its queue keeps feeding
+// whichever collector is healthy.
+type failoverTransport struct {
+	primary  jaeger.Transport
+	fallback jaeger.Transport
+	now      func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	backoff  time.Duration // non-zero while failed over
+	retryAt  time.Time
+}
+
+func newFailoverTransport(primary, fallback jaeger.Transport) *failoverTransport {
+	return &failoverTransport{
+		primary:  primary,
+		fallback: fallback,
+		now:      time.Now,
+	}
+}
+
+// current returns the transport spans should be sent through.
+func (t *failoverTransport) current() jaeger.Transport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.backoff == 0 || !t.now().Before(t.retryAt) {
+		return t.primary
+	}
+	return t.fallback
+}
+
+// record updates the health of the primary with the outcome of sending spans
+// through trans. Calls which only buffered spans say nothing about it.
+func (t *failoverTransport) record(trans jaeger.Transport, flushed int, err error) {
+	if trans != t.primary || (flushed == 0 && err == nil) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil {
+		if t.backoff != 0 {
+			glog.Infof("Trace collector recovered, no longer sending spans to the fallback")
+		}
+		t.failures = 0
+		t.backoff = 0
+		return
+	}
+
+	t.failures++
+	switch {
+	case t.backoff != 0:
+		t.backoff *= 2
+		if t.backoff > failoverMaxBackoff {
+			t.backoff = failoverMaxBackoff
+		}
+	case t.failures >= failoverThreshold:
+		glog.Warningf("Trace collector failed %d times in a row, sending spans to the fallback: %v", t.failures, err)
+		t.backoff = failoverMinBackoff
+	default:
+		return
+	}
+	t.retryAt = t.now().Add(t.backoff)
+}
+
+// Append implements the Append() method of jaeger.Transport.
+func (t *failoverTransport) Append(span *jaeger.Span) (int, error) {
+	trans := t.current()
+	n, err := trans.Append(span)
+	t.record(trans, n, err)
+	return n, err
+}
+
+// Flush implements the Flush() method of jaeger.Transport. Spans left in the
+// fallback's buffer when switching back to the primary are flushed along
+// with the primary's.
+func (t *failoverTransport) Flush() (int, error) {
+	trans := t.current()
+	n, err := trans.Flush()
+	t.record(trans, n, err)
+	if trans == t.primary {
+		fn, ferr := t.fallback.Flush()
+		n += fn
+		if err == nil {
+			err = ferr
+		}
+	}
+	return n, err
+}
+
+// Close implements the Close() method of jaeger.Transport.
+func (t *failoverTransport) Close() error {
+	err := t.primary.Close()
+	if ferr := t.fallback.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
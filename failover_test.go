@@ -0,0 +1,62 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// countingCollector returns a collector answering every request with status,
+// and counting them in n.
+func countingCollector(status int, n *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(n, 1)
+		w.WriteHeader(status)
+	}))
+}
+
+func TestFlushPrioritySpansFailOver(t *testing.T) {
+	defer func(threshold int) { failoverThreshold = threshold }(failoverThreshold)
+	failoverThreshold = 1
+
+	var primaryRequests, fallbackRequests int32
+	primary := countingCollector(http.StatusInternalServerError, &primaryRequests)
+	defer primary.Close()
+	fallback := countingCollector(http.StatusAccepted, &fallbackRequests)
+	defer fallback.Close()
+	defer configureRecording(t, &Options{
+		JaegerURL:          primary.URL,
+		FallbackJaegerURL:  fallback.URL,
+		FlushPrioritySpans: true,
+	}).Close()
+
+	for i := 0; i < 2; i++ {
+		span, _ := StartSpan(context.Background(), "priority")
+		ext.SamplingPriority.Set(span, 1)
+		span.Finish()
+	}
+	if n := atomic.LoadInt32(&primaryRequests); n != 1 {
+		t.Errorf("got %d uploads to the failing primary collector, want 1", n)
+	}
+	if n := atomic.LoadInt32(&fallbackRequests); n != 1 {
+		t.Errorf("got %d uploads to the fallback collector, want 1", n)
+	}
+}
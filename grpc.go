@@ -0,0 +1,67 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/golang/glog"
+	ot "github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc/metadata"
+)
+
+// metadataCarrier adapts gRPC metadata to the opentracing TextMap carrier
+// interfaces. gRPC lowercases metadata keys, so keys are lowercased when set.
+type metadataCarrier metadata.MD
+
+// Set implements the Set() method of opentracing.TextMapWriter.
+func (c metadataCarrier) Set(key, val string) {
+	key = strings.ToLower(key)
+	c[key] = append(c[key], val)
+}
+
+// ForeachKey implements the ForeachKey() method of opentracing.TextMapReader.
+func (c metadataCarrier) ForeachKey(handler func(key, val string) error) error {
+	for k, vals := range c {
+		for _, v := range vals {
+			if err := handler(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// InjectGRPCMetadata injects the context of the span active in ctx into md,
+// allocating md if it is nil. It does nothing if ctx carries no span.
+func InjectGRPCMetadata(ctx context.Context, md *metadata.MD) {
+	span := ot.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	if *md == nil {
+		*md = metadata.MD{}
+	}
+	if err := span.Tracer().Inject(span.Context(), ot.TextMap, metadataCarrier(*md)); err != nil {
+		glog.Warningf("Could not inject span context into gRPC metadata: %v", err)
+	}
+}
+
+// ExtractGRPCMetadata extracts a span context from md using the propagation
+// format of the tracer of ctx, as set by WithTracer, or of the global tracer.
+func ExtractGRPCMetadata(ctx context.Context, md metadata.MD) (ot.SpanContext, error) {
+	return propagatorFor(ctx).Extract(ot.TextMap, metadataCarrier(md))
+}
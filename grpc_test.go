@@ -0,0 +1,53 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+	zp "github.com/uber/jaeger-client-go/zipkin"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestGRPCMetadataWithTracer(t *testing.T) {
+	defer configureCollector(t, &Options{}).Close()
+
+	b3 := zp.NewZipkinB3HTTPHeaderPropagator()
+	tracer, closer := jaeger.NewTracer("tenant", jaeger.NewConstSampler(true), jaeger.NewNullReporter(),
+		jaeger.TracerOptions.Injector(ot.TextMap, b3), jaeger.TracerOptions.Extractor(ot.TextMap, b3))
+	defer closer.Close()
+	span, ctx := StartSpan(WithTracer(context.Background(), tracer), "call")
+	defer span.Finish()
+	want := span.Context().(jaeger.SpanContext)
+
+	var md metadata.MD
+	InjectGRPCMetadata(ctx, &md)
+	if len(md.Get("x-b3-traceid")) != 1 {
+		t.Fatalf("got metadata %v, want B3 headers", md)
+	}
+	sc, err := ExtractGRPCMetadata(ctx, md)
+	if err != nil {
+		t.Fatalf("ExtractGRPCMetadata(%v): %v", md, err)
+	}
+	if got := sc.(jaeger.SpanContext); got.TraceID() != want.TraceID() || got.SpanID() != want.SpanID() {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := ExtractGRPCMetadata(context.Background(), md); err != ot.ErrSpanContextNotFound {
+		t.Errorf("got %v with the global tracer, want ot.ErrSpanContextNotFound", err)
+	}
+}
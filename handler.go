@@ -0,0 +1,269 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+type tracingHandler struct {
+	next http.Handler
+}
+
+// NewHandler returns an http.Handler which continues the trace found in the
+// headers of each inbound request, if any.
+//
+// A server span is started for every request and made active in the context
+// passed to next, and it is finished once next returns. The span is named by
+// Options.ServerSpanNamer, or by the request method and path by default, and
+// sampled under the name given by Options.SamplingOperationNamer, if any.
+// The trace ID is returned in the Options.TraceIDResponseHeader header, if
+// set, and the sizes of the request and response bodies are tagged when
+// Options.RecordPayloadSizes is set.
+//
+// Requests whose path matches Options.SkipPaths are passed to next without
+// starting any span.
+func NewHandler(next http.Handler) http.Handler {
+	return &tracingHandler{next: next}
+}
+
+// ServeHTTP implements the ServeHTTP() method of http.Handler.
+func (h *tracingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	tracer := tracerFor(req.Context())
+	options := currentOptions()
+	if tracer == nil || skipPath(options.SkipPaths, req.URL.Path) {
+		h.next.ServeHTTP(w, req)
+		return
+	}
+
+	parent, _ := tracer.Extract(ot.HTTPHeaders, ot.HTTPHeadersCarrier(req.Header))
+	ctx, opts := applyForcedSampling(req.Context(), []ot.StartSpanOption{ext.RPCServerOption(parent)})
+	name := serverSpanName(options, req)
+	samplingName := name
+	if options.SamplingOperationNamer != nil {
+		samplingName = options.SamplingOperationNamer(req)
+	}
+	span := tracer.StartSpan(samplingName, opts...)
+	defer func() {
+		renameSampled(span, samplingName, name)
+		span.Finish()
+	}()
+	renameSampled(span, samplingName, name)
+	ext.HTTPMethod.Set(span, req.Method)
+	ext.HTTPUrl.Set(span, urlTag(req.URL, options))
+	if options.TraceIDResponseHeader != "" {
+		if sc, ok := span.Context().(jaeger.SpanContext); ok {
+			w.Header().Set(options.TraceIDResponseHeader, sc.TraceID().String())
+		}
+	}
+
+	ctx = ot.ContextWithSpan(ctx, span)
+	if options.TagDeadlines {
+		TagDeadline(ctx)
+	}
+
+	req = req.WithContext(ctx)
+	var body *countingBody
+	if options.RecordPayloadSizes && req.ContentLength < 0 && req.Body != nil && req.Body != http.NoBody {
+		body = &countingBody{ReadCloser: req.Body}
+		req.Body = body
+	}
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	h.next.ServeHTTP(rec.wrap(), req)
+
+	if options.RecordPayloadSizes {
+		requestSize := req.ContentLength
+		if body != nil {
+			requestSize = body.read
+		} else if requestSize < 0 {
+			requestSize = 0
+		}
+		span.SetTag(requestSizeTag, requestSize)
+		span.SetTag(responseSizeTag, rec.written)
+	}
+	ext.HTTPStatusCode.Set(span, uint16(rec.status))
+	if rec.status >= http.StatusInternalServerError {
+		ext.Error.Set(span, true)
+	}
+}
+
+// skipPath returns whether path matches one of Options.SkipPaths, either
+// exactly or, for those ending in *, by prefix.
+func skipPath(skipPaths []string, path string) bool {
+	for _, skip := range skipPaths {
+		if strings.HasSuffix(skip, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(skip, "*")) {
+				return true
+			}
+		} else if path == skip {
+			return true
+		}
+	}
+	return false
+}
+
+// renameSampled renames span, started under the name its sampling decision
+// was made for, to its display name if it is sampled. Renaming a span which
+// isn't sampled yet would let the sampler decide again for the display name.
+func renameSampled(span ot.Span, samplingName, name string) {
+	if samplingName == name {
+		return
+	}
+	if js, ok := span.(*jaeger.Span); ok && js.SpanContext().IsSampled() && js.OperationName() == samplingName {
+		span.SetOperationName(name)
+	}
+}
+
+// serverSpanName returns the operation name of the server span of req.
+func serverSpanName(options *Options, req *http.Request) string {
+	if options.ServerSpanNamer != nil {
+		return options.ServerSpanNamer(req)
+	}
+	return req.Method + " " + req.URL.Path
+}
+
+// tags recording the body sizes of server spans, see
+// Options.RecordPayloadSizes
+const (
+	requestSizeTag  = "http.request.size"
+	responseSizeTag = "http.response.size"
+)
+
+// countingBody counts the bytes read from a request body of unknown length.
+type countingBody struct {
+	io.ReadCloser
+	read int64
+}
+
+// Read implements the Read() method of io.Reader.
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	return n, err
+}
+
+// statusRecorder records the status code and the number of body bytes
+// written to the wrapped http.ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	written     int64
+}
+
+// WriteHeader implements the WriteHeader() method of http.ResponseWriter.
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements the Write() method of http.ResponseWriter.
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.written += int64(n)
+	return n, err
+}
+
+// Flush implements the Flush() method of http.Flusher. It is only exposed by
+// wrap when the wrapped http.ResponseWriter supports it.
+func (r *statusRecorder) Flush() {
+	r.wroteHeader = true
+	r.ResponseWriter.(http.Flusher).Flush()
+}
+
+// Hijack implements the Hijack() method of http.Hijacker, e.g. for websocket
+// upgrades, recording a 101 status unless one was written already. It is
+// only exposed by wrap when the wrapped http.ResponseWriter supports it.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := r.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil && !r.wroteHeader {
+		r.status = http.StatusSwitchingProtocols
+		r.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Push implements the Push() method of http.Pusher. It is only exposed by wrap
+// when the wrapped http.ResponseWriter supports it.
+func (r *statusRecorder) Push(target string, opts *http.PushOptions) error {
+	return r.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+// wrap returns r as an http.ResponseWriter implementing the optional
+// interfaces among http.Flusher, http.Hijacker and http.Pusher which the
+// wrapped http.ResponseWriter implements, and only those, so that handlers
+// streaming responses or upgrading connections keep working behind
+// NewHandler.
+func (r *statusRecorder) wrap() http.ResponseWriter {
+	_, flusher := r.ResponseWriter.(http.Flusher)
+	_, hijacker := r.ResponseWriter.(http.Hijacker)
+	_, pusher := r.ResponseWriter.(http.Pusher)
+	switch {
+	case flusher && hijacker && pusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{r, r, r, r}
+	case flusher && hijacker:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{r, r, r}
+	case flusher && pusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Pusher
+		}{r, r, r}
+	case hijacker && pusher:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.Pusher
+		}{r, r, r}
+	case flusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+		}{r, r}
+	case hijacker:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+		}{r, r}
+	case pusher:
+		return struct {
+			http.ResponseWriter
+			http.Pusher
+		}{r, r}
+	}
+	return struct{ http.ResponseWriter }{r}
+}
@@ -0,0 +1,316 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestHandlerForwardsFlusher(t *testing.T) {
+	tracer := configureCollector(t, &Options{})
+	defer tracer.Close()
+
+	var flusher, hijacker bool
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hijacker = w.(http.Hijacker)
+		var f http.Flusher
+		if f, flusher = w.(http.Flusher); flusher {
+			w.Write([]byte("partial"))
+			f.Flush()
+		}
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/stream", nil))
+
+	if !flusher {
+		t.Fatal("http.Flusher hidden by NewHandler")
+	}
+	if hijacker {
+		t.Error("http.Hijacker exposed although httptest.ResponseRecorder doesn't implement it")
+	}
+	if !rec.Flushed {
+		t.Error("Flush wasn't forwarded")
+	}
+	if got := tracer.onlySpan(t).Tags["http.status_code"]; got != int64(http.StatusOK) {
+		t.Errorf("got status tag %v, want 200", got)
+	}
+}
+
+func TestHandlerForwardsHijacker(t *testing.T) {
+	tracer := configureCollector(t, &Options{})
+	defer tracer.Close()
+
+	server := httptest.NewServer(NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("http.Hijacker hidden by NewHandler")
+			return
+		}
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		rw.Flush()
+	})))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d, want 101", resp.StatusCode)
+	}
+	// the span is finished once the handler returns, after the response
+	if got := tracer.waitForSpans(t, 1)[0].Tags["http.status_code"]; got != int64(http.StatusSwitchingProtocols) {
+		t.Errorf("got status tag %v, want 101", got)
+	}
+}
+
+// serve passes a request for path through NewHandler.
+func serve(path string) {
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+}
+
+func TestServerSpanNamer(t *testing.T) {
+	tracer := configureCollector(t, &Options{
+		ServerSpanNamer: func(req *http.Request) string {
+			if strings.HasPrefix(req.URL.Path, "/users/") {
+				return req.Method + " /users/{id}"
+			}
+			return req.Method + " " + req.URL.Path
+		},
+	})
+	defer tracer.Close()
+
+	serve("/users/42")
+	if got := tracer.onlySpan(t).Operation; got != "GET /users/{id}" {
+		t.Errorf("got operation %q, want the route template", got)
+	}
+}
+
+func TestServerSpanDefaultName(t *testing.T) {
+	tracer := configureCollector(t, &Options{})
+	defer tracer.Close()
+
+	serve("/users/42")
+	if got := tracer.onlySpan(t).Operation; got != "GET /users/42" {
+		t.Errorf("got operation %q, want the method and path", got)
+	}
+}
+
+func TestSamplingOperationNamer(t *testing.T) {
+	var keys []string
+	defer configureRecording(t, &Options{
+		SamplerType:  "const",
+		SamplerParam: 0,
+		SamplingOperationNamer: func(req *http.Request) string {
+			if strings.HasPrefix(req.URL.Path, "/users/") {
+				return req.Method + " /users/{id}"
+			}
+			return req.Method + " " + req.URL.Path
+		},
+		SamplerDecider: func(operation string, ctx SamplerContext) (bool, bool) {
+			keys = append(keys, operation)
+			return operation == "GET /users/{id}", true
+		},
+	}).Close()
+
+	serve("/users/42")
+	serve("/orders/7")
+	if want := []string{"GET /users/{id}", "GET /orders/7"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got sampling keys %v, want %v", keys, want)
+	}
+	if got := onlyRecentSpan(t).Operation; got != "GET /users/42" {
+		t.Errorf("got operation %q, want the full path", got)
+	}
+}
+
+func TestSamplingOperationNamerREDMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	var sampled bool
+	defer configureRecording(t, &Options{
+		REDMetrics: reg,
+		SamplingOperationNamer: func(req *http.Request) string {
+			return req.Method + " /users/{name}"
+		},
+		// samples every other request
+		SamplerDecider: func(operation string, ctx SamplerContext) (bool, bool) {
+			sampled = !sampled
+			return sampled, true
+		},
+	}).Close()
+
+	serve("/users/alice")
+	serve("/users/bob")
+	if got := onlyRecentSpan(t).Operation; got != "GET /users/alice" {
+		t.Errorf("got operation %q, want the sampled span renamed to its path", got)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := map[string]float64{}
+	for _, f := range families {
+		if f.GetName() != "tracing_span_requests_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				requests[l.GetValue()] += m.GetCounter().GetValue()
+			}
+		}
+	}
+	if want := map[string]float64{"GET /users/{name}": 2}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("got requests %v, want %v", requests, want)
+	}
+}
+
+func TestTraceIDResponseHeader(t *testing.T) {
+	defer configureRecording(t, &Options{TraceIDResponseHeader: "X-Trace-Id"}).Close()
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	traceID := rec.Header().Get("X-Trace-Id")
+	if traceID == "" || traceID != onlyRecentSpan(t).TraceID {
+		t.Errorf("got trace ID %q in the response, want the one of the new trace", traceID)
+	}
+
+	const inbound = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(jaeger.TraceContextHeaderName, inbound+":00f067aa0ba902b7:0:1")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Trace-Id"); got != inbound {
+		t.Errorf("got trace ID %q in the response, want the inbound %s", got, inbound)
+	}
+}
+
+func TestHandlerTagsDeadline(t *testing.T) {
+	defer configureRecording(t, &Options{TagDeadlines: true}).Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if remaining, ok := onlyRecentSpan(t).Tags[deadlineRemainingTag].(int64); !ok || remaining < 59000 {
+		t.Errorf("got %s tag %v on the server span, want about a minute", deadlineRemainingTag, remaining)
+	}
+}
+
+func TestRecordPayloadSizes(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		body          io.Reader
+		chunks        []string
+		wantRequest   int64
+		wantResponse  int64
+		contentLength bool
+	}{
+		{"fixed length", strings.NewReader("hello"), []string{"hello world"}, 5, 11, true},
+		// hides the length of the body, so that the request is chunked
+		{"chunked", struct{ io.Reader }{strings.NewReader("0123456789")}, []string{"abcd", "efgh", "ijkl"}, 10, 12, false},
+		{"empty", nil, nil, 0, 0, true},
+	} {
+		closer := configureRecording(t, &Options{RecordPayloadSizes: true})
+		server := httptest.NewServer(NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if (r.ContentLength >= 0) != tt.contentLength {
+				t.Errorf("%s: got request content length %d", tt.name, r.ContentLength)
+			}
+			ioutil.ReadAll(r.Body)
+			for _, chunk := range tt.chunks {
+				io.WriteString(w, chunk)
+				w.(http.Flusher).Flush()
+			}
+		})))
+		resp, err := http.Post(server.URL, "text/plain", tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		server.Close()
+
+		tags := waitForRecentSpans(t, 1)[0].Tags
+		if got := tags[requestSizeTag]; got != tt.wantRequest {
+			t.Errorf("%s: got %s %v, want %d", tt.name, requestSizeTag, got, tt.wantRequest)
+		}
+		if got := tags[responseSizeTag]; got != tt.wantResponse {
+			t.Errorf("%s: got %s %v, want %d", tt.name, responseSizeTag, got, tt.wantResponse)
+		}
+		closer.Close()
+	}
+
+	defer configureRecording(t, &Options{}).Close()
+	serve("/")
+	if tags := onlyRecentSpan(t).Tags; tags[requestSizeTag] != nil || tags[responseSizeTag] != nil {
+		t.Errorf("got size tags %v with RecordPayloadSizes unset", tags)
+	}
+}
+
+func TestSkipPaths(t *testing.T) {
+	tracer := configureCollector(t, &Options{SkipPaths: []string{"/healthz", "/debug/*"}})
+	defer tracer.Close()
+
+	var traced bool
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traced = ot.SpanFromContext(r.Context()) != nil
+	}))
+	paths := map[string]bool{
+		"/healthz":       false,
+		"/debug/pprof/":  false,
+		"/debug":         true,
+		"/healthz/ready": true,
+		"/api/users":     true,
+	}
+	for path, want := range paths {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		if traced != want {
+			t.Errorf("%s: got a span passed to the handler %t, want %t", path, traced, want)
+		}
+	}
+
+	recorded := map[string]bool{}
+	for _, span := range tracer.spans() {
+		recorded[span.Operation] = true
+	}
+	for path, want := range paths {
+		if got := recorded["GET "+path]; got != want {
+			t.Errorf("%s: got a span recorded %t, want %t", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	jaeger "github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-client-go/thrift"
+	j "github.com/uber/jaeger-client-go/thrift-gen/jaeger"
+)
+
+// collectedSpan is a span as received by a testCollector.
+type collectedSpan struct {
+	TraceID   string
+	SpanID    string
+	ParentID  string
+	Operation string
+	Start     time.Time
+	Duration  time.Duration
+	Tags      map[string]interface{}
+}
+
+// testCollector is a Jaeger collector keeping the spans it receives over
+// HTTP.
+type testCollector struct {
+	*httptest.Server
+	mu    sync.Mutex
+	spans []collectedSpan
+}
+
+func newTestCollector() *testCollector {
+	c := &testCollector{}
+	c.Server = httptest.NewServer(c)
+	return c
+}
+
+// ServeHTTP implements the ServeHTTP() method of http.Handler, decoding the
+// batch of spans in the request body.
+func (c *testCollector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	buf := thrift.NewTMemoryBuffer()
+	buf.ReadFrom(req.Body)
+	batch := j.NewBatch()
+	if err := batch.Read(thrift.NewTBinaryProtocolTransport(buf)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range batch.Spans {
+		span := collectedSpan{
+			TraceID:   jaeger.TraceID{High: uint64(s.TraceIdHigh), Low: uint64(s.TraceIdLow)}.String(),
+			SpanID:    jaeger.SpanID(s.SpanId).String(),
+			Operation: s.OperationName,
+			Start:     time.Unix(0, s.StartTime*int64(time.Microsecond)),
+			Duration:  time.Duration(s.Duration) * time.Microsecond,
+			Tags:      make(map[string]interface{}),
+		}
+		if s.ParentSpanId != 0 {
+			span.ParentID = jaeger.SpanID(s.ParentSpanId).String()
+		}
+		for _, tag := range s.Tags {
+			span.Tags[tag.Key] = tagValue(tag)
+		}
+		c.spans = append(c.spans, span)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// tagValue returns the value of tag, integers being int64 whatever their
+// type when the tag was set.
+func tagValue(tag *j.Tag) interface{} {
+	switch tag.VType {
+	case j.TagType_BOOL:
+		return tag.GetVBool()
+	case j.TagType_LONG:
+		return tag.GetVLong()
+	case j.TagType_DOUBLE:
+		return tag.GetVDouble()
+	case j.TagType_BINARY:
+		return tag.GetVBinary()
+	}
+	return tag.GetVStr()
+}
+
+// received returns the spans received so far, in the order they were
+// reported.
+func (c *testCollector) received() []collectedSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]collectedSpan(nil), c.spans...)
+}
+
+// collectorTracer is the global tracer configured by configureCollector.
+type collectorTracer struct {
+	closer    io.Closer
+	collector *testCollector
+	once      sync.Once
+}
+
+// configureCollector configures the global tracer with options, sampling
+// every trace unless options say otherwise and sending its spans to a new
+// testCollector.
+func configureCollector(t *testing.T, options *Options) *collectorTracer {
+	t.Helper()
+	if options.SamplerType == "" {
+		options.SamplerType = "const"
+		options.SamplerParam = 1
+	}
+	collector := newTestCollector()
+	options.JaegerURL = collector.URL + "/api/traces"
+	closer, err := Configure("svc", options)
+	if err != nil {
+		collector.Close()
+		t.Fatalf("Configure: %v", err)
+	}
+	return &collectorTracer{closer: closer, collector: collector}
+}
+
+// Close closes the tracer, which flushes its spans to the collector, and then
+// the collector. Closing it again has no effect.
+func (c *collectorTracer) Close() error {
+	c.once.Do(func() {
+		c.closer.Close()
+		c.collector.Close()
+	})
+	return nil
+}
+
+// spans closes c and returns the spans its collector received.
+func (c *collectorTracer) spans() []collectedSpan {
+	c.Close()
+	return c.collector.received()
+}
+
+// onlySpan closes c and returns the only span its collector received.
+func (c *collectorTracer) onlySpan(t *testing.T) collectedSpan {
+	t.Helper()
+	spans := c.spans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1: %+v", len(spans), spans)
+	}
+	return spans[0]
+}
+
+// waitForSpans waits up to three seconds for the collector to receive n
+// spans, which the tracer flushes every second, and returns them.
+func (c *collectorTracer) waitForSpans(t *testing.T, n int) []collectedSpan {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		spans := c.collector.received()
+		if len(spans) >= n {
+			return spans
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d spans, want %d", len(spans), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// configureRecording configures the global tracer with options, sampling
+// every trace and keeping the spans it reports for RecentSpans unless options
+// say otherwise.
+func configureRecording(t *testing.T, options *Options) io.Closer {
+	t.Helper()
+	if options.RecentSpanBufferSize == 0 {
+		options.RecentSpanBufferSize = 100
+	}
+	if options.SamplerType == "" {
+		options.SamplerType = "const"
+		options.SamplerParam = 1
+	}
+	closer, err := Configure("svc", options)
+	if err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	return closer
+}
+
+// onlyRecentSpan returns the only span returned by RecentSpans.
+func onlyRecentSpan(t *testing.T) RecordedSpan {
+	t.Helper()
+	spans := RecentSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d recent spans, want 1: %+v", len(spans), spans)
+	}
+	return spans[0]
+}
+
+// waitForRecentSpans waits up to a second for RecentSpans to return n spans,
+// and returns them.
+func waitForRecentSpans(t *testing.T, n int) []RecordedSpan {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		spans := RecentSpans()
+		if len(spans) >= n {
+			return spans
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d recent spans, want %d", len(spans), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// recordCollector makes options send spans to a Jaeger collector, and
+// returns the reporter the collector reporter is replaced with. The spans it
+// holds are reset by Close.
+func recordCollector(options *Options) *jaeger.InMemoryReporter {
+	reporter := jaeger.NewInMemoryReporter()
+	options.JaegerURL = "http://127.0.0.1:14268/api/traces"
+	options.CollectorReporter = func(jaeger.Transport) jaeger.Reporter {
+		return reporter
+	}
+	return reporter
+}
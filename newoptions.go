@@ -0,0 +1,83 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// Option sets one or more fields of Options, see NewOptions.
+type Option func(*Options)
+
+// NewOptions builds Options from opts, for callers setting only a few of
+// them, e.g.
+//
+//	options, err := tracing.NewOptions(tracing.WithJaeger(url), tracing.WithSampleRate(0.01))
+//
+// The options are validated as each one is applied, so the error returned
+// is the one of the first option conflicting with those before it.
+func NewOptions(opts ...Option) (*Options, error) {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+		if err := o.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// WithZipkin sends spans to the zipkin collector at url.
+func WithZipkin(url string) Option {
+	return func(o *Options) {
+		o.ZipkinURL = url
+	}
+}
+
+// WithJaeger sends spans to the jaeger collector at url.
+func WithJaeger(url string) Option {
+	return func(o *Options) {
+		o.JaegerURL = url
+	}
+}
+
+// WithLogSpans logs every reported span.
+func WithLogSpans() Option {
+	return func(o *Options) {
+		o.LogTraceSpans = true
+	}
+}
+
+// WithSampleRate samples traces with probability rate.
+func WithSampleRate(rate float64) Option {
+	return func(o *Options) {
+		o.SamplerType = jaeger.SamplerTypeProbabilistic
+		o.SamplerParam = rate
+	}
+}
+
+// WithEnvironment sets the deployment environment, see Options.Environment.
+func WithEnvironment(environment string) Option {
+	return func(o *Options) {
+		o.Environment = environment
+	}
+}
+
+// WithPropagation propagates span contexts in format.
+func WithPropagation(format PropagationFormat) Option {
+	return func(o *Options) {
+		o.Propagation = format
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewOptions(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Option
+		want *Options
+		err  error
+	}{
+		{"none", nil, &Options{}, nil},
+		{"jaeger sampled", []Option{WithJaeger("http://jaeger:14268/api/traces"), WithSampleRate(0.01)},
+			&Options{JaegerURL: "http://jaeger:14268/api/traces", SamplerType: "probabilistic", SamplerParam: 0.01}, nil},
+		{"zipkin logged", []Option{WithZipkin("http://zipkin:9411/api/v1/spans"), WithLogSpans(), WithPropagation(PropagationW3C)},
+			&Options{ZipkinURL: "http://zipkin:9411/api/v1/spans", LogTraceSpans: true, Propagation: PropagationW3C}, nil},
+		{"environment", []Option{WithEnvironment("staging")}, &Options{Environment: "staging"}, nil},
+		{"jaeger and zipkin", []Option{WithJaeger("http://jaeger:14268"), WithZipkin("http://zipkin:9411")}, nil, ErrMultipleOutputs},
+		{"unknown environment", []Option{WithEnvironment("qa")}, nil, ErrUnknownEnvironment},
+	} {
+		got, err := NewOptions(tt.opts...)
+		if err != tt.err {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
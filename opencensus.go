@@ -0,0 +1,151 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	jaeger "github.com/uber/jaeger-client-go"
+	octrace "go.opencensus.io/trace"
+)
+
+// openCensusReporter exports spans through an OpenCensus exporter, easing the
+// migration of services whose spans still go to OpenCensus infrastructure.
+//
+// OpenTracing and OpenCensus spans don't map one to one:
+//   - tags become attributes; values which aren't strings, bools, integers or
+//     floats are formatted as strings
+//   - logs become annotations, named by their "event" field if they have one
+//   - FollowsFrom references become links of unspecified type, ChildOf
+//     references other than the parent are dropped
+//   - message events are never produced, OpenTracing has no equivalent
+//   - a span tagged with error=true gets the UNKNOWN status code
+type openCensusReporter struct {
+	exporter octrace.Exporter
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r openCensusReporter) Report(span *jaeger.Span) {
+	r.exporter.ExportSpan(toSpanData(span))
+}
+
+// Close implements the Close() method of jaeger.Reporter.
+func (openCensusReporter) Close() {}
+
+// OpenCensus' UNKNOWN status code
+const openCensusStatusUnknown = 2
+
+func toSpanData(span *jaeger.Span) *octrace.SpanData {
+	sc := span.SpanContext()
+	sd := &octrace.SpanData{
+		SpanContext:  toOpenCensusContext(sc),
+		ParentSpanID: toOpenCensusSpanID(sc.ParentID()),
+		Name:         span.OperationName(),
+		StartTime:    span.StartTime(),
+		EndTime:      span.StartTime().Add(span.Duration()),
+	}
+
+	tags := span.Tags()
+	sd.Attributes = make(map[string]interface{}, len(tags))
+	for k, v := range tags {
+		sd.Attributes[k] = toOpenCensusValue(v)
+	}
+	switch tags[string(ext.SpanKind)] {
+	case ext.SpanKindRPCServerEnum, string(ext.SpanKindRPCServerEnum):
+		sd.SpanKind = octrace.SpanKindServer
+	case ext.SpanKindRPCClientEnum, string(ext.SpanKindRPCClientEnum):
+		sd.SpanKind = octrace.SpanKindClient
+	}
+	if isErr, _ := tags[string(ext.Error)].(bool); isErr {
+		sd.Status = octrace.Status{Code: openCensusStatusUnknown}
+	}
+
+	for _, l := range span.Logs() {
+		a := octrace.Annotation{
+			Time:       l.Timestamp,
+			Message:    "log",
+			Attributes: make(map[string]interface{}, len(l.Fields)),
+		}
+		for _, f := range l.Fields {
+			if f.Key() == "event" {
+				a.Message = fmt.Sprint(f.Value())
+				continue
+			}
+			a.Attributes[f.Key()] = toOpenCensusValue(f.Value())
+		}
+		sd.Annotations = append(sd.Annotations, a)
+	}
+
+	for _, ref := range span.References() {
+		refCtx, ok := ref.ReferencedContext.(jaeger.SpanContext)
+		if !ok || ref.Type != ot.FollowsFromRef {
+			continue
+		}
+		oc := toOpenCensusContext(refCtx)
+		sd.Links = append(sd.Links, octrace.Link{
+			TraceID: oc.TraceID,
+			SpanID:  oc.SpanID,
+			Type:    octrace.LinkTypeUnspecified,
+		})
+	}
+
+	return sd
+}
+
+func toOpenCensusContext(sc jaeger.SpanContext) octrace.SpanContext {
+	var oc octrace.SpanContext
+	binary.BigEndian.PutUint64(oc.TraceID[:8], sc.TraceID().High)
+	binary.BigEndian.PutUint64(oc.TraceID[8:], sc.TraceID().Low)
+	oc.SpanID = toOpenCensusSpanID(sc.SpanID())
+	if sc.IsSampled() {
+		oc.TraceOptions = 1
+	}
+	return oc
+}
+
+func toOpenCensusSpanID(id jaeger.SpanID) octrace.SpanID {
+	var oc octrace.SpanID
+	binary.BigEndian.PutUint64(oc[:], uint64(id))
+	return oc
+}
+
+// toOpenCensusValue converts v to one of the attribute types supported by
+// OpenCensus.
+func toOpenCensusValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string, bool, int64, float64:
+		return v
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go/ext"
+	jaeger "github.com/uber/jaeger-client-go"
+	octrace "go.opencensus.io/trace"
+)
+
+// fakeExporter is an OpenCensus exporter handing out the spans it exports.
+type fakeExporter chan *octrace.SpanData
+
+func (e fakeExporter) ExportSpan(sd *octrace.SpanData) {
+	e <- sd
+}
+
+func TestOpenCensusExporter(t *testing.T) {
+	exporter := make(fakeExporter, 1)
+	defer configureCollector(t, &Options{OpenCensusExporter: exporter}).Close()
+
+	parent, ctx := StartSpan(context.Background(), "parent")
+	defer parent.Finish()
+	span, _ := StartSpan(ctx, "op")
+	ext.SpanKindRPCServer.Set(span)
+	span.SetTag("string", "value")
+	span.SetTag("int", 3)
+	span.SetTag("duration", time.Second)
+	span.LogKV("event", "milestone", "step", 2)
+	span.Finish()
+
+	var sd *octrace.SpanData
+	select {
+	case sd = <-exporter:
+	case <-time.After(time.Second):
+		t.Fatal("no span exported")
+	}
+	if sd.Name != "op" {
+		t.Errorf("got name %q, want op", sd.Name)
+	}
+	if sd.SpanKind != octrace.SpanKindServer {
+		t.Errorf("got span kind %d, want server", sd.SpanKind)
+	}
+	for k, want := range map[string]interface{}{"string": "value", "int": int64(3), "duration": "1s"} {
+		if got := sd.Attributes[k]; got != want {
+			t.Errorf("got attribute %s=%#v, want %#v", k, got, want)
+		}
+	}
+	want := toOpenCensusSpanID(parent.Context().(jaeger.SpanContext).SpanID())
+	if sd.ParentSpanID != want {
+		t.Errorf("got parent %v, want %v", sd.ParentSpanID, want)
+	}
+	if len(sd.Annotations) != 1 || sd.Annotations[0].Message != "milestone" ||
+		sd.Annotations[0].Attributes["step"] != int64(2) {
+		t.Errorf("got annotations %+v, want the milestone log", sd.Annotations)
+	}
+}
@@ -17,8 +17,14 @@ package tracing
 
 import (
 	"errors"
+	"net/http"
+	"strings"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
+	jaeger "github.com/uber/jaeger-client-go"
+	octrace "go.opencensus.io/trace"
 )
 
 // Most of the following is taken from:
@@ -32,23 +38,680 @@ type Options struct {
 	// URL of jaeger HTTP collector (example: 'http://jaeger:14268/api/traces?format=jaeger.thrift'). This enables tracing for Mixer itself.
 	JaegerURL string
 
-	// Whether or not to emit trace spans as log records.
+	// URL of a Datadog agent (example: 'http://localhost:8126') spans are
+	// sent to in Datadog's trace format. It may be combined with the other
+	// collectors and any Propagation.
+	DatadogAgentURL string
+
+	// When positive, the most recent of this many reported spans are kept
+	// in memory and returned by RecentSpans, e.g. for a live debug page,
+	// in addition to being sent to any collector.
+	RecentSpanBufferSize int
+
+	// Path of a file every reported span is appended to, in the OTLP JSON
+	// encoding of an ExportTraceServiceRequest per line, for offline
+	// analysis with OpenTelemetry tooling. The file is created if needed.
+	OTLPFile string
+
+	// Address of an OTLP collector spans are sent to over gRPC (example:
+	// 'otel-collector:4317'). The connection uses TLS when TLSCertFile or
+	// TLSCAFile is set, and is unencrypted otherwise.
+	OTLPEndpoint string
+
+	// Compression of the requests sent to OTLPEndpoint, either
+	// OTLPCompressionGzip or OTLPCompressionNone. Defaults to none.
+	OTLPCompression string
+
+	// gRPC metadata sent with every request to OTLPEndpoint, e.g. the
+	// authentication header the collector requires.
+	OTLPHeaders map[string]string
+
+	// URLs of collectors spans are sent to while the collector at ZipkinURL
+	// or JaegerURL is failing. Each requires the corresponding primary URL.
+	FallbackZipkinURL string
+	FallbackJaegerURL string
+
+	// URL of a Zipkin collector (example:
+	// 'http://jaeger-collector:9411/api/v1/spans') sent a duplicate of every
+	// span reported to JaegerURL, encoded as Zipkin thrift rather than jaeger
+	// thrift, to compare how collectors ingest both encodings. It doubles
+	// the volume of spans exported.
+	DualEncodeValidationURL string
+
+	// Whether or not to emit trace spans as log records. SetLogSpans turns
+	// this on or off at runtime.
 	LogTraceSpans bool
+
+	// Whether or not to print finished spans to stdout in a human readable
+	// form. Intended for local development without a collector.
+	ConsoleExporter bool
+
+	// Builds the reporter sending spans to a collector through trans in
+	// place of jaeger's buffered remote reporter. It is meant for
+	// tracingtest.ConfigureSync and should be left nil otherwise.
+	CollectorReporter func(trans jaeger.Transport) jaeger.Reporter
+
+	// OpenCensus exporter which also receives every sampled span, for
+	// services migrating off OpenCensus infrastructure. See
+	// openCensusReporter for how spans are converted.
+	OpenCensusExporter octrace.Exporter
+
+	// Whether the sampler.type and sampler.param tags which record how the
+	// sampling decision of a trace was made are copied from its root span
+	// onto its other spans started in this process.
+	PropagateSamplerTags bool
+
+	// Registerer of request, error and duration metrics per operation
+	// derived from every span, whether sampled or not. Spans count under the
+	// name they are started under, e.g. the SamplingOperationNamer name of
+	// server spans, passed through OperationNameSanitizer, or CollapseIDs
+	// when it is nil, to bound the cardinality of the metrics.
+	REDMetrics prometheus.Registerer
+
+	// Upper bounds, in seconds and ascending, of the buckets of the span
+	// duration histogram of REDMetrics, e.g. finer buckets for services
+	// answering within microseconds. prometheus.DefBuckets are used when
+	// empty. The buckets can't change once the histogram is registered.
+	DurationBuckets []float64
+
+	// Baggage items copied into tags of the same name on every reported
+	// span, e.g. a routing key set by the mesh, so that collectors can index
+	// them.
+	BaggageToTagKeys []string
+
+	// Baggage key holding the tenant of a request, e.g. in a multi-tenant
+	// gateway. Spans carrying it are tagged service.instance with the
+	// service name suffixed by the tenant, e.g. "gateway-acme", so that
+	// collectors can tell tenants apart. The jaeger service name itself is
+	// fixed; recording spans under several services requires a tracer per
+	// service.
+	TenantTagKey string
+
+	// Whether sampled spans started by the helpers of this package, such as
+	// StartSpan, are tagged code.filepath and code.lineno with where they
+	// were started. Looking up the caller is expensive; enable it for
+	// debugging.
+	TagCallerInfo bool
+
+	// Whether spans started by the helpers of this package, such as
+	// StartSpan and NewHandler, are tagged deadline.remaining_ms with the
+	// time left before the deadline of their context, if it has one. See
+	// TagDeadline.
+	TagDeadlines bool
+
+	// When not empty, only span tags with these keys are forwarded to the
+	// collector, to control cost and cardinality. The error tag and the
+	// tags recording the sampling decision are always kept.
+	TagAllowlist []string
+
+	// Called with the key and value, rendered as a string, of every span tag
+	// before it is forwarded to the collector, after TagAllowlist, to keep
+	// sensitive data such as emails or tokens out of the backend. It returns
+	// the value to forward in place of the original one, or false to drop
+	// the tag. The error tag and the tags recording the sampling decision are
+	// never passed to it. NewTagRedactor returns a ready made redactor.
+	TagRedactor func(key, value string) (string, bool)
+
+	// Names the server spans started by NewHandler for inbound requests,
+	// e.g. by their route template. Spans are named by the request method
+	// and path when nil.
+	ServerSpanNamer func(*http.Request) string
+
+	// Names inbound requests for the sampler, e.g. by their route template,
+	// so per-operation sampling keys on a low-cardinality name while server
+	// spans keep the name given by ServerSpanNamer. Server spans are started
+	// under this name and renamed once sampled. The span name is used when
+	// nil.
+	SamplingOperationNamer func(*http.Request) string
+
+	// Paths of inbound requests for which NewHandler starts no span at all,
+	// such as health checks. A path ending in * matches every path starting
+	// with the part before it, other paths only match exactly. Each must
+	// start with /.
+	SkipPaths []string
+
+	// Response header in which NewHandler returns the ID of the trace each
+	// request is part of (example: 'X-Trace-Id'), e.g. so clients can quote
+	// it in support tickets. Requests carrying no trace context start a new
+	// trace, whose ID is returned. No header is written when empty.
+	TraceIDResponseHeader string
+
+	// Whether the server spans of NewHandler are tagged http.request.size and
+	// http.response.size with the number of bytes of the request and response
+	// bodies. Request bodies of unknown length are counted as they are read,
+	// so only the bytes read by the handler are recorded.
+	RecordPayloadSizes bool
+
+	// When positive, a warning is logged for every span whose estimated
+	// serialized size exceeds this many bytes, as some collectors reject
+	// oversized spans.
+	MaxSpanBytes int
+
+	// Whether spans exceeding MaxSpanBytes are dropped rather than reported.
+	DropOversizedSpans bool
+
+	// Called with every span right before it is reported, e.g. to add tags
+	// computed from the span. It runs on the goroutine finishing the span,
+	// so it must be fast.
+	SpanPostProcessor func(*jaeger.Span)
+
+	// Rewrites the operation name of every span before it is reported, to
+	// keep IDs embedded in names from exploding the backend's index.
+	// CollapseIDs is a ready made sanitizer.
+	OperationNameSanitizer func(string) string
+
+	// Prepended to the operation name of every span before it is reported,
+	// after OperationNameSanitizer, to tell apart the generic operations of
+	// services sharing a backend (example: 'payments.'). Operation names are
+	// reported as is when empty.
+	OperationNamePrefix string
+
+	// Whether Configure leaves the global tracer alone if one has already
+	// been installed, e.g. when Configure is called by a library embedded in
+	// an application with its own tracer. The tracer is still built and
+	// closed as usual.
+	OnlySetGlobalIfUnset bool
+
+	// Whether Configure records a tracing.configure span, tagged with the
+	// configured backends and sampler, once the tracer is installed, e.g. to
+	// confirm spans are delivered end to end right at startup. The span is
+	// always sampled.
+	TraceSelfInit bool
+
+	// Sampling rates for traces whose root span carries a matching baggage
+	// item, keyed by "baggageKey:value" (example: 'tenant:acme'). Rules only
+	// apply to baggage present when the root span is started, so this works
+	// best when the baggage is set on, or propagated into, the root span.
+	BaggageSamplingRules map[string]float64
+
+	// Consulted for the sampling decision of every new trace before the
+	// other samplers, e.g. to sample every request of beta users as decided
+	// by a feature flag service. Its decision is final when it reports the
+	// trace as handled, and the other samplers decide otherwise. It is
+	// called on the goroutine starting the root span, so it must be fast.
+	SamplerDecider func(operation string, ctx SamplerContext) (sampled bool, handled bool)
+
+	// Rules consulted in order for the sampling decision of every new trace,
+	// after SamplerDecider and before the other samplers. The first rule
+	// which doesn't return SamplingDefer decides; the trace is left to the
+	// other samplers when every rule defers. Like SamplerDecider, rules are
+	// called on the goroutine starting the root span, so they must be fast.
+	SamplerRules []SamplerRule
+
+	// Format used to propagate span contexts in HTTP headers and text maps
+	// such as gRPC metadata. Defaults to B3 when ZipkinURL is set and to
+	// jaeger's native format otherwise.
+	Propagation PropagationFormat
+
+	// Whether the default Propagation keeps jaeger's native format even
+	// when ZipkinURL is set, instead of installing B3, for callers layering
+	// their own propagation on top.
+	DisableDefaultPropagator bool
+
+	// Formats tried in order when extracting span contexts, overriding the
+	// extraction of Propagation, so that requests carrying the headers of
+	// several formats consistently continue the trace of the first one. Each
+	// must be 'jaeger', 'b3' or 'w3c'. Injection still follows Propagation.
+	ExtractionPriority []PropagationFormat
+
+	// Whether the spans started but never finished are tracked, and the
+	// operations of those still open are logged when the tracer is closed,
+	// to catch instrumentation forgetting to call Finish. Tracking adds
+	// overhead to every span; keep it off in production.
+	DebugUnfinishedSpans bool
+
+	// Whether every injected span context, and every extraction finding no
+	// span context, is logged along with the header keys involved, to
+	// diagnose traces breaking at service boundaries. It is noisy and meant
+	// to be turned on only while diagnosing.
+	DebugPropagation bool
+
+	// Fraction of sampled spans sent to the collector, between 0 and 1. Spans
+	// are dropped per trace and independently of the sampler, so unshipped
+	// spans are still recorded in-process. Every sampled span is sent when
+	// nil.
+	ReportSampleRate *float64
+
+	// Rules refining which sampled spans are sent to collectors, and which
+	// are logged while span logging is on, by operation, e.g. to log every
+	// span of an operation audited from the logs while exporting 1% of them.
+	// The first rule matching a span's operation applies and spans no rule
+	// matches are kept. Spans the tracer doesn't sample never reach any
+	// reporter, so the tracer has to sample at least at the highest rate any
+	// reporter needs, e.g. through SamplingConfigFile.
+	ExportSamplingRules []ReportSamplingRule
+	LogSamplingRules    []ReportSamplingRule
+
+	// Maximum time Close waits for buffered spans to be flushed to the
+	// collectors, all of them together. Close waits for the flush to
+	// complete when zero.
+	CloseTimeout time.Duration
+
+	// Whether debug spans, i.e. spans of traces forced to be sampled through
+	// ForceSampleContext, a sampling.priority tag or a jaeger-debug-id header,
+	// are uploaded to the Zipkin or Jaeger collector as soon as they finish
+	// rather than batched, so a trace is visible while its operator is still
+	// looking for it. The upload happens synchronously on the goroutine
+	// finishing the span, and other spans are batched as usual.
+	FlushPrioritySpans bool
+
+	// Called with DropReasonQueueFull or DropReasonTransportError for every
+	// span the collector reporter drops, e.g. to increment a counter. It is
+	// called on a separate goroutine, and calls are discarded if it can't
+	// keep up.
+	OnSpanDropped func(reason string)
+
+	// Called with the status and an error describing it whenever the
+	// collector rejects an upload with a 4xx status, e.g. to alert on
+	// misconfigured collectors. Batches rejected as too large (413) are first
+	// split and uploaded in smaller batches, so it is only called for them if
+	// a single span is too large. It is called on the goroutine flushing
+	// spans and must not block.
+	OnCollectorReject func(status int, err error)
+
+	// Generator of trace and span IDs, e.g. to get predictable IDs in tests or
+	// to allocate them externally. jaeger's random generator is used when nil.
+	RandomNumberFunc func() uint64
+
+	// Query parameters kept in the http.url tag of HTTP spans. Other
+	// parameters are dropped, or redacted if URLTagRedactValues is set. The
+	// full URL is tagged when empty.
+	URLTagParamAllowlist []string
+
+	// Whether query parameters missing from URLTagParamAllowlist are kept in
+	// the http.url tag with a redacted value rather than dropped.
+	URLTagRedactValues bool
+
+	// Deployment environment of the service: 'dev', 'staging' or 'prod', or
+	// one of the keys of EnvironmentSampleRates. Unless SamplerType is set,
+	// traces are sampled with the environment's default probability. It is
+	// also reported as the deployment.environment process tag.
+	Environment string
+
+	// Namespace of the service, reported as the service.namespace process
+	// tag so that collectors can group services.
+	ServiceNamespace string
+
+	// Version of the service, reported as the service.version process tag
+	// which backends use to track releases.
+	ServiceVersion string
+
+	// Sampling probabilities of environments, overriding or adding to the
+	// defaults of 1 for 'dev', 0.1 for 'staging' and 0.01 for 'prod'.
+	EnvironmentSampleRates map[string]float64
+
+	// Type of sampler deciding which traces are recorded: 'const',
+	// 'probabilistic' or 'ratelimiting'. Every trace is sampled when empty.
+	// 'probabilistic' decisions are derived from the trace ID, so services
+	// sampling at the same rate sample the same traces, see SampleTraceID.
+	SamplerType string
+
+	// Parameter of the sampler selected by SamplerType: 0 or 1 for 'const',
+	// the sampling probability for 'probabilistic' and the maximum number of
+	// traces per second for 'ratelimiting'.
+	SamplerParam float64
+
+	// When positive, every operation is guaranteed at least this many
+	// sampled traces per second on top of the sampling probability of a
+	// 'probabilistic' SamplerType, or of the environment's default rate when
+	// SamplerType is empty, so that low-traffic operations are still traced.
+	LowerBoundPerSecond float64
+
+	// URL of a jaeger sampling server (example:
+	// 'http://jaeger-agent:5778/sampling') serving the strategies which
+	// decide which traces are recorded, instead of SamplerType.
+	SamplingServerURL string
+
+	// Sampling probability of traces until strategies have been fetched
+	// from SamplingServerURL. The sampler selected by the other options is
+	// used until then when nil.
+	SamplingFallbackRate *float64
+
+	// When positive, traces started after this many others within the same
+	// minute are sampled with probability AdaptiveThrottledSampleRate rather
+	// than by the sampler, which is used again from the next minute.
+	AdaptiveThresholdPerMinute int
+
+	// Sampling probability of traces past AdaptiveThresholdPerMinute.
+	AdaptiveThrottledSampleRate float64
+
+	// When positive, traces with a span tagged 'priority' with a value of at
+	// least this are always sampled. The tag is evaluated when it is set,
+	// including when a span is started with it, up until the first span of
+	// the trace in this process finishes.
+	AlwaysSamplePriority int
+
+	// Traces with a span tagged peer.service with one of these services,
+	// e.g. a critical downstream, are always sampled. Like the priority tag,
+	// the tag is evaluated when it is set up until the first span of the
+	// trace in this process finishes, so that span and every span started
+	// after the tag is set are reported; spans already sent downstream
+	// meanwhile carry the unsampled flag.
+	AlwaysSamplePeers []string
+
+	// When positive, traces with a span tagged as failed through the error
+	// tag are sampled, up to this many traces per second so that an outage
+	// doesn't flood the backend; failed traces past that rate get the
+	// decision of the other samplers. Like the priority tag, the tag is
+	// evaluated up until the first span of the trace in this process
+	// finishes.
+	MaxErrorSamplesPerSecond float64
+
+	// EXPERIMENTAL: whether traces carry a sampling budget set with
+	// SetSamplingBudget by the service at their root. Traces started with a
+	// budget in baggage stop being sampled once their root service's budget
+	// is exhausted, so that downstream services don't over-sample. Like
+	// BaggageSamplingRules, the budget is only seen on baggage present when
+	// the root span is started in this process.
+	SamplingBudgets bool
+
+	// YAML or JSON file of per operation sampling rates, e.g. to never
+	// sample health checks. Traces whose root span matches none of its rules
+	// are sampled as configured by the other options.
+	SamplingConfigFile string
+
+	// Whether every sampling decision is logged with the operation and trace
+	// it was made for, to diagnose missing traces.
+	LogSamplingDecisions bool
+
+	// When positive, the first span of every operation within each window of
+	// this length is sampled regardless of the sampler, so that every
+	// operation has at least one recent trace.
+	FirstSpanPerOperationWindow time.Duration
+
+	// Whether every reporter, e.g. the collector and the span logger, gets a
+	// queue and goroutine of its own so that a slow one doesn't delay the
+	// others. Spans are dropped by a reporter whose queue is full.
+	IndependentReporterQueues bool
+
+	// Whether spans are buffered, rather than lost, while the collector at
+	// ZipkinURL or JaegerURL can't be connected to, until a connection
+	// retried in the background succeeds. Useful when the collector may
+	// start after the service.
+	RetryCollectorConnect bool
+
+	// Number of times an upload of spans to the collector which failed with
+	// a network error or a 5xx status is retried before the spans are
+	// dropped.
+	ReporterMaxRetries int
+
+	// Time to wait before the first retry of an upload, doubling with every
+	// further retry. Defaults to 200ms.
+	ReporterRetryBackoff time.Duration
+
+	// Maximum number of idle connections kept open to the collector.
+	// Defaults to 10.
+	MaxIdleConns int
+
+	// Time after which an idle connection to the collector is closed.
+	// Defaults to 90s.
+	IdleConnTimeout time.Duration
+
+	// Client certificate and key files presented to the collector. Both must
+	// be set together.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// CA certificate file used to verify the collector, instead of the
+	// system roots.
+	TLSCAFile string
 }
 
+var (
+	// ErrMultipleOutputs is returned by Validate when both Jaeger and Zipkin
+	// outputs are configured.
+	ErrMultipleOutputs = errors.New("can't have Jaeger and Zipkin outputs active simultaneously")
+
+	// ErrFallbackWithoutPrimary is returned by Validate when a fallback
+	// collector is configured without the corresponding primary collector.
+	ErrFallbackWithoutPrimary = errors.New("fallback collector configured without a primary collector of the same kind")
+
+	// ErrDualEncodeWithoutJaeger is returned by Validate when
+	// DualEncodeValidationURL is set without JaegerURL.
+	ErrDualEncodeWithoutJaeger = errors.New("dual encode validation requires a Jaeger collector")
+
+	// ErrUnknownOTLPCompression is returned by Validate when OTLPCompression
+	// is neither empty, OTLPCompressionGzip nor OTLPCompressionNone.
+	ErrUnknownOTLPCompression = errors.New("unknown OTLP compression")
+
+	// ErrUnknownPropagation is returned by Validate when Propagation is not
+	// one of the supported propagation formats.
+	ErrUnknownPropagation = errors.New("propagation format must be one of 'jaeger', 'b3', 'w3c' or 'all'")
+
+	// ErrUnknownExtractionFormat is returned by Validate when
+	// ExtractionPriority lists anything but 'jaeger', 'b3' or 'w3c'.
+	ErrUnknownExtractionFormat = errors.New("extraction priority formats must be 'jaeger', 'b3' or 'w3c'")
+
+	// ErrInvalidReportSampleRate is returned by Validate when
+	// ReportSampleRate is outside of [0, 1].
+	ErrInvalidReportSampleRate = errors.New("report sample rate must be within [0, 1]")
+
+	// ErrUnknownEnvironment is returned by Validate when Environment has no
+	// default sampling probability.
+	ErrUnknownEnvironment = errors.New("environment must be one of 'dev', 'staging', 'prod' or a key of the environment sample rates")
+
+	// ErrInvalidEnvironmentSampleRate is returned by Validate when one of
+	// EnvironmentSampleRates is outside of [0, 1].
+	ErrInvalidEnvironmentSampleRate = errors.New("environment sample rates must be within [0, 1]")
+
+	// ErrInvalidSkipPath is returned by Validate when one of SkipPaths doesn't
+	// start with /.
+	ErrInvalidSkipPath = errors.New("skip paths must start with '/'")
+
+	// ErrUnsortedDurationBuckets is returned by Validate when
+	// DurationBuckets are not in strictly ascending order.
+	ErrUnsortedDurationBuckets = errors.New("duration buckets must be in ascending order")
+
+	// ErrNegativeRecentSpanBufferSize is returned by Validate when
+	// RecentSpanBufferSize is negative.
+	ErrNegativeRecentSpanBufferSize = errors.New("recent span buffer size must not be negative")
+
+	// ErrNegativeMaxSpanBytes is returned by Validate when MaxSpanBytes is
+	// negative.
+	ErrNegativeMaxSpanBytes = errors.New("max span bytes must not be negative")
+
+	// ErrNegativeCloseTimeout is returned by Validate when CloseTimeout is
+	// negative.
+	ErrNegativeCloseTimeout = errors.New("close timeout must not be negative")
+
+	// ErrNegativeReporterRetries is returned by Validate when
+	// ReporterMaxRetries or ReporterRetryBackoff is negative.
+	ErrNegativeReporterRetries = errors.New("reporter max retries and retry backoff must not be negative")
+
+	// ErrNegativeIdleConns is returned by Validate when MaxIdleConns or
+	// IdleConnTimeout is negative.
+	ErrNegativeIdleConns = errors.New("max idle conns and idle conn timeout must not be negative")
+
+	// ErrTLSCertWithoutKey is returned by Validate when a TLS certificate is
+	// configured without its key.
+	ErrTLSCertWithoutKey = errors.New("TLS certificate configured without a TLS key")
+
+	// ErrTLSKeyWithoutCert is returned by Validate when a TLS key is
+	// configured without its certificate.
+	ErrTLSKeyWithoutCert = errors.New("TLS key configured without a TLS certificate")
+
+	// ErrUnknownSamplerType is returned by Validate when SamplerType is not
+	// one of the supported sampler types.
+	ErrUnknownSamplerType = errors.New("sampler type must be one of 'const', 'probabilistic' or 'ratelimiting'")
+
+	// ErrInvalidSamplerParam is returned by Validate when SamplerParam is not
+	// valid for the configured SamplerType.
+	ErrInvalidSamplerParam = errors.New("sampler param must be 0 or 1 for 'const', within [0, 1] for 'probabilistic' and non-negative for 'ratelimiting'")
+
+	// ErrInvalidLowerBound is returned by Validate when LowerBoundPerSecond
+	// is negative, or positive with a SamplerType other than
+	// 'probabilistic'.
+	ErrInvalidLowerBound = errors.New("lower bound per second must not be negative and requires a 'probabilistic' sampler type")
+
+	// ErrNegativeMaxErrorSamples is returned by Validate when
+	// MaxErrorSamplesPerSecond is negative.
+	ErrNegativeMaxErrorSamples = errors.New("max error samples per second must not be negative")
+
+	// ErrInvalidSamplingFallbackRate is returned by Validate when
+	// SamplingFallbackRate is outside of [0, 1].
+	ErrInvalidSamplingFallbackRate = errors.New("sampling fallback rate must be within [0, 1]")
+
+	// ErrNegativeAdaptiveThreshold is returned by Validate when
+	// AdaptiveThresholdPerMinute is negative.
+	ErrNegativeAdaptiveThreshold = errors.New("adaptive threshold per minute must not be negative")
+
+	// ErrInvalidAdaptiveThrottledSampleRate is returned by Validate when
+	// AdaptiveThrottledSampleRate is outside of [0, 1].
+	ErrInvalidAdaptiveThrottledSampleRate = errors.New("adaptive throttled sample rate must be within [0, 1]")
+
+	// ErrNegativeFirstSpanPerOperationWindow is returned by Validate when
+	// FirstSpanPerOperationWindow is negative.
+	ErrNegativeFirstSpanPerOperationWindow = errors.New("first span per operation window must not be negative")
+)
+
 // Validate returns whether the options have been configured correctly or an error
 func (o *Options) Validate() error {
 	// due to a race condition in the OT libraries somewhere, we can't have both tracing outputs active at once
 	if o.JaegerURL != "" && o.ZipkinURL != "" {
-		return errors.New("can't have Jaeger and Zipkin outputs active simultaneously")
+		return ErrMultipleOutputs
+	}
+	if (o.FallbackZipkinURL != "" && o.ZipkinURL == "") || (o.FallbackJaegerURL != "" && o.JaegerURL == "") {
+		return ErrFallbackWithoutPrimary
+	}
+	if o.DualEncodeValidationURL != "" && o.JaegerURL == "" {
+		return ErrDualEncodeWithoutJaeger
+	}
+	switch o.OTLPCompression {
+	case "", OTLPCompressionNone, OTLPCompressionGzip:
+	default:
+		return ErrUnknownOTLPCompression
+	}
+
+	if !o.Propagation.valid() {
+		return ErrUnknownPropagation
+	}
+	for _, format := range o.ExtractionPriority {
+		if _, err := singlePropagator(format); err != nil {
+			return ErrUnknownExtractionFormat
+		}
+	}
+
+	if r := o.ReportSampleRate; r != nil && (*r < 0 || *r > 1) {
+		return ErrInvalidReportSampleRate
+	}
+	if err := validateReportSamplingRules(o.ExportSamplingRules); err != nil {
+		return err
+	}
+	if err := validateReportSamplingRules(o.LogSamplingRules); err != nil {
+		return err
+	}
+
+	for _, path := range o.SkipPaths {
+		if !strings.HasPrefix(path, "/") {
+			return ErrInvalidSkipPath
+		}
+	}
+
+	for i := 1; i < len(o.DurationBuckets); i++ {
+		if o.DurationBuckets[i] <= o.DurationBuckets[i-1] {
+			return ErrUnsortedDurationBuckets
+		}
+	}
+
+	if o.RecentSpanBufferSize < 0 {
+		return ErrNegativeRecentSpanBufferSize
+	}
+
+	if o.MaxSpanBytes < 0 {
+		return ErrNegativeMaxSpanBytes
+	}
+
+	if o.CloseTimeout < 0 {
+		return ErrNegativeCloseTimeout
+	}
+
+	if o.ReporterMaxRetries < 0 || o.ReporterRetryBackoff < 0 {
+		return ErrNegativeReporterRetries
+	}
+
+	if o.MaxIdleConns < 0 || o.IdleConnTimeout < 0 {
+		return ErrNegativeIdleConns
+	}
+
+	if o.TLSCertFile != "" && o.TLSKeyFile == "" {
+		return ErrTLSCertWithoutKey
+	}
+	if o.TLSKeyFile != "" && o.TLSCertFile == "" {
+		return ErrTLSKeyWithoutCert
+	}
+
+	for _, rate := range o.EnvironmentSampleRates {
+		if rate < 0 || rate > 1 {
+			return ErrInvalidEnvironmentSampleRate
+		}
+	}
+	if _, ok := o.environmentSampleRate(); o.Environment != "" && !ok {
+		return ErrUnknownEnvironment
+	}
+
+	switch o.SamplerType {
+	case "":
+	case jaeger.SamplerTypeConst:
+		if o.SamplerParam != 0 && o.SamplerParam != 1 {
+			return ErrInvalidSamplerParam
+		}
+	case jaeger.SamplerTypeProbabilistic:
+		if o.SamplerParam < 0 || o.SamplerParam > 1 {
+			return ErrInvalidSamplerParam
+		}
+	case jaeger.SamplerTypeRateLimiting:
+		if o.SamplerParam < 0 {
+			return ErrInvalidSamplerParam
+		}
+	default:
+		return ErrUnknownSamplerType
+	}
+
+	if o.LowerBoundPerSecond < 0 || (o.LowerBoundPerSecond > 0 && o.SamplerType != "" && o.SamplerType != jaeger.SamplerTypeProbabilistic) {
+		return ErrInvalidLowerBound
+	}
+
+	if o.MaxErrorSamplesPerSecond < 0 {
+		return ErrNegativeMaxErrorSamples
+	}
+
+	if r := o.SamplingFallbackRate; r != nil && (*r < 0 || *r > 1) {
+		return ErrInvalidSamplingFallbackRate
+	}
+
+	if o.AdaptiveThresholdPerMinute < 0 {
+		return ErrNegativeAdaptiveThreshold
+	}
+	if o.AdaptiveThrottledSampleRate < 0 || o.AdaptiveThrottledSampleRate > 1 {
+		return ErrInvalidAdaptiveThrottledSampleRate
+	}
+
+	if o.FirstSpanPerOperationWindow < 0 {
+		return ErrNegativeFirstSpanPerOperationWindow
+	}
+
+	if err := validateBaggageSamplingRules(o.BaggageSamplingRules); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// environmentSampleRate returns the sampling probability of the configured
+// environment, and whether the environment is known.
+func (o *Options) environmentSampleRate() (float64, bool) {
+	if rate, ok := o.EnvironmentSampleRates[o.Environment]; ok {
+		return rate, true
+	}
+	rate, ok := environmentSampleRates[o.Environment]
+	return rate, ok
+}
+
 // TracingEnabled returns whether the given options enable tracing to take place.
 func (o *Options) TracingEnabled() bool {
-	return o.JaegerURL != "" || o.ZipkinURL != "" || o.LogTraceSpans
+	return o.JaegerURL != "" || o.ZipkinURL != "" || o.DatadogAgentURL != "" || o.LogTraceSpans || o.ConsoleExporter ||
+		o.OpenCensusExporter != nil || o.RecentSpanBufferSize > 0 || o.OTLPFile != "" ||
+		o.OTLPEndpoint != ""
 }
 
 // AttachCobraFlags attaches a set of Cobra flags to the given Cobra command.
@@ -62,6 +725,165 @@ func AttachCobraFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().StringP("trace_jaeger_url", "", "",
 		"URL of Jaeger HTTP collector (example: 'http://jaeger:14268/api/traces?format=jaeger.thrift').")
 
+	cmd.PersistentFlags().StringP("trace_datadog_agent_url", "", "",
+		"URL of a Datadog agent receiving trace spans (example: 'http://localhost:8126').")
+
+	cmd.PersistentFlags().IntP("trace_recent_span_buffer_size", "", 0,
+		"Number of the most recent trace spans kept in memory for debugging. Disabled if zero.")
+
+	cmd.PersistentFlags().StringP("trace_otlp_file", "", "",
+		"Path of a file trace spans are appended to in the OTLP JSON encoding. Disabled if empty.")
+
+	cmd.PersistentFlags().StringP("trace_otlp_endpoint", "", "",
+		"Address of an OTLP collector receiving trace spans over gRPC (example: 'otel-collector:4317').")
+
+	cmd.PersistentFlags().StringP("trace_otlp_compression", "", "",
+		"Compression of the requests sent to the OTLP collector, 'gzip' or 'none'.")
+
+	cmd.PersistentFlags().StringSliceP("trace_otlp_headers", "", nil,
+		"gRPC metadata sent to the OTLP collector, as key=value pairs (example: 'authorization=Bearer token').")
+
+	cmd.PersistentFlags().StringP("trace_fallback_zipkin_url", "", "",
+		"URL of Zipkin collector used while the primary Zipkin collector is failing.")
+
+	cmd.PersistentFlags().StringP("trace_fallback_jaeger_url", "", "",
+		"URL of Jaeger HTTP collector used while the primary Jaeger collector is failing.")
+
 	cmd.PersistentFlags().BoolP("trace_log_spans", "", false,
 		"Whether or not to log trace spans.")
+
+	cmd.PersistentFlags().BoolP("trace_console", "", false,
+		"Whether or not to print trace spans to stdout in a human readable form.")
+
+	cmd.PersistentFlags().BoolP("trace_propagate_sampler_tags", "", false,
+		"Whether the sampler tags of the root trace span are copied onto its other spans.")
+
+	cmd.PersistentFlags().StringSliceP("trace_baggage_to_tag_keys", "", nil,
+		"Baggage items copied into tags of the same name on every reported trace span.")
+
+	cmd.PersistentFlags().StringP("trace_tenant_tag_key", "", "",
+		"Baggage key holding the tenant of a request, suffixed to the service name in the 'service.instance' tag of trace spans.")
+
+	cmd.PersistentFlags().StringP("trace_operation_name_prefix", "", "",
+		"Prefix of the operation name of every reported trace span (example: 'payments.'). Disabled if empty.")
+
+	cmd.PersistentFlags().BoolP("trace_tag_caller_info", "", false,
+		"Whether sampled trace spans are tagged with the file and line they were started from.")
+
+	cmd.PersistentFlags().BoolP("trace_tag_deadlines", "", false,
+		"Whether trace spans are tagged with the time left before the deadline of their request.")
+
+	cmd.PersistentFlags().StringSliceP("trace_tag_allowlist", "", nil,
+		"Keys of the span tags forwarded to the trace collector. All tags are forwarded if empty.")
+
+	cmd.PersistentFlags().StringSliceP("trace_skip_paths", "", nil,
+		"Paths of inbound requests which are never traced, e.g. '/healthz'. A trailing '*' matches any path with that prefix.")
+
+	cmd.PersistentFlags().StringP("trace_id_response_header", "", "",
+		"Response header in which the trace ID of inbound requests is returned (example: 'X-Trace-Id'). Disabled if empty.")
+
+	cmd.PersistentFlags().BoolP("trace_record_payload_sizes", "", false,
+		"Whether the server spans of inbound requests are tagged with the sizes of the request and response bodies.")
+
+	cmd.PersistentFlags().IntP("trace_max_span_bytes", "", 0,
+		"Estimated size in bytes of trace spans past which a warning is logged. Disabled if zero.")
+
+	cmd.PersistentFlags().BoolP("trace_drop_oversized_spans", "", false,
+		"Whether trace spans over the maximum size are dropped rather than reported.")
+
+	cmd.PersistentFlags().StringP("trace_propagation", "", "",
+		"Format used to propagate trace context: 'jaeger', 'b3', 'w3c' or 'all'. Defaults to 'b3' with a Zipkin collector and 'jaeger' otherwise.")
+
+	cmd.PersistentFlags().StringSliceP("trace_extraction_priority", "", nil,
+		"Trace context formats ('jaeger', 'b3' or 'w3c') tried in order when extracting inbound trace context.")
+
+	cmd.PersistentFlags().BoolP("trace_debug_unfinished_spans", "", false,
+		"Whether the operations of trace spans never finished are logged when tracing is closed. Not meant for production.")
+
+	cmd.PersistentFlags().BoolP("trace_debug_propagation", "", false,
+		"Whether trace context injection and failed extraction are logged along with the header keys involved.")
+
+	cmd.PersistentFlags().StringP("trace_environment", "", "",
+		"Deployment environment ('dev', 'staging' or 'prod') selecting the default trace sampling rate.")
+
+	cmd.PersistentFlags().StringP("trace_service_namespace", "", "",
+		"Namespace of the service, reported with its traces.")
+
+	cmd.PersistentFlags().StringP("trace_service_version", "", "",
+		"Version of the service, reported with its traces.")
+
+	cmd.PersistentFlags().StringP("trace_sampler_type", "", "",
+		"Type of trace sampler: 'const', 'probabilistic' or 'ratelimiting'. All traces are sampled if unset.")
+
+	cmd.PersistentFlags().Float64P("trace_sampler_param", "", 0,
+		"Parameter of the trace sampler (example: 0.01 for a 'probabilistic' sampler).")
+
+	cmd.PersistentFlags().Float64P("trace_lower_bound_per_second", "", 0,
+		"Minimum number of traces per second sampled for every operation with a 'probabilistic' trace sampler. Disabled if zero.")
+
+	cmd.PersistentFlags().StringSliceP("trace_always_sample_peers", "", nil,
+		"Peer services whose traces are always sampled when a span is tagged with them in 'peer.service'.")
+
+	cmd.PersistentFlags().IntP("trace_always_sample_priority", "", 0,
+		"Minimum value of the 'priority' tag of trace spans which are always sampled. Disabled if zero.")
+
+	cmd.PersistentFlags().Float64P("trace_max_error_samples_per_second", "", 0,
+		"Maximum number of traces per second sampled because a span failed. Failed traces aren't sampled on that basis if zero.")
+
+	cmd.PersistentFlags().BoolP("trace_sampling_budgets", "", false,
+		"Experimental: whether traces stop being sampled once the sampling budget propagated in their baggage is exhausted.")
+
+	cmd.PersistentFlags().StringP("trace_sampling_server_url", "", "",
+		"URL of jaeger sampling server (example: 'http://jaeger-agent:5778/sampling') serving trace sampling strategies.")
+
+	cmd.PersistentFlags().StringP("trace_sampling_config_file", "", "",
+		"YAML or JSON file of per operation trace sampling rates.")
+
+	cmd.PersistentFlags().IntP("trace_adaptive_threshold_per_minute", "", 0,
+		"Number of traces per minute past which traces are sampled at the throttled rate instead. Disabled if zero.")
+
+	cmd.PersistentFlags().Float64P("trace_adaptive_throttled_sample_rate", "", 0,
+		"Sampling probability of traces past the adaptive threshold.")
+
+	cmd.PersistentFlags().BoolP("trace_log_sampling_decisions", "", false,
+		"Whether or not to log every trace sampling decision.")
+
+	cmd.PersistentFlags().DurationP("trace_first_span_per_operation_window", "", 0,
+		"Sample the first trace of every operation within each window of this length, regardless of the trace sampler. Disabled if zero.")
+
+	cmd.PersistentFlags().DurationP("trace_close_timeout", "", 0,
+		"Maximum time to wait for buffered trace spans to be flushed on shutdown. Waits for the flush to complete if zero.")
+
+	cmd.PersistentFlags().BoolP("trace_self_init", "", false,
+		"Whether a trace span recording the configuration of tracing is emitted at startup.")
+
+	cmd.PersistentFlags().BoolP("trace_flush_priority_spans", "", false,
+		"Whether debug trace spans are uploaded to the collector as soon as they finish rather than batched.")
+
+	cmd.PersistentFlags().BoolP("trace_independent_reporter_queues", "", false,
+		"Whether each trace span reporter gets its own queue, so that a slow one doesn't delay the others.")
+
+	cmd.PersistentFlags().BoolP("trace_retry_collector_connect", "", false,
+		"Whether trace spans are buffered until the trace collector can be connected to, if it can't be at startup.")
+
+	cmd.PersistentFlags().IntP("trace_reporter_max_retries", "", 0,
+		"Number of times a failed upload of trace spans to the collector is retried.")
+
+	cmd.PersistentFlags().DurationP("trace_reporter_retry_backoff", "", 0,
+		"Time to wait before the first retry of a failed upload of trace spans, doubling with every retry. Defaults to 200ms.")
+
+	cmd.PersistentFlags().IntP("trace_max_idle_conns", "", 0,
+		"Maximum number of idle connections kept open to the trace collector. Defaults to 10.")
+
+	cmd.PersistentFlags().DurationP("trace_idle_conn_timeout", "", 0,
+		"Time after which an idle connection to the trace collector is closed. Defaults to 90s.")
+
+	cmd.PersistentFlags().StringP("trace_tls_cert", "", "",
+		"Client certificate file presented to the trace collector.")
+
+	cmd.PersistentFlags().StringP("trace_tls_key", "", "",
+		"Client key file presented to the trace collector.")
+
+	cmd.PersistentFlags().StringP("trace_tls_ca", "", "",
+		"CA certificate file used to verify the trace collector.")
 }
@@ -0,0 +1,82 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func TestValidate(t *testing.T) {
+	rate := func(r float64) *float64 { return &r }
+	cases := []struct {
+		name    string
+		options Options
+		want    error
+	}{
+		{"empty", Options{}, nil},
+		{"jaeger", Options{JaegerURL: "http://jaeger:14268/api/traces", SamplerType: "probabilistic", SamplerParam: 0.1}, nil},
+		{"datadog with b3 propagation", Options{DatadogAgentURL: "http://localhost:8126", Propagation: PropagationB3}, nil},
+		{"datadog and jaeger", Options{DatadogAgentURL: "http://localhost:8126", JaegerURL: "http://jaeger"}, nil},
+		{"jaeger and zipkin", Options{JaegerURL: "http://jaeger", ZipkinURL: "http://zipkin"}, ErrMultipleOutputs},
+		{"zipkin fallback alone", Options{FallbackZipkinURL: "http://zipkin"}, ErrFallbackWithoutPrimary},
+		{"jaeger fallback alone", Options{ZipkinURL: "http://zipkin", FallbackJaegerURL: "http://jaeger"}, ErrFallbackWithoutPrimary},
+		{"dual encode alone", Options{DualEncodeValidationURL: "http://zipkin"}, ErrDualEncodeWithoutJaeger},
+		{"unknown propagation", Options{Propagation: "xray"}, ErrUnknownPropagation},
+		{"unknown extraction format", Options{ExtractionPriority: []PropagationFormat{PropagationAll}}, ErrUnknownExtractionFormat},
+		{"report sample rate", Options{ReportSampleRate: rate(1.5)}, ErrInvalidReportSampleRate},
+		{"relative skip path", Options{SkipPaths: []string{"healthz"}}, ErrInvalidSkipPath},
+		{"unsorted buckets", Options{DurationBuckets: []float64{0.1, 0.1}}, ErrUnsortedDurationBuckets},
+		{"negative recent spans", Options{RecentSpanBufferSize: -1}, ErrNegativeRecentSpanBufferSize},
+		{"negative max span bytes", Options{MaxSpanBytes: -1}, ErrNegativeMaxSpanBytes},
+		{"negative close timeout", Options{CloseTimeout: -time.Second}, ErrNegativeCloseTimeout},
+		{"negative retries", Options{ReporterMaxRetries: -1}, ErrNegativeReporterRetries},
+		{"negative retry backoff", Options{ReporterRetryBackoff: -time.Second}, ErrNegativeReporterRetries},
+		{"negative idle conns", Options{MaxIdleConns: -1}, ErrNegativeIdleConns},
+		{"TLS cert without key", Options{TLSCertFile: "cert.pem"}, ErrTLSCertWithoutKey},
+		{"TLS key without cert", Options{TLSKeyFile: "key.pem"}, ErrTLSKeyWithoutCert},
+		{"environment rate", Options{EnvironmentSampleRates: map[string]float64{"qa": 2}}, ErrInvalidEnvironmentSampleRate},
+		{"unknown environment", Options{Environment: "qa"}, ErrUnknownEnvironment},
+		{"custom environment", Options{Environment: "qa", EnvironmentSampleRates: map[string]float64{"qa": 0.5}}, nil},
+		{"unknown sampler type", Options{SamplerType: "remote"}, ErrUnknownSamplerType},
+		{"const sampler param", Options{SamplerType: "const", SamplerParam: 0.5}, ErrInvalidSamplerParam},
+		{"probabilistic sampler param", Options{SamplerType: "probabilistic", SamplerParam: 2}, ErrInvalidSamplerParam},
+		{"ratelimiting sampler param", Options{SamplerType: "ratelimiting", SamplerParam: -1}, ErrInvalidSamplerParam},
+		{"lower bound with const", Options{SamplerType: "const", LowerBoundPerSecond: 1}, ErrInvalidLowerBound},
+		{"negative lower bound", Options{LowerBoundPerSecond: -1}, ErrInvalidLowerBound},
+		{"negative error samples", Options{MaxErrorSamplesPerSecond: -1}, ErrNegativeMaxErrorSamples},
+		{"sampling fallback rate", Options{SamplingFallbackRate: rate(-0.1)}, ErrInvalidSamplingFallbackRate},
+		{"negative adaptive threshold", Options{AdaptiveThresholdPerMinute: -1}, ErrNegativeAdaptiveThreshold},
+		{"adaptive throttled rate", Options{AdaptiveThrottledSampleRate: 1.1}, ErrInvalidAdaptiveThrottledSampleRate},
+		{"negative first span window", Options{FirstSpanPerOperationWindow: -time.Second}, ErrNegativeFirstSpanPerOperationWindow},
+	}
+	for _, c := range cases {
+		if err := c.options.Validate(); err != c.want {
+			t.Errorf("%s: Validate() = %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+func TestAttachCobraFlags(t *testing.T) {
+	cmd := &cobra.Command{}
+	AttachCobraFlags(cmd)
+	for _, name := range []string{"trace_zipkin_url", "trace_jaeger_url", "trace_log_spans", "trace_service_version"} {
+		if cmd.PersistentFlags().Lookup(name) == nil {
+			t.Errorf("flag %s not attached", name)
+		}
+	}
+}
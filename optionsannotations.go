@@ -0,0 +1,129 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AnnotationPrefix prefixes the annotations read by OptionsFromAnnotations.
+const AnnotationPrefix = "tracing.aspenmesh.io/"
+
+// annotationFields maps the annotations read by OptionsFromAnnotations, less
+// AnnotationPrefix, to the Options field they set. Their names are those of
+// the Cobra flags of the same options, in kebab case and without the trace
+// prefix.
+var annotationFields = map[string]func(*Options) interface{}{
+	"zipkin-url":                   func(o *Options) interface{} { return &o.ZipkinURL },
+	"jaeger-url":                   func(o *Options) interface{} { return &o.JaegerURL },
+	"datadog-agent-url":            func(o *Options) interface{} { return &o.DatadogAgentURL },
+	"fallback-zipkin-url":          func(o *Options) interface{} { return &o.FallbackZipkinURL },
+	"fallback-jaeger-url":          func(o *Options) interface{} { return &o.FallbackJaegerURL },
+	"log-spans":                    func(o *Options) interface{} { return &o.LogTraceSpans },
+	"otlp-file":                    func(o *Options) interface{} { return &o.OTLPFile },
+	"otlp-endpoint":                func(o *Options) interface{} { return &o.OTLPEndpoint },
+	"otlp-compression":             func(o *Options) interface{} { return &o.OTLPCompression },
+	"environment":                  func(o *Options) interface{} { return &o.Environment },
+	"service-namespace":            func(o *Options) interface{} { return &o.ServiceNamespace },
+	"service-version":              func(o *Options) interface{} { return &o.ServiceVersion },
+	"propagation":                  func(o *Options) interface{} { return (*string)(&o.Propagation) },
+	"baggage-to-tag-keys":          func(o *Options) interface{} { return &o.BaggageToTagKeys },
+	"tenant-tag-key":               func(o *Options) interface{} { return &o.TenantTagKey },
+	"skip-paths":                   func(o *Options) interface{} { return &o.SkipPaths },
+	"id-response-header":           func(o *Options) interface{} { return &o.TraceIDResponseHeader },
+	"operation-name-prefix":        func(o *Options) interface{} { return &o.OperationNamePrefix },
+	"sampler-type":                 func(o *Options) interface{} { return &o.SamplerType },
+	"sampler-param":                func(o *Options) interface{} { return &o.SamplerParam },
+	"sampling-server-url":          func(o *Options) interface{} { return &o.SamplingServerURL },
+	"sampling-config-file":         func(o *Options) interface{} { return &o.SamplingConfigFile },
+	"always-sample-priority":       func(o *Options) interface{} { return &o.AlwaysSamplePriority },
+	"always-sample-peers":          func(o *Options) interface{} { return &o.AlwaysSamplePeers },
+	"max-error-samples-per-second": func(o *Options) interface{} { return &o.MaxErrorSamplesPerSecond },
+	"reporter-max-retries":         func(o *Options) interface{} { return &o.ReporterMaxRetries },
+	"reporter-retry-backoff":       func(o *Options) interface{} { return &o.ReporterRetryBackoff },
+	"close-timeout":                func(o *Options) interface{} { return &o.CloseTimeout },
+	"tls-cert":                     func(o *Options) interface{} { return &o.TLSCertFile },
+	"tls-key":                      func(o *Options) interface{} { return &o.TLSKeyFile },
+	"tls-ca":                       func(o *Options) interface{} { return &o.TLSCAFile },
+}
+
+// OptionsFromAnnotations builds Options from the annotations of a pod, such
+// as those injected by an operator, and validates them. Only annotations
+// starting with AnnotationPrefix are read, e.g.
+//
+//	tracing.aspenmesh.io/zipkin-url: http://zipkin:9411/api/v1/spans
+//	tracing.aspenmesh.io/sampler-param: "0.01"
+//	tracing.aspenmesh.io/skip-paths: /healthz,/debug/*
+//
+// The annotations are named like the Cobra flags of AttachCobraFlags, in
+// kebab case and without the trace prefix. Lists are comma separated and
+// durations use the syntax of time.ParseDuration. Unknown annotations with
+// the prefix and malformed values are rejected.
+func OptionsFromAnnotations(annotations map[string]string) (*Options, error) {
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		if strings.HasPrefix(key, AnnotationPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	// sorted so that the error returned doesn't depend on map order
+	sort.Strings(keys)
+
+	o := &Options{}
+	for _, key := range keys {
+		field, ok := annotationFields[strings.TrimPrefix(key, AnnotationPrefix)]
+		if !ok {
+			return nil, fmt.Errorf("unknown tracing annotation %s", key)
+		}
+		if err := setAnnotationField(field(o), annotations[key]); err != nil {
+			return nil, fmt.Errorf("invalid value %q of annotation %s: %v", annotations[key], key, err)
+		}
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// setAnnotationField parses value into the Options field pointed to by field.
+func setAnnotationField(field interface{}, value string) error {
+	var err error
+	switch f := field.(type) {
+	case *string:
+		*f = value
+	case *[]string:
+		*f = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*f = append(*f, item)
+			}
+		}
+	case *bool:
+		*f, err = strconv.ParseBool(value)
+	case *int:
+		*f, err = strconv.Atoi(value)
+	case *float64:
+		*f, err = strconv.ParseFloat(value, 64)
+	case *time.Duration:
+		*f, err = time.ParseDuration(value)
+	default:
+		panic(fmt.Sprintf("unsupported annotation field type %T", field))
+	}
+	return err
+}
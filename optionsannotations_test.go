@@ -0,0 +1,85 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOptionsFromAnnotations(t *testing.T) {
+	o, err := OptionsFromAnnotations(map[string]string{
+		"tracing.aspenmesh.io/zipkin-url":             "http://zipkin:9411/api/v1/spans",
+		"tracing.aspenmesh.io/log-spans":              "true",
+		"tracing.aspenmesh.io/sampler-type":           "probabilistic",
+		"tracing.aspenmesh.io/sampler-param":          "0.01",
+		"tracing.aspenmesh.io/always-sample-priority": "1",
+		"tracing.aspenmesh.io/skip-paths":             "/healthz, /debug/*",
+		"tracing.aspenmesh.io/close-timeout":          "5s",
+		"tracing.aspenmesh.io/propagation":            "b3",
+		"sidecar.istio.io/inject":                     "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Options{
+		ZipkinURL:            "http://zipkin:9411/api/v1/spans",
+		LogTraceSpans:        true,
+		SamplerType:          "probabilistic",
+		SamplerParam:         0.01,
+		AlwaysSamplePriority: 1,
+		SkipPaths:            []string{"/healthz", "/debug/*"},
+		CloseTimeout:         5 * time.Second,
+		Propagation:          PropagationB3,
+	}
+	if !reflect.DeepEqual(o, want) {
+		t.Errorf("got %+v, want %+v", o, want)
+	}
+}
+
+func TestOptionsFromAnnotationsInvalid(t *testing.T) {
+	for name, tt := range map[string]struct {
+		annotations map[string]string
+		want        error
+	}{
+		"unknown":  {map[string]string{"tracing.aspenmesh.io/zipkin": "http://zipkin:9411"}, nil},
+		"bool":     {map[string]string{"tracing.aspenmesh.io/log-spans": "yes please"}, nil},
+		"int":      {map[string]string{"tracing.aspenmesh.io/reporter-max-retries": "three"}, nil},
+		"float":    {map[string]string{"tracing.aspenmesh.io/sampler-param": "1%"}, nil},
+		"duration": {map[string]string{"tracing.aspenmesh.io/close-timeout": "5"}, nil},
+		"invalid": {map[string]string{
+			"tracing.aspenmesh.io/zipkin-url": "http://zipkin:9411",
+			"tracing.aspenmesh.io/jaeger-url": "http://jaeger:14268",
+		}, ErrMultipleOutputs},
+	} {
+		o, err := OptionsFromAnnotations(tt.annotations)
+		if err == nil {
+			t.Errorf("%s: got options %+v, want an error", name, o)
+		} else if tt.want != nil && err != tt.want {
+			t.Errorf("%s: got %v, want %v", name, err, tt.want)
+		}
+	}
+}
+
+func TestAnnotationFieldTypes(t *testing.T) {
+	for name, field := range annotationFields {
+		switch f := field(&Options{}).(type) {
+		case *string, *[]string, *bool, *int, *float64, *time.Duration:
+		default:
+			t.Errorf("annotation %s sets a field of unsupported type %T", name, f)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"fmt"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// yamlOptions is the schema accepted by OptionsFromYAML. Every key is
+// optional and maps onto the Options field of the same meaning:
+//
+//	collectors:
+//	  zipkin_url: http://zipkin:9411/api/v1/spans
+//	  jaeger_url: ""
+//	  datadog_agent_url: ""
+//	  fallback_zipkin_url: ""
+//	  fallback_jaeger_url: ""
+//	  dual_encode_validation_url: ""
+//	  log_spans: false
+//	  console: false
+//	  recent_span_buffer_size: 0
+//	  otlp_file: ""
+//	  otlp_endpoint: ""
+//	  otlp_compression: gzip
+//	  otlp_headers: {authorization: Bearer token}
+//	  self_init: false
+//	reporter:
+//	  max_retries: 3
+//	  retry_backoff: 200ms
+//	  retry_connect: false
+//	  independent_queues: false
+//	  report_sample_rate: 1
+//	  export_sampling_rules: [{operation: "GET /audit*", rate: 0.01}]
+//	  log_sampling_rules: []
+//	  close_timeout: 5s
+//	  flush_priority_spans: false
+//	  max_idle_conns: 10
+//	  idle_conn_timeout: 90s
+//	  max_span_bytes: 0
+//	  drop_oversized_spans: false
+//	sampler:
+//	  type: probabilistic
+//	  param: 0.01
+//	  lower_bound_per_second: 0
+//	  server_url: ""
+//	  fallback_rate: 0.001
+//	  adaptive_threshold_per_minute: 0
+//	  adaptive_throttled_sample_rate: 0
+//	  always_sample_priority: 0
+//	  always_sample_peers: [payments]
+//	  max_error_samples_per_second: 0
+//	  budgets: false
+//	  first_span_per_operation_window: 0s
+//	  config_file: ""
+//	  log_decisions: false
+//	  baggage_rules: {"debug:true": 1}
+//	  environment_rates: {"canary": 0.5}
+//	  propagate_tags: false
+//	propagation:
+//	  format: b3
+//	  disable_default: false
+//	  extraction_priority: [w3c, b3]
+//	  debug: false
+//	tags:
+//	  environment: prod
+//	  service_namespace: payments
+//	  service_version: 1.4.2
+//	  baggage_keys: [tenant]
+//	  url_param_allowlist: [page]
+//	  url_redact_values: false
+//	  allowlist: []
+//	  tenant_key: tenant
+//	  caller_info: false
+//	  deadlines: false
+//	  operation_name_prefix: ""
+//	handler:
+//	  skip_paths: [/healthz, /debug/*]
+//	  trace_id_response_header: X-Trace-Id
+//	  record_payload_sizes: false
+//	tls:
+//	  cert_file: /etc/certs/cert.pem
+//	  key_file: /etc/certs/key.pem
+//	  ca_file: /etc/certs/ca.pem
+//
+// JSON documents of the same shape are accepted too.
+type yamlOptions struct {
+	Collectors struct {
+		ZipkinURL               string            `yaml:"zipkin_url"`
+		JaegerURL               string            `yaml:"jaeger_url"`
+		DatadogAgentURL         string            `yaml:"datadog_agent_url"`
+		FallbackZipkinURL       string            `yaml:"fallback_zipkin_url"`
+		FallbackJaegerURL       string            `yaml:"fallback_jaeger_url"`
+		DualEncodeValidationURL string            `yaml:"dual_encode_validation_url"`
+		LogSpans                bool              `yaml:"log_spans"`
+		Console                 bool              `yaml:"console"`
+		RecentSpanBufferSize    int               `yaml:"recent_span_buffer_size"`
+		OTLPFile                string            `yaml:"otlp_file"`
+		OTLPEndpoint            string            `yaml:"otlp_endpoint"`
+		OTLPCompression         string            `yaml:"otlp_compression"`
+		OTLPHeaders             map[string]string `yaml:"otlp_headers"`
+		SelfInit                bool              `yaml:"self_init"`
+	} `yaml:"collectors"`
+
+	Reporter struct {
+		MaxRetries         int                  `yaml:"max_retries"`
+		RetryBackoff       time.Duration        `yaml:"retry_backoff"`
+		RetryConnect       bool                 `yaml:"retry_connect"`
+		IndependentQueues  bool                 `yaml:"independent_queues"`
+		ReportSampleRate   *float64             `yaml:"report_sample_rate"`
+		ExportRules        []ReportSamplingRule `yaml:"export_sampling_rules"`
+		LogRules           []ReportSamplingRule `yaml:"log_sampling_rules"`
+		CloseTimeout       time.Duration        `yaml:"close_timeout"`
+		FlushPriority      bool                 `yaml:"flush_priority_spans"`
+		MaxIdleConns       int                  `yaml:"max_idle_conns"`
+		IdleConnTimeout    time.Duration        `yaml:"idle_conn_timeout"`
+		MaxSpanBytes       int                  `yaml:"max_span_bytes"`
+		DropOversizedSpans bool                 `yaml:"drop_oversized_spans"`
+	} `yaml:"reporter"`
+
+	Sampler struct {
+		Type                        string             `yaml:"type"`
+		Param                       float64            `yaml:"param"`
+		LowerBoundPerSecond         float64            `yaml:"lower_bound_per_second"`
+		ServerURL                   string             `yaml:"server_url"`
+		FallbackRate                *float64           `yaml:"fallback_rate"`
+		AdaptiveThresholdPerMinute  int                `yaml:"adaptive_threshold_per_minute"`
+		AdaptiveThrottledSampleRate float64            `yaml:"adaptive_throttled_sample_rate"`
+		AlwaysSamplePriority        int                `yaml:"always_sample_priority"`
+		AlwaysSamplePeers           []string           `yaml:"always_sample_peers"`
+		MaxErrorSamplesPerSecond    float64            `yaml:"max_error_samples_per_second"`
+		Budgets                     bool               `yaml:"budgets"`
+		FirstSpanPerOperationWindow time.Duration      `yaml:"first_span_per_operation_window"`
+		ConfigFile                  string             `yaml:"config_file"`
+		LogDecisions                bool               `yaml:"log_decisions"`
+		BaggageRules                map[string]float64 `yaml:"baggage_rules"`
+		EnvironmentRates            map[string]float64 `yaml:"environment_rates"`
+		PropagateTags               bool               `yaml:"propagate_tags"`
+	} `yaml:"sampler"`
+
+	Propagation struct {
+		Format             PropagationFormat   `yaml:"format"`
+		DisableDefault     bool                `yaml:"disable_default"`
+		ExtractionPriority []PropagationFormat `yaml:"extraction_priority"`
+		Debug              bool                `yaml:"debug"`
+	} `yaml:"propagation"`
+
+	Tags struct {
+		Environment       string   `yaml:"environment"`
+		ServiceNamespace  string   `yaml:"service_namespace"`
+		ServiceVersion    string   `yaml:"service_version"`
+		BaggageKeys       []string `yaml:"baggage_keys"`
+		URLParamAllowlist []string `yaml:"url_param_allowlist"`
+		URLRedactValues   bool     `yaml:"url_redact_values"`
+		Allowlist         []string `yaml:"allowlist"`
+		TenantKey         string   `yaml:"tenant_key"`
+		CallerInfo        bool     `yaml:"caller_info"`
+		Deadlines         bool     `yaml:"deadlines"`
+		OperationPrefix   string   `yaml:"operation_name_prefix"`
+	} `yaml:"tags"`
+
+	Handler struct {
+		SkipPaths             []string `yaml:"skip_paths"`
+		TraceIDResponseHeader string   `yaml:"trace_id_response_header"`
+		RecordPayloadSizes    bool     `yaml:"record_payload_sizes"`
+	} `yaml:"handler"`
+
+	TLS struct {
+		CertFile string `yaml:"cert_file"`
+		KeyFile  string `yaml:"key_file"`
+		CAFile   string `yaml:"ca_file"`
+	} `yaml:"tls"`
+}
+
+// OptionsFromYAML builds Options from a YAML (or JSON) document, such as one
+// pushed by a platform to all of its services, and validates them. The schema
+// groups the serializable Options under collectors, reporter, sampler,
+// propagation, tags, handler and tls; unknown keys are rejected. Options which
+// can only be set from code, like hooks and registries, are left unset and may
+// be filled in by the caller before calling Configure.
+func OptionsFromYAML(data []byte) (*Options, error) {
+	y := &yamlOptions{}
+	if err := yaml.UnmarshalStrict(data, y); err != nil {
+		return nil, fmt.Errorf("could not parse tracing options: %v", err)
+	}
+	o := &Options{
+		ZipkinURL:               y.Collectors.ZipkinURL,
+		JaegerURL:               y.Collectors.JaegerURL,
+		DatadogAgentURL:         y.Collectors.DatadogAgentURL,
+		FallbackZipkinURL:       y.Collectors.FallbackZipkinURL,
+		FallbackJaegerURL:       y.Collectors.FallbackJaegerURL,
+		DualEncodeValidationURL: y.Collectors.DualEncodeValidationURL,
+		LogTraceSpans:           y.Collectors.LogSpans,
+		ConsoleExporter:         y.Collectors.Console,
+		RecentSpanBufferSize:    y.Collectors.RecentSpanBufferSize,
+		OTLPFile:                y.Collectors.OTLPFile,
+		OTLPEndpoint:            y.Collectors.OTLPEndpoint,
+		OTLPCompression:         y.Collectors.OTLPCompression,
+		OTLPHeaders:             y.Collectors.OTLPHeaders,
+		TraceSelfInit:           y.Collectors.SelfInit,
+
+		ReporterMaxRetries:        y.Reporter.MaxRetries,
+		ReporterRetryBackoff:      y.Reporter.RetryBackoff,
+		RetryCollectorConnect:     y.Reporter.RetryConnect,
+		IndependentReporterQueues: y.Reporter.IndependentQueues,
+		ReportSampleRate:          y.Reporter.ReportSampleRate,
+		ExportSamplingRules:       y.Reporter.ExportRules,
+		LogSamplingRules:          y.Reporter.LogRules,
+		CloseTimeout:              y.Reporter.CloseTimeout,
+		FlushPrioritySpans:        y.Reporter.FlushPriority,
+		MaxIdleConns:              y.Reporter.MaxIdleConns,
+		IdleConnTimeout:           y.Reporter.IdleConnTimeout,
+		MaxSpanBytes:              y.Reporter.MaxSpanBytes,
+		DropOversizedSpans:        y.Reporter.DropOversizedSpans,
+
+		SamplerType:                 y.Sampler.Type,
+		SamplerParam:                y.Sampler.Param,
+		LowerBoundPerSecond:         y.Sampler.LowerBoundPerSecond,
+		SamplingServerURL:           y.Sampler.ServerURL,
+		SamplingFallbackRate:        y.Sampler.FallbackRate,
+		AdaptiveThresholdPerMinute:  y.Sampler.AdaptiveThresholdPerMinute,
+		AdaptiveThrottledSampleRate: y.Sampler.AdaptiveThrottledSampleRate,
+		AlwaysSamplePriority:        y.Sampler.AlwaysSamplePriority,
+		AlwaysSamplePeers:           y.Sampler.AlwaysSamplePeers,
+		MaxErrorSamplesPerSecond:    y.Sampler.MaxErrorSamplesPerSecond,
+		SamplingBudgets:             y.Sampler.Budgets,
+		FirstSpanPerOperationWindow: y.Sampler.FirstSpanPerOperationWindow,
+		SamplingConfigFile:          y.Sampler.ConfigFile,
+		LogSamplingDecisions:        y.Sampler.LogDecisions,
+		BaggageSamplingRules:        y.Sampler.BaggageRules,
+		EnvironmentSampleRates:      y.Sampler.EnvironmentRates,
+		PropagateSamplerTags:        y.Sampler.PropagateTags,
+
+		Propagation:              y.Propagation.Format,
+		DisableDefaultPropagator: y.Propagation.DisableDefault,
+		ExtractionPriority:       y.Propagation.ExtractionPriority,
+		DebugPropagation:         y.Propagation.Debug,
+
+		Environment:          y.Tags.Environment,
+		ServiceNamespace:     y.Tags.ServiceNamespace,
+		ServiceVersion:       y.Tags.ServiceVersion,
+		BaggageToTagKeys:     y.Tags.BaggageKeys,
+		URLTagParamAllowlist: y.Tags.URLParamAllowlist,
+		URLTagRedactValues:   y.Tags.URLRedactValues,
+		TagAllowlist:         y.Tags.Allowlist,
+		TenantTagKey:         y.Tags.TenantKey,
+		TagCallerInfo:        y.Tags.CallerInfo,
+		TagDeadlines:         y.Tags.Deadlines,
+		OperationNamePrefix:  y.Tags.OperationPrefix,
+
+		SkipPaths:             y.Handler.SkipPaths,
+		TraceIDResponseHeader: y.Handler.TraceIDResponseHeader,
+		RecordPayloadSizes:    y.Handler.RecordPayloadSizes,
+
+		TLSCertFile: y.TLS.CertFile,
+		TLSKeyFile:  y.TLS.KeyFile,
+		TLSCAFile:   y.TLS.CAFile,
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
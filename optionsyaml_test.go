@@ -0,0 +1,81 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOptionsFromYAML(t *testing.T) {
+	o, err := OptionsFromYAML([]byte(`
+collectors:
+  jaeger_url: http://jaeger-collector:14268/api/traces
+reporter:
+  max_retries: 3
+  retry_backoff: 200ms
+  report_sample_rate: 0.5
+sampler:
+  type: probabilistic
+  param: 0.01
+propagation:
+  format: w3c
+  extraction_priority: [w3c, b3]
+tags:
+  environment: prod
+  baggage_keys: [tenant]
+handler:
+  skip_paths: [/healthz]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rate := 0.5
+	want := &Options{
+		JaegerURL:            "http://jaeger-collector:14268/api/traces",
+		ReporterMaxRetries:   3,
+		ReporterRetryBackoff: 200 * time.Millisecond,
+		ReportSampleRate:     &rate,
+		SamplerType:          "probabilistic",
+		SamplerParam:         0.01,
+		Propagation:          PropagationW3C,
+		ExtractionPriority:   []PropagationFormat{PropagationW3C, PropagationB3},
+		Environment:          "prod",
+		BaggageToTagKeys:     []string{"tenant"},
+		SkipPaths:            []string{"/healthz"},
+	}
+	if !reflect.DeepEqual(o, want) {
+		t.Errorf("got %+v, want %+v", o, want)
+	}
+}
+
+func TestOptionsFromYAMLInvalid(t *testing.T) {
+	for name, tt := range map[string]struct {
+		yaml string
+		want error
+	}{
+		"unknown key": {"collectors:\n  jeager_url: http://jaeger:14268\n", nil},
+		"malformed":   {"collectors: [", nil},
+		"invalid":     {"collectors:\n  zipkin_url: http://zipkin:9411\n  jaeger_url: http://jaeger:14268\n", ErrMultipleOutputs},
+	} {
+		o, err := OptionsFromYAML([]byte(tt.yaml))
+		if err == nil {
+			t.Errorf("%s: got options %+v, want an error", name, o)
+		} else if tt.want != nil && err != tt.want {
+			t.Errorf("%s: got %v, want %v", name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// instrumentation scope of the spans exported over OTLP
+const otlpScopeName = "github.com/aspenmesh/tracing-go"
+
+// OTLP span kinds and status codes
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpKindClient   = 3
+	otlpKindProducer = 4
+	otlpKindConsumer = 5
+
+	otlpStatusError = 2
+)
+
+// The types below are the subset of the OTLP JSON encoding of an
+// ExportTraceServiceRequest needed to describe jaeger spans. 64 bit integers
+// are encoded as strings and IDs as hex, as the encoding requires.
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// otlpValue converts a tag or log field value to an OTLP value. Values of
+// types OTLP has no equivalent for are rendered as strings.
+func otlpValue(value interface{}) otlpAnyValue {
+	switch v := value.(type) {
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case float32:
+		f := float64(v)
+		return otlpAnyValue{DoubleValue: &f}
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	case int:
+		i := strconv.FormatInt(int64(v), 10)
+		return otlpAnyValue{IntValue: &i}
+	case int64:
+		i := strconv.FormatInt(v, 10)
+		return otlpAnyValue{IntValue: &i}
+	}
+	if f, ok := numeric(value); ok {
+		i := strconv.FormatInt(int64(f), 10)
+		return otlpAnyValue{IntValue: &i}
+	}
+	s := fmt.Sprint(value)
+	return otlpAnyValue{StringValue: &s}
+}
+
+var otlpKinds = map[string]int{
+	string(ext.SpanKindRPCServerEnum): otlpKindServer,
+	string(ext.SpanKindRPCClientEnum): otlpKindClient,
+	string(ext.SpanKindProducerEnum):  otlpKindProducer,
+	string(ext.SpanKindConsumerEnum):  otlpKindConsumer,
+}
+
+// toOTLPSpan converts span to the OTLP span model. The span.kind and error
+// tags become the kind and status of the span, and logs become events named
+// by their event field.
+func toOTLPSpan(span *jaeger.Span) otlpSpan {
+	sc := span.SpanContext()
+	traceID := sc.TraceID()
+	out := otlpSpan{
+		TraceID:           fmt.Sprintf("%016x%016x", traceID.High, traceID.Low),
+		SpanID:            fmt.Sprintf("%016x", uint64(sc.SpanID())),
+		Name:              span.OperationName(),
+		Kind:              otlpKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(span.StartTime().UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.StartTime().Add(span.Duration()).UnixNano(), 10),
+	}
+	if sc.ParentID() != 0 {
+		out.ParentSpanID = fmt.Sprintf("%016x", uint64(sc.ParentID()))
+	}
+	tags := span.Tags()
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := tags[k]
+		switch k {
+		case string(ext.SpanKind):
+			if kind, ok := otlpKinds[fmt.Sprint(v)]; ok {
+				out.Kind = kind
+				continue
+			}
+		case string(ext.Error):
+			if isErr, ok := v.(bool); ok {
+				if isErr {
+					out.Status = &otlpStatus{Code: otlpStatusError}
+				}
+				continue
+			}
+		}
+		out.Attributes = append(out.Attributes, otlpKeyValue{k, otlpValue(v)})
+	}
+	for _, l := range span.Logs() {
+		out.Events = append(out.Events, toOTLPEvent(l))
+	}
+	return out
+}
+
+func toOTLPEvent(l ot.LogRecord) otlpEvent {
+	e := otlpEvent{TimeUnixNano: strconv.FormatInt(l.Timestamp.UnixNano(), 10), Name: "log"}
+	for _, f := range l.Fields {
+		if f.Key() == "event" {
+			e.Name = fmt.Sprint(f.Value())
+			continue
+		}
+		e.Attributes = append(e.Attributes, otlpKeyValue{f.Key(), otlpValue(f.Value())})
+	}
+	return e
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/golang/glog"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// The types below wrap spans of the model of otlp.go into an
+// ExportTraceServiceRequest in the OTLP JSON encoding.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+// otlpFileReporter appends every span it is given to a file as an
+// ExportTraceServiceRequest in the OTLP JSON encoding, one per line.
+type otlpFileReporter struct {
+	mu       sync.Mutex
+	file     *os.File
+	enc      *json.Encoder
+	resource otlpResource
+}
+
+func newOTLPFileReporter(path, serviceName string) (*otlpFileReporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	name := otlpValue(serviceName)
+	return &otlpFileReporter{
+		file:     f,
+		enc:      json.NewEncoder(f),
+		resource: otlpResource{Attributes: []otlpKeyValue{{"service.name", name}}},
+	}, nil
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *otlpFileReporter) Report(span *jaeger.Span) {
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: r.resource,
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: otlpScopeName},
+			Spans: []otlpSpan{toOTLPSpan(span)},
+		}},
+	}}}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(req); err != nil {
+		glog.Warningf("Could not write span %q to %s: %v", span.OperationName(), r.file.Name(), err)
+	}
+}
+
+// Close implements the Close() method of jaeger.Reporter.
+func (r *otlpFileReporter) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.file.Close(); err != nil {
+		glog.Warningf("Could not close %s: %v", r.file.Name(), err)
+	}
+}
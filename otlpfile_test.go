@@ -0,0 +1,81 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+)
+
+func TestOTLPFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "otlp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spans.json")
+
+	var id uint64
+	closer := configureRecording(t, &Options{
+		OTLPFile:         path,
+		RandomNumberFunc: func() uint64 { id++; return 0xa0 + id },
+	})
+	start := time.Unix(1500000000, 0)
+	root, ctx := StartSpanAt(context.Background(), "GET /users", start, ext.SpanKindRPCServer)
+	child, _ := StartSpanAt(ctx, "query", start.Add(time.Millisecond))
+	child.SetTag("rows", 3)
+	child.SetTag("cached", false)
+	child.SetTag("ratio", 0.5)
+	ext.Error.Set(child, true)
+	child.FinishWithOptions(ot.FinishOptions{
+		FinishTime: start.Add(3 * time.Millisecond),
+		LogRecords: []ot.LogRecord{{
+			Timestamp: start.Add(2 * time.Millisecond),
+			Fields:    []otlog.Field{otlog.String("event", "cache miss"), otlog.String("key", "user:42")},
+		}},
+	})
+	FinishAt(root, start.Add(5*time.Millisecond))
+	closer.Close()
+
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an ExportTraceServiceRequest per line, in the OTLP/JSON encoding
+	want := `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"svc"}}]},` +
+		`"scopeSpans":[{"scope":{"name":"github.com/aspenmesh/tracing-go"},"spans":[{` +
+		`"traceId":"000000000000000000000000000000a2","spanId":"00000000000000a3","parentSpanId":"00000000000000a2",` +
+		`"name":"query","kind":1,"startTimeUnixNano":"1500000000001000000","endTimeUnixNano":"1500000000003000000",` +
+		`"attributes":[{"key":"cached","value":{"boolValue":false}},{"key":"ratio","value":{"doubleValue":0.5}},` +
+		`{"key":"rows","value":{"intValue":"3"}}],` +
+		`"events":[{"timeUnixNano":"1500000000002000000","name":"cache miss",` +
+		`"attributes":[{"key":"key","value":{"stringValue":"user:42"}}]}],"status":{"code":2}}]}]}]}` + "\n" +
+		`{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"svc"}}]},` +
+		`"scopeSpans":[{"scope":{"name":"github.com/aspenmesh/tracing-go"},"spans":[{` +
+		`"traceId":"000000000000000000000000000000a2","spanId":"00000000000000a2",` +
+		`"name":"GET /users","kind":2,"startTimeUnixNano":"1500000000000000000","endTimeUnixNano":"1500000000005000000",` +
+		`"attributes":[{"key":"sampler.param","value":{"boolValue":true}},{"key":"sampler.type","value":{"stringValue":"const"}}]}]}]}]}` + "\n"
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
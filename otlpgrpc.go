@@ -0,0 +1,339 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	jaeger "github.com/uber/jaeger-client-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+)
+
+// method of the OTLP trace service receiving spans
+const otlpExportMethod = "/opentelemetry.proto.collector.trace.v1.TraceService/Export"
+
+// values of Options.OTLPCompression
+const (
+	OTLPCompressionNone = "none"
+	OTLPCompressionGzip = "gzip"
+)
+
+// number of spans buffered by otlpTransport before it flushes on its own
+const otlpBatchSize = 100
+
+// protobuf wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+// protoWriter encodes messages in the protobuf wire format. The OTLP messages
+// are encoded by hand, field by field, from the model of otlp.go, rather
+// than through generated code.
+type protoWriter struct {
+	*proto.Buffer
+}
+
+func newProtoWriter() protoWriter {
+	return protoWriter{proto.NewBuffer(nil)}
+}
+
+func (w protoWriter) key(field, wireType int) {
+	w.EncodeVarint(uint64(field<<3 | wireType))
+}
+
+func (w protoWriter) varint(field int, v uint64) {
+	w.key(field, protoVarint)
+	w.EncodeVarint(v)
+}
+
+func (w protoWriter) fixed64(field int, v uint64) {
+	w.key(field, protoFixed64)
+	w.EncodeFixed64(v)
+}
+
+func (w protoWriter) bytes(field int, b []byte) {
+	w.key(field, protoBytes)
+	w.EncodeRawBytes(b)
+}
+
+func (w protoWriter) string(field int, s string) {
+	w.key(field, protoBytes)
+	w.EncodeStringBytes(s)
+}
+
+// message encodes the message written by encode as field.
+func (w protoWriter) message(field int, encode func(protoWriter)) {
+	m := newProtoWriter()
+	encode(m)
+	w.bytes(field, m.Bytes())
+}
+
+// hexID decodes an ID of the OTLP JSON model.
+func hexID(id string) ([]byte, error) {
+	b, err := hex.DecodeString(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP ID %q: %v", id, err)
+	}
+	return b, nil
+}
+
+// unixNano decodes a timestamp of the OTLP JSON model.
+func unixNano(t string) (uint64, error) {
+	n, err := strconv.ParseUint(t, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid OTLP timestamp %q: %v", t, err)
+	}
+	return n, nil
+}
+
+// encodeOTLPRequest encodes the spans as an ExportTraceServiceRequest. An
+// error is returned, and nothing encoded, if a span can't be encoded.
+func encodeOTLPRequest(w protoWriter, resource otlpResource, spans []otlpSpan) error {
+	encoded := make([][]byte, 0, len(spans))
+	for _, span := range spans {
+		m := newProtoWriter()
+		if err := encodeOTLPSpan(m, span); err != nil {
+			return err
+		}
+		encoded = append(encoded, m.Bytes())
+	}
+
+	attributes := newProtoWriter()
+	if err := encodeOTLPAttributes(attributes, 1, resource.Attributes); err != nil {
+		return err
+	}
+
+	// ExportTraceServiceRequest.resource_spans
+	w.message(1, func(w protoWriter) {
+		// ResourceSpans.resource
+		w.bytes(1, attributes.Bytes())
+		// ResourceSpans.scope_spans
+		w.message(2, func(w protoWriter) {
+			w.message(1, func(w protoWriter) {
+				w.string(1, otlpScopeName)
+			})
+			for _, span := range encoded {
+				w.bytes(2, span)
+			}
+		})
+	})
+	return nil
+}
+
+func encodeOTLPSpan(w protoWriter, span otlpSpan) error {
+	traceID, err := hexID(span.TraceID)
+	if err != nil {
+		return err
+	}
+	spanID, err := hexID(span.SpanID)
+	if err != nil {
+		return err
+	}
+	var parentSpanID []byte
+	if span.ParentSpanID != "" {
+		if parentSpanID, err = hexID(span.ParentSpanID); err != nil {
+			return err
+		}
+	}
+	start, err := unixNano(span.StartTimeUnixNano)
+	if err != nil {
+		return err
+	}
+	end, err := unixNano(span.EndTimeUnixNano)
+	if err != nil {
+		return err
+	}
+	eventTimes := make([]uint64, len(span.Events))
+	for i, e := range span.Events {
+		if eventTimes[i], err = unixNano(e.TimeUnixNano); err != nil {
+			return err
+		}
+	}
+
+	w.bytes(1, traceID)
+	w.bytes(2, spanID)
+	if parentSpanID != nil {
+		w.bytes(4, parentSpanID)
+	}
+	w.string(5, span.Name)
+	w.varint(6, uint64(span.Kind))
+	w.fixed64(7, start)
+	w.fixed64(8, end)
+	if err := encodeOTLPAttributes(w, 9, span.Attributes); err != nil {
+		return err
+	}
+	for i, e := range span.Events {
+		w.message(11, func(w protoWriter) {
+			w.fixed64(1, eventTimes[i])
+			w.string(2, e.Name)
+			err = encodeOTLPAttributes(w, 3, e.Attributes)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if span.Status != nil {
+		w.message(15, func(w protoWriter) {
+			w.varint(3, uint64(span.Status.Code))
+		})
+	}
+	return nil
+}
+
+// encodeOTLPAttributes encodes attributes as repeated KeyValue field.
+func encodeOTLPAttributes(w protoWriter, field int, attributes []otlpKeyValue) error {
+	for _, kv := range attributes {
+		var err error
+		w.message(field, func(w protoWriter) {
+			w.string(1, kv.Key)
+			w.message(2, func(w protoWriter) {
+				v := kv.Value
+				switch {
+				case v.StringValue != nil:
+					w.string(1, *v.StringValue)
+				case v.BoolValue != nil:
+					b := uint64(0)
+					if *v.BoolValue {
+						b = 1
+					}
+					w.varint(2, b)
+				case v.IntValue != nil:
+					var i int64
+					if i, err = strconv.ParseInt(*v.IntValue, 10, 64); err == nil {
+						w.varint(3, uint64(i))
+					}
+				case v.DoubleValue != nil:
+					w.fixed64(4, math.Float64bits(*v.DoubleValue))
+				}
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("invalid OTLP attribute %q: %v", kv.Key, err)
+		}
+	}
+	return nil
+}
+
+// otlpCodec passes the messages of otlpTransport, which are encoded already,
+// to gRPC as they are. Received messages are only kept when read into a
+// *[]byte, so the responses of the collector are discarded.
+type otlpCodec struct{}
+
+func (otlpCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected OTLP message type %T", v)
+	}
+	return b, nil
+}
+
+func (otlpCodec) Unmarshal(data []byte, v interface{}) error {
+	if b, ok := v.(*[]byte); ok {
+		*b = append((*b)[:0], data...)
+	}
+	return nil
+}
+
+func (otlpCodec) String() string {
+	return "otlp"
+}
+
+// otlpTransport is a jaeger.Transport sending spans to an OTLP collector
+// over gRPC.
+type otlpTransport struct {
+	conn     *grpc.ClientConn
+	md       metadata.MD
+	callOpts []grpc.CallOption
+	resource otlpResource
+
+	spans []otlpSpan
+}
+
+// newOTLPTransport returns an otlpTransport sending spans to the collector at
+// endpoint, over TLS if the options configure a certificate or CA. The
+// connection is established in the background.
+func newOTLPTransport(endpoint, serviceName string, options *Options) (*otlpTransport, error) {
+	tlsConfig, err := collectorTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	dialOpt := grpc.WithInsecure()
+	if tlsConfig != nil {
+		dialOpt = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+	}
+	conn, err := grpc.Dial(endpoint, dialOpt)
+	if err != nil {
+		return nil, err
+	}
+	callOpts := []grpc.CallOption{grpc.CallCustomCodec(otlpCodec{})}
+	if options.OTLPCompression == OTLPCompressionGzip {
+		callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
+	}
+	return &otlpTransport{
+		conn:     conn,
+		md:       metadata.New(options.OTLPHeaders),
+		callOpts: callOpts,
+		resource: otlpResource{Attributes: []otlpKeyValue{{"service.name", otlpValue(serviceName)}}},
+	}, nil
+}
+
+// Append implements the Append() method of jaeger.Transport.
+func (t *otlpTransport) Append(span *jaeger.Span) (int, error) {
+	t.spans = append(t.spans, toOTLPSpan(span))
+	if len(t.spans) >= otlpBatchSize {
+		return t.Flush()
+	}
+	return 0, nil
+}
+
+// Flush implements the Flush() method of jaeger.Transport.
+func (t *otlpTransport) Flush() (int, error) {
+	n := len(t.spans)
+	if n == 0 {
+		return 0, nil
+	}
+	w := newProtoWriter()
+	err := encodeOTLPRequest(w, t.resource, t.spans)
+	t.spans = t.spans[:0]
+	if err != nil {
+		return n, fmt.Errorf("could not encode spans for the OTLP collector: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), t.md), httpTimeout)
+	defer cancel()
+	if err := t.conn.Invoke(ctx, otlpExportMethod, w.Bytes(), nil, t.callOpts...); err != nil {
+		return n, fmt.Errorf("error from OTLP collector: %v", err)
+	}
+	return n, nil
+}
+
+// Close implements the Close() method of jaeger.Transport.
+func (t *otlpTransport) Close() error {
+	_, err := t.Flush()
+	if closeErr := t.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+)
+
+// receivedOTLPRequest is a request received by mockOTLPCollector.
+type receivedOTLPRequest struct {
+	method      string
+	compression string
+	md          metadata.MD
+	body        []byte
+}
+
+// mockOTLPCollector is a gRPC server recording the requests it receives.
+type mockOTLPCollector struct {
+	server *grpc.Server
+	addr   string
+
+	mu          sync.Mutex
+	compression string
+	requests    []receivedOTLPRequest
+}
+
+func newMockOTLPCollector(t *testing.T) *mockOTLPCollector {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &mockOTLPCollector{addr: lis.Addr().String()}
+	c.server = grpc.NewServer(
+		grpc.CustomCodec(otlpCodec{}),
+		grpc.StatsHandler(c),
+		grpc.UnknownServiceHandler(c.handle))
+	go c.server.Serve(lis)
+	return c
+}
+
+func (c *mockOTLPCollector) handle(srv interface{}, stream grpc.ServerStream) error {
+	var body []byte
+	if err := stream.RecvMsg(&body); err != nil {
+		return err
+	}
+	method, _ := grpc.MethodFromServerStream(stream)
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	c.mu.Lock()
+	c.requests = append(c.requests, receivedOTLPRequest{method, c.compression, md, body})
+	c.mu.Unlock()
+	return stream.SendMsg([]byte{})
+}
+
+func (c *mockOTLPCollector) received() []receivedOTLPRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]receivedOTLPRequest(nil), c.requests...)
+}
+
+// HandleRPC implements the HandleRPC() method of stats.Handler, recording the
+// compression of the request being received.
+func (c *mockOTLPCollector) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	if h, ok := s.(*stats.InHeader); ok {
+		c.mu.Lock()
+		c.compression = h.Compression
+		c.mu.Unlock()
+	}
+}
+
+func (c *mockOTLPCollector) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (c *mockOTLPCollector) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (c *mockOTLPCollector) HandleConn(ctx context.Context, s stats.ConnStats) {}
+
+func TestOTLPEndpoint(t *testing.T) {
+	collector := newMockOTLPCollector(t)
+	defer collector.server.Stop()
+
+	closer, err := Configure("svc", &Options{
+		OTLPEndpoint:    collector.addr,
+		OTLPCompression: OTLPCompressionGzip,
+		OTLPHeaders:     map[string]string{"authorization": "Bearer secret"},
+		SamplerType:     "const",
+		SamplerParam:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	span, _ := StartSpan(context.Background(), "exported-op")
+	span.Finish()
+	closer.Close()
+
+	requests := collector.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	if req.method != otlpExportMethod {
+		t.Errorf("got method %q, want %q", req.method, otlpExportMethod)
+	}
+	if req.compression != OTLPCompressionGzip {
+		t.Errorf("got compression %q, want gzip", req.compression)
+	}
+	if got := req.md.Get("authorization"); len(got) != 1 || got[0] != "Bearer secret" {
+		t.Errorf("got authorization metadata %q", got)
+	}
+	if !bytes.Contains(req.body, []byte("exported-op")) || !bytes.Contains(req.body, []byte("svc")) {
+		t.Errorf("request doesn't carry the span: %q", req.body)
+	}
+}
+
+func TestValidateOTLPCompression(t *testing.T) {
+	for compression, want := range map[string]error{
+		"":                  nil,
+		OTLPCompressionNone: nil,
+		OTLPCompressionGzip: nil,
+		"zstd":              ErrUnknownOTLPCompression,
+	} {
+		o := &Options{OTLPEndpoint: "otel-collector:4317", OTLPCompression: compression}
+		if err := o.Validate(); err != want {
+			t.Errorf("Validate() with compression %q = %v, want %v", compression, err, want)
+		}
+	}
+}
+
+func TestEncodeOTLPRequestInvalid(t *testing.T) {
+	valid := func() otlpSpan {
+		return otlpSpan{
+			TraceID:           "4bf92f3577b34da6a3ce929d0e0e4736",
+			SpanID:            "00f067aa0ba902b7",
+			Name:              "op",
+			StartTimeUnixNano: "1514764800000000000",
+			EndTimeUnixNano:   "1514764801000000000",
+			Attributes:        []otlpKeyValue{{"count", otlpValue(3)}},
+			Events:            []otlpEvent{{TimeUnixNano: "1514764800500000000", Name: "event"}},
+		}
+	}
+	invalidInt := "three"
+	for name, invalidate := range map[string]func(*otlpSpan){
+		"trace ID":        func(s *otlpSpan) { s.TraceID = "not hex" },
+		"parent span ID":  func(s *otlpSpan) { s.ParentSpanID = "00f067aa0ba902bz" },
+		"start time":      func(s *otlpSpan) { s.StartTimeUnixNano = "" },
+		"event time":      func(s *otlpSpan) { s.Events[0].TimeUnixNano = "-1" },
+		"int attribute":   func(s *otlpSpan) { s.Attributes[0].Value = otlpAnyValue{IntValue: &invalidInt} },
+		"event attribute": func(s *otlpSpan) { s.Events[0].Attributes = []otlpKeyValue{{"n", otlpAnyValue{IntValue: &invalidInt}}} },
+	} {
+		span := valid()
+		invalidate(&span)
+		w := newProtoWriter()
+		if err := encodeOTLPRequest(w, otlpResource{}, []otlpSpan{valid(), span}); err == nil {
+			t.Errorf("%s: encoded an invalid span", name)
+		} else if len(w.Bytes()) != 0 {
+			t.Errorf("%s: got %d bytes encoded along with error %v", name, len(w.Bytes()), err)
+		}
+	}
+
+	w := newProtoWriter()
+	if err := encodeOTLPRequest(w, otlpResource{}, []otlpSpan{valid()}); err != nil || len(w.Bytes()) == 0 {
+		t.Errorf("got %d bytes and error %v encoding a valid span", len(w.Bytes()), err)
+	}
+}
@@ -0,0 +1,374 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+	zp "github.com/uber/jaeger-client-go/zipkin"
+)
+
+// PropagationFormat selects the headers used to propagate span contexts
+// between services.
+type PropagationFormat string
+
+const (
+	// PropagationDefault uses B3 headers when a Zipkin collector is
+	// configured and jaeger's uber-trace-id header otherwise.
+	PropagationDefault PropagationFormat = ""
+
+	// PropagationJaeger uses jaeger's uber-trace-id header.
+	PropagationJaeger PropagationFormat = "jaeger"
+
+	// PropagationB3 uses Zipkin's X-B3-* headers.
+	PropagationB3 PropagationFormat = "b3"
+
+	// PropagationW3C uses the W3C Trace Context traceparent header.
+	PropagationW3C PropagationFormat = "w3c"
+
+	// PropagationAll injects the headers of every other format, and extracts
+	// from the first format present in the order jaeger, B3, W3C. It is meant
+	// for fleets migrating between formats.
+	PropagationAll PropagationFormat = "all"
+)
+
+func (f PropagationFormat) valid() bool {
+	switch f {
+	case PropagationDefault, PropagationJaeger, PropagationB3, PropagationW3C, PropagationAll:
+		return true
+	}
+	return false
+}
+
+// propagator both injects and extracts span contexts.
+type propagator interface {
+	jaeger.Injector
+	jaeger.Extractor
+}
+
+// newPropagator returns the propagator for the given format, or nil if
+// jaeger's built-in propagation should be left in place. defaultB3 selects B3
+// for the default format.
+func newPropagator(format PropagationFormat, defaultB3 bool) (propagator, error) {
+	switch format {
+	case PropagationDefault:
+		if defaultB3 {
+			return zp.NewZipkinB3HTTPHeaderPropagator(), nil
+		}
+		return nil, nil
+	case PropagationJaeger:
+		return nil, nil
+	case PropagationB3:
+		return zp.NewZipkinB3HTTPHeaderPropagator(), nil
+	case PropagationW3C:
+		return w3cPropagator{}, nil
+	case PropagationAll:
+		return newPriorityPropagator([]PropagationFormat{PropagationJaeger, PropagationB3, PropagationW3C})
+	}
+	return nil, fmt.Errorf("unknown propagation format %q", format)
+}
+
+// propagationOptions returns the tracer options installing the injectors and
+// extractors configured by the options, if any.
+func propagationOptions(options *Options) ([]jaeger.TracerOption, error) {
+	prop, err := newPropagator(options.Propagation, options.ZipkinURL != "" && !options.DisableDefaultPropagator)
+	if err != nil {
+		return nil, err
+	}
+	var injector jaeger.Injector
+	var extractor jaeger.Extractor
+	if prop != nil {
+		injector, extractor = prop, prop
+	}
+	if len(options.ExtractionPriority) > 0 {
+		if extractor, err = newPriorityPropagator(options.ExtractionPriority); err != nil {
+			return nil, err
+		}
+	}
+	if options.DebugPropagation {
+		// jaeger's built-in propagation has to be made explicit to be wrapped
+		native, _ := singlePropagator(PropagationJaeger)
+		if injector == nil {
+			injector = native
+		}
+		if extractor == nil {
+			extractor = native
+		}
+		injector = debugInjector{injector}
+		extractor = debugExtractor{extractor}
+	}
+
+	var opts []jaeger.TracerOption
+	for _, format := range []interface{}{ot.HTTPHeaders, ot.TextMap} {
+		if injector != nil {
+			opts = append(opts, jaeger.TracerOptions.Injector(format, injector))
+		}
+		if extractor != nil {
+			opts = append(opts, jaeger.TracerOptions.Extractor(format, extractor))
+		}
+	}
+	return opts, nil
+}
+
+// singlePropagator returns the propagator handling exactly one of the jaeger,
+// B3 and W3C formats.
+func singlePropagator(format PropagationFormat) (propagator, error) {
+	switch format {
+	case PropagationJaeger:
+		return jaeger.NewHTTPHeaderPropagator((&jaeger.HeadersConfig{}).ApplyDefaults(), *jaeger.NewNullMetrics()), nil
+	case PropagationB3:
+		return zp.NewZipkinB3HTTPHeaderPropagator(), nil
+	case PropagationW3C:
+		return w3cPropagator{}, nil
+	}
+	return nil, fmt.Errorf("unknown propagation format %q", format)
+}
+
+// newPriorityPropagator returns a propagator extracting span contexts with the
+// first of formats whose headers are present, and injecting all of them.
+func newPriorityPropagator(formats []PropagationFormat) (compositePropagator, error) {
+	c := make(compositePropagator, 0, len(formats))
+	for _, format := range formats {
+		p, err := singlePropagator(format)
+		if err != nil {
+			return nil, err
+		}
+		c = append(c, p)
+	}
+	return c, nil
+}
+
+// compositePropagator injects span contexts with every one of its propagators
+// and extracts them with the first one which finds a span context.
+type compositePropagator []propagator
+
+// Inject implements the Inject() method of jaeger.Injector.
+func (c compositePropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	for _, p := range c {
+		if err := p.Inject(sc, carrier); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Extract implements the Extract() method of jaeger.Extractor.
+//
+// A format whose headers are present but corrupt doesn't prevent the others
+// from being tried; its error is only returned if no format succeeds.
+func (c compositePropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	var firstErr error
+	for _, p := range c {
+		sc, err := p.Extract(carrier)
+		if err == nil {
+			return sc, nil
+		}
+		if err != ot.ErrSpanContextNotFound && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return jaeger.SpanContext{}, firstErr
+	}
+	return jaeger.SpanContext{}, ot.ErrSpanContextNotFound
+}
+
+// debugInjector logs every span context injected by the wrapped injector
+// along with the keys it set, for Options.DebugPropagation.
+type debugInjector struct {
+	jaeger.Injector
+}
+
+// Inject implements the Inject() method of jaeger.Injector.
+func (d debugInjector) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	writer, ok := carrier.(ot.TextMapWriter)
+	if !ok {
+		return d.Injector.Inject(sc, carrier)
+	}
+	recorder := &keyRecorder{TextMapWriter: writer}
+	err := d.Injector.Inject(sc, recorder)
+	if err != nil {
+		glog.Infof("Could not inject span context %v: %v", sc, err)
+	} else {
+		glog.Infof("Injected span context %v into keys %v", sc, recorder.keys)
+	}
+	return err
+}
+
+// keyRecorder records the keys set through the wrapped writer.
+type keyRecorder struct {
+	ot.TextMapWriter
+	keys []string
+}
+
+// Set implements the Set() method of ot.TextMapWriter.
+func (r *keyRecorder) Set(key, val string) {
+	r.keys = append(r.keys, key)
+	r.TextMapWriter.Set(key, val)
+}
+
+// debugExtractor logs when the wrapped extractor finds no span context,
+// along with the keys it was given, for Options.DebugPropagation.
+type debugExtractor struct {
+	jaeger.Extractor
+}
+
+// Extract implements the Extract() method of jaeger.Extractor.
+func (d debugExtractor) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	sc, err := d.Extractor.Extract(carrier)
+	if err != nil {
+		var keys []string
+		if reader, ok := carrier.(ot.TextMapReader); ok {
+			reader.ForeachKey(func(key, val string) error {
+				keys = append(keys, key)
+				return nil
+			})
+		}
+		sort.Strings(keys)
+		glog.Infof("Extracted no span context from keys %v: %v", keys, err)
+	}
+	return sc, err
+}
+
+const traceparentHeader = "traceparent"
+
+// w3cPropagator propagates span contexts in the W3C Trace Context traceparent
+// header. Baggage and tracestate are not propagated.
+type w3cPropagator struct{}
+
+// Inject implements the Inject() method of jaeger.Injector.
+func (w3cPropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	writer, ok := carrier.(ot.TextMapWriter)
+	if !ok {
+		return ot.ErrInvalidCarrier
+	}
+	writer.Set(traceparentHeader, formatTraceparent(sc))
+	return nil
+}
+
+// Extract implements the Extract() method of jaeger.Extractor.
+func (w3cPropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	reader, ok := carrier.(ot.TextMapReader)
+	if !ok {
+		return jaeger.SpanContext{}, ot.ErrInvalidCarrier
+	}
+	var traceparent string
+	err := reader.ForeachKey(func(key, value string) error {
+		if strings.ToLower(key) == traceparentHeader {
+			traceparent = value
+		}
+		return nil
+	})
+	if err != nil {
+		return jaeger.SpanContext{}, err
+	}
+	if traceparent == "" {
+		return jaeger.SpanContext{}, ot.ErrSpanContextNotFound
+	}
+	return parseTraceparent(traceparent)
+}
+
+// formatTraceparent renders sc as a version 00 traceparent value.
+func formatTraceparent(sc jaeger.SpanContext) string {
+	flags := "00"
+	if sc.IsSampled() {
+		flags = "01"
+	}
+	traceID := sc.TraceID()
+	return fmt.Sprintf("00-%016x%016x-%016x-%s", traceID.High, traceID.Low, uint64(sc.SpanID()), flags)
+}
+
+// parseTraceparent parses a traceparent value into a span context.
+func parseTraceparent(value string) (jaeger.SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return jaeger.SpanContext{}, ot.ErrSpanContextCorrupted
+	}
+	// Version 00 has exactly four fields, later versions may append more.
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return jaeger.SpanContext{}, ot.ErrSpanContextCorrupted
+	}
+	traceID, err := jaeger.TraceIDFromString(parts[1])
+	if err != nil || !traceID.IsValid() {
+		return jaeger.SpanContext{}, ot.ErrSpanContextCorrupted
+	}
+	spanID, err := strconv.ParseUint(parts[2], 16, 64)
+	if err != nil || spanID == 0 {
+		return jaeger.SpanContext{}, ot.ErrSpanContextCorrupted
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return jaeger.SpanContext{}, ot.ErrSpanContextCorrupted
+	}
+	return jaeger.NewSpanContext(traceID, jaeger.SpanID(spanID), 0, flags&1 == 1, nil), nil
+}
+
+// MarshalSpanContext renders sc as a single opaque string in jaeger's text
+// representation, for storing trace context where no carrier fits, such as a
+// job queue payload or a database column. Baggage is not included.
+func MarshalSpanContext(sc ot.SpanContext) (string, error) {
+	jsc, ok := sc.(jaeger.SpanContext)
+	if !ok || !jsc.IsValid() {
+		return "", ot.ErrInvalidSpanContext
+	}
+	return jsc.String(), nil
+}
+
+// spanContextCodec extracts the span contexts rendered by MarshalSpanContext.
+// Like any tracer, it marks the contexts it extracts as remote, so that the
+// spans continuing them keep their sampling decision rather than being
+// sampled again.
+var spanContextCodec, _ = jaeger.NewTracer("span-context-codec", jaeger.NewConstSampler(false), jaeger.NewNullReporter())
+
+// UnmarshalSpanContext parses a string returned by MarshalSpanContext, e.g. to
+// start a span resuming the trace with ot.ChildOf or ot.FollowsFrom. Like an
+// extracted context, it keeps the sampling decision of the trace.
+func UnmarshalSpanContext(s string) (ot.SpanContext, error) {
+	return spanContextCodec.Extract(ot.TextMap, ot.TextMapCarrier{jaeger.TraceContextHeaderName: s})
+}
+
+// Inject injects sc into carrier, e.g. the envelope of a message or the input
+// of a workflow, using the propagation format of the tracer of ctx, as set by
+// WithTracer, or of the global tracer, which is the one Extract uses. It is
+// the building block of the carrier specific helpers.
+func Inject(ctx context.Context, sc ot.SpanContext, carrier ot.TextMapWriter) error {
+	return propagatorFor(ctx).Inject(sc, ot.TextMap, carrier)
+}
+
+// Extract extracts a span context from carrier, such as one written by
+// Inject, using the propagation format of the tracer of ctx.
+func Extract(ctx context.Context, carrier ot.TextMapReader) (ot.SpanContext, error) {
+	return propagatorFor(ctx).Extract(ot.TextMap, carrier)
+}
+
+// InjectMap injects sc into m, e.g. the string keyed headers of a message
+// broker client, using the propagation format of the tracer of ctx. m must
+// not be nil.
+func InjectMap(ctx context.Context, sc ot.SpanContext, m map[string]string) error {
+	return Inject(ctx, sc, ot.TextMapCarrier(m))
+}
+
+// ExtractMap extracts a span context from m, e.g. the string keyed headers
+// of a consumed message, using the propagation format of the tracer of ctx.
+func ExtractMap(ctx context.Context, m map[string]string) (ot.SpanContext, error) {
+	return Extract(ctx, ot.TextMapCarrier(m))
+}
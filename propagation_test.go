@@ -0,0 +1,304 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang/glog"
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+	zp "github.com/uber/jaeger-client-go/zipkin"
+)
+
+func TestMarshalSpanContextRoundTrip(t *testing.T) {
+	for _, sampled := range []bool{true, false} {
+		tracer, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(sampled), jaeger.NewNullReporter(),
+			jaeger.TracerOptions.Gen128Bit(true))
+		defer closer.Close()
+		span := tracer.StartSpan("op")
+		want := span.Context().(jaeger.SpanContext)
+
+		s, err := MarshalSpanContext(want)
+		if err != nil {
+			t.Fatalf("sampled=%t: MarshalSpanContext: %v", sampled, err)
+		}
+		sc, err := UnmarshalSpanContext(s)
+		if err != nil {
+			t.Fatalf("sampled=%t: UnmarshalSpanContext(%q): %v", sampled, s, err)
+		}
+		got := sc.(jaeger.SpanContext)
+		if got.TraceID() != want.TraceID() || got.SpanID() != want.SpanID() || got.IsSampled() != sampled {
+			t.Errorf("sampled=%t: got %v, want %v", sampled, got, want)
+		}
+
+		// a tracer sampling every trace must keep the decision of the context
+		resumer, resumerCloser := jaeger.NewTracer("svc", jaeger.NewConstSampler(!sampled), jaeger.NewNullReporter())
+		defer resumerCloser.Close()
+		child := resumer.StartSpan("child", ot.ChildOf(sc))
+		if got := child.Context().(jaeger.SpanContext); got.IsSampled() != sampled || got.TraceID() != want.TraceID() {
+			t.Errorf("sampled=%t: got child %v", sampled, got)
+		}
+	}
+}
+
+func TestUnmarshalSpanContextInvalid(t *testing.T) {
+	if _, err := UnmarshalSpanContext("garbage"); err == nil {
+		t.Error("UnmarshalSpanContext succeeded on garbage")
+	}
+	if _, err := MarshalSpanContext(nil); err != ot.ErrInvalidSpanContext {
+		t.Errorf("got %v, want ot.ErrInvalidSpanContext", err)
+	}
+}
+
+func TestPropagationAll(t *testing.T) {
+	defer configureCollector(t, &Options{Propagation: PropagationAll}).Close()
+	tracer := ot.GlobalTracer()
+	span := tracer.StartSpan("op")
+	defer span.Finish()
+	want := span.Context().(jaeger.SpanContext)
+
+	header := http.Header{}
+	if err := tracer.Inject(span.Context(), ot.HTTPHeaders, ot.HTTPHeadersCarrier(header)); err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range [][]string{
+		{"Uber-Trace-Id"},
+		{"X-B3-Traceid", "X-B3-Spanid", "X-B3-Sampled"},
+		{"Traceparent"},
+	} {
+		only := http.Header{}
+		for _, key := range family {
+			if header.Get(key) == "" {
+				t.Errorf("%s header not injected: %v", key, header)
+			}
+			only.Set(key, header.Get(key))
+		}
+		sc, err := tracer.Extract(ot.HTTPHeaders, ot.HTTPHeadersCarrier(only))
+		if err != nil {
+			t.Errorf("Extract from %v: %v", only, err)
+			continue
+		}
+		if got := sc.(jaeger.SpanContext); got.TraceID() != want.TraceID() || got.SpanID() != want.SpanID() {
+			t.Errorf("got %v from %v, want %v", got, only, want)
+		}
+	}
+}
+
+func TestDisableDefaultPropagator(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		closer := configureRecording(t, &Options{
+			ZipkinURL:                "http://127.0.0.1:9411/api/v1/spans",
+			CollectorReporter:        func(jaeger.Transport) jaeger.Reporter { return jaeger.NewInMemoryReporter() },
+			DisableDefaultPropagator: disable,
+		})
+		span := ot.StartSpan("op")
+		header := http.Header{}
+		if err := ot.GlobalTracer().Inject(span.Context(), ot.HTTPHeaders, ot.HTTPHeadersCarrier(header)); err != nil {
+			t.Fatal(err)
+		}
+		span.Finish()
+		closer.Close()
+
+		if b3 := header.Get("X-B3-Traceid") != ""; b3 == disable {
+			t.Errorf("disable=%t: got B3 headers %t: %v", disable, b3, header)
+		}
+		if native := header.Get("Uber-Trace-Id") != ""; native != disable {
+			t.Errorf("disable=%t: got jaeger headers %t: %v", disable, native, header)
+		}
+	}
+}
+
+func TestExtractionPriority(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-B3-Traceid", "000000000000000000000000000000b3")
+	header.Set("X-B3-Spanid", "00000000000000b3")
+	header.Set("X-B3-Sampled", "1")
+	header.Set("Traceparent", "00-00000000000000000000000000000c3c-0000000000000c3c-01")
+
+	for _, tt := range []struct {
+		priority []PropagationFormat
+		want     uint64
+	}{
+		{[]PropagationFormat{PropagationW3C, PropagationB3}, 0xc3c},
+		{[]PropagationFormat{PropagationB3, PropagationW3C}, 0xb3},
+		// skipped when the carrier has none of its headers
+		{[]PropagationFormat{PropagationJaeger, PropagationB3}, 0xb3},
+	} {
+		closer := configureCollector(t, &Options{ExtractionPriority: tt.priority})
+		sc, err := ot.GlobalTracer().Extract(ot.HTTPHeaders, ot.HTTPHeadersCarrier(header))
+		closer.Close()
+		if err != nil {
+			t.Errorf("%v: %v", tt.priority, err)
+			continue
+		}
+		if got := sc.(jaeger.SpanContext); got.TraceID().Low != tt.want || uint64(got.SpanID()) != tt.want {
+			t.Errorf("%v: got %v, want IDs %x", tt.priority, got, tt.want)
+		}
+	}
+}
+
+// propagationLines returns the number of info lines logged while injecting a
+// span context and extracting from a request without one.
+func propagationLines(t *testing.T) int64 {
+	t.Helper()
+	before := glog.Stats.Info.Lines()
+	tracer := ot.GlobalTracer()
+	span := tracer.StartSpan("op")
+	defer span.Finish()
+	if err := tracer.Inject(span.Context(), ot.HTTPHeaders, ot.HTTPHeadersCarrier(http.Header{})); err != nil {
+		t.Fatal(err)
+	}
+	missing := http.Header{"Content-Type": {"text/plain"}}
+	if _, err := tracer.Extract(ot.HTTPHeaders, ot.HTTPHeadersCarrier(missing)); err != ot.ErrSpanContextNotFound {
+		t.Errorf("got %v extracting from %v, want ot.ErrSpanContextNotFound", err, missing)
+	}
+	return glog.Stats.Info.Lines() - before
+}
+
+func TestDebugPropagation(t *testing.T) {
+	closer := configureCollector(t, &Options{DebugPropagation: true})
+	if n := propagationLines(t); n != 2 {
+		t.Errorf("got %d lines logged, want one for the injection and one for the failed extraction", n)
+	}
+	closer.Close()
+
+	closer = configureCollector(t, &Options{})
+	defer closer.Close()
+	if n := propagationLines(t); n != 0 {
+		t.Errorf("got %d lines logged with DebugPropagation unset, want none", n)
+	}
+}
+
+// envelope is a carrier of a custom message format, keeping its headers as
+// a list.
+type envelope struct {
+	headers [][2]string
+}
+
+func (e *envelope) Set(key, val string) {
+	e.headers = append(e.headers, [2]string{key, val})
+}
+
+func (e *envelope) ForeachKey(handler func(key, val string) error) error {
+	for _, h := range e.headers {
+		if err := handler(h[0], h[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestInjectExtract(t *testing.T) {
+	for propagation, key := range map[PropagationFormat]string{
+		PropagationDefault: jaeger.TraceContextHeaderName,
+		PropagationB3:      "x-b3-traceid",
+		PropagationW3C:     "traceparent",
+	} {
+		closer := configureRecording(t, &Options{Propagation: propagation})
+		span := ot.StartSpan("op")
+		want := span.Context().(jaeger.SpanContext)
+
+		carrier := &envelope{}
+		if err := Inject(context.Background(), span.Context(), carrier); err != nil {
+			t.Fatalf("%q: Inject: %v", propagation, err)
+		}
+		var injected bool
+		for _, h := range carrier.headers {
+			injected = injected || strings.EqualFold(h[0], key)
+		}
+		if !injected {
+			t.Errorf("%q: got headers %v, want %s", propagation, carrier.headers, key)
+		}
+		sc, err := Extract(context.Background(), carrier)
+		if err != nil {
+			t.Errorf("%q: Extract(%v): %v", propagation, carrier.headers, err)
+		} else if got := sc.(jaeger.SpanContext); got.TraceID() != want.TraceID() || got.SpanID() != want.SpanID() {
+			t.Errorf("%q: got %v, want %v", propagation, got, want)
+		}
+		span.Finish()
+		closer.Close()
+	}
+}
+
+func TestInjectExtractMap(t *testing.T) {
+	defer configureCollector(t, &Options{}).Close()
+
+	span := ot.StartSpan("produce")
+	defer span.Finish()
+	span.SetBaggageItem("tenant", "acme")
+	want := span.Context().(jaeger.SpanContext)
+
+	headers := map[string]string{"content-type": "application/json"}
+	if err := InjectMap(context.Background(), span.Context(), headers); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := ExtractMap(context.Background(), headers)
+	if err != nil {
+		t.Fatalf("ExtractMap(%v): %v", headers, err)
+	}
+	got := sc.(jaeger.SpanContext)
+	if got.TraceID() != want.TraceID() || got.SpanID() != want.SpanID() || got.IsSampled() != want.IsSampled() {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var tenant string
+	got.ForeachBaggageItem(func(k, v string) bool {
+		if k == "tenant" {
+			tenant = v
+		}
+		return true
+	})
+	if tenant != "acme" {
+		t.Errorf("baggage lost in %v", headers)
+	}
+
+	if _, err := ExtractMap(context.Background(), map[string]string{}); err != ot.ErrSpanContextNotFound {
+		t.Errorf("got %v from empty headers, want ot.ErrSpanContextNotFound", err)
+	}
+}
+
+func TestInjectExtractMapWithTracer(t *testing.T) {
+	defer configureRecording(t, &Options{}).Close()
+
+	b3 := zp.NewZipkinB3HTTPHeaderPropagator()
+	tracer, closer := jaeger.NewTracer("tenant", jaeger.NewConstSampler(true), jaeger.NewNullReporter(),
+		jaeger.TracerOptions.Injector(ot.TextMap, b3), jaeger.TracerOptions.Extractor(ot.TextMap, b3))
+	defer closer.Close()
+	ctx := WithTracer(context.Background(), tracer)
+	span := tracer.StartSpan("produce")
+	defer span.Finish()
+	want := span.Context().(jaeger.SpanContext)
+
+	headers := map[string]string{}
+	if err := InjectMap(ctx, span.Context(), headers); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := headers["x-b3-traceid"]; !ok {
+		t.Fatalf("got headers %v, want B3 headers", headers)
+	}
+	sc, err := ExtractMap(ctx, headers)
+	if err != nil {
+		t.Fatalf("ExtractMap(%v): %v", headers, err)
+	}
+	if got := sc.(jaeger.SpanContext); got.TraceID() != want.TraceID() || got.SpanID() != want.SpanID() {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := ExtractMap(context.Background(), headers); err != ot.ErrSpanContextNotFound {
+		t.Errorf("got %v with the global tracer, want ot.ErrSpanContextNotFound", err)
+	}
+}
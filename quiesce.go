@@ -0,0 +1,123 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+var (
+	// quiescing is set by Quiesce to make the span helpers hand out no-op
+	// spans.
+	quiescing int32
+
+	// the holder returned by the last successful Configure
+	activeMu sync.Mutex
+	active   *holder
+
+	// how often Quiesce checks for in-flight spans
+	quiescePollInterval = 10 * time.Millisecond
+)
+
+// Quiesce prepares the tracer installed by Configure for shutdown. The span
+// helpers of this package start handing out no-op spans, spans which are
+// already in flight are given until ctx is done to finish, and every reported
+// span is then flushed to the collector.
+//
+// Unlike Close it stops new work from being traced, so it is meant to be
+// called from a SIGTERM handler ahead of Close. Spans can't be reported once
+// Quiesce has returned. ctx's error is returned if spans were still in flight
+// when it was done; the flush happens regardless.
+func Quiesce(ctx context.Context) error {
+	atomic.StoreInt32(&quiescing, 1)
+
+	activeMu.Lock()
+	h := active
+	activeMu.Unlock()
+	if h == nil {
+		return nil
+	}
+
+	err := h.inflight.wait(ctx)
+	h.closer.Close()
+	return err
+}
+
+func isQuiescing() bool {
+	return atomic.LoadInt32(&quiescing) == 1
+}
+
+// inflightObserver counts the spans which have been started but not yet
+// finished.
+type inflightObserver struct {
+	n int64
+}
+
+// OnStartSpan implements the OnStartSpan() method of jaeger.ContribObserver.
+func (o *inflightObserver) OnStartSpan(sp ot.Span, operationName string, options ot.StartSpanOptions) (jaeger.ContribSpanObserver, bool) {
+	atomic.AddInt64(&o.n, 1)
+	return &inflightSpanObserver{o: o}, true
+}
+
+// wait returns once no span is in flight, or with ctx's error once ctx is
+// done.
+func (o *inflightObserver) wait(ctx context.Context) error {
+	ticker := time.NewTicker(quiescePollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&o.n) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+type inflightSpanObserver struct {
+	o        *inflightObserver
+	finished int32 // Finish may be called more than once
+}
+
+func (*inflightSpanObserver) OnSetOperationName(operationName string) {}
+
+func (*inflightSpanObserver) OnSetTag(key string, value interface{}) {}
+
+func (s *inflightSpanObserver) OnFinish(options ot.FinishOptions) {
+	if atomic.CompareAndSwapInt32(&s.finished, 0, 1) {
+		atomic.AddInt64(&s.o.n, -1)
+	}
+}
+
+// onceCloser closes the wrapped closer on its first call to Close only, so
+// that Quiesce and holder.Close can both flush the tracer.
+type onceCloser struct {
+	once   sync.Once
+	closer io.Closer
+}
+
+func (c *onceCloser) Close() error {
+	c.once.Do(func() {
+		c.closer.Close()
+	})
+	return nil
+}
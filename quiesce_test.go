@@ -0,0 +1,69 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestQuiesce(t *testing.T) {
+	defer configureCollector(t, &Options{}).Close()
+
+	inflight, _ := StartSpan(context.Background(), "inflight")
+	done, _ := StartSpan(context.Background(), "done")
+	done.Finish()
+	done.Finish() // must not make up for the span in flight
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := Quiesce(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v with a span in flight, want context.DeadlineExceeded", err)
+	}
+	if Enabled() {
+		t.Error("still enabled once quiescing")
+	}
+	if span, _ := StartSpan(context.Background(), "late"); isJaegerSpan(span) {
+		t.Error("span started while quiescing")
+	}
+	inflight.Finish()
+}
+
+func TestQuiesceWaitsForSpansInFlight(t *testing.T) {
+	tracer := configureCollector(t, &Options{})
+	defer tracer.Close()
+
+	inflight, _ := StartSpan(context.Background(), "inflight")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		inflight.Finish()
+	}()
+	if err := Quiesce(context.Background()); err != nil {
+		t.Errorf("Quiesce: %v", err)
+	}
+	// flushed by Quiesce, before the tracer is closed
+	if spans := tracer.collector.received(); len(spans) != 1 || spans[0].Operation != "inflight" {
+		t.Errorf("got spans %+v, want the one in flight", spans)
+	}
+}
+
+func isJaegerSpan(span ot.Span) bool {
+	_, ok := span.(*jaeger.Span)
+	return ok
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"sync"
+	"time"
+
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// RecordedSpan is a copy of a finished span kept by Options.RecentSpanBufferSize.
+type RecordedSpan struct {
+	TraceID   string                 `json:"trace_id"`
+	SpanID    string                 `json:"span_id"`
+	ParentID  string                 `json:"parent_id,omitempty"`
+	Operation string                 `json:"operation"`
+	Start     time.Time              `json:"start"`
+	Duration  time.Duration          `json:"duration"`
+	Tags      map[string]interface{} `json:"tags,omitempty"`
+}
+
+// recentReporter keeps the most recently reported spans in a ring buffer.
+type recentReporter struct {
+	mu    sync.Mutex
+	spans []RecordedSpan
+	next  int
+	full  bool
+}
+
+func newRecentReporter(size int) *recentReporter {
+	return &recentReporter{spans: make([]RecordedSpan, size)}
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *recentReporter) Report(span *jaeger.Span) {
+	sc := span.SpanContext()
+	recorded := RecordedSpan{
+		TraceID:   sc.TraceID().String(),
+		SpanID:    sc.SpanID().String(),
+		Operation: span.OperationName(),
+		Start:     span.StartTime(),
+		Duration:  span.Duration(),
+		Tags:      span.Tags(),
+	}
+	if sc.ParentID() != 0 {
+		recorded.ParentID = sc.ParentID().String()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans[r.next] = recorded
+	r.next = (r.next + 1) % len(r.spans)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Close implements the Close() method of jaeger.Reporter.
+func (r *recentReporter) Close() {}
+
+// recent returns the spans in the buffer, oldest first.
+func (r *recentReporter) recent() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]RecordedSpan(nil), r.spans[:r.next]...)
+	}
+	return append(append([]RecordedSpan(nil), r.spans[r.next:]...), r.spans[:r.next]...)
+}
+
+// RecentSpans returns the most recent spans reported by the tracer installed
+// by Configure, oldest first, if enabled by Options.RecentSpanBufferSize.
+func RecentSpans() []RecordedSpan {
+	activeMu.Lock()
+	h := active
+	activeMu.Unlock()
+	if h == nil || h.recent == nil {
+		return nil
+	}
+	return h.recent.recent()
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestRecentSpans(t *testing.T) {
+	closer := configureRecording(t, &Options{RecentSpanBufferSize: 3})
+	operations := func() []string {
+		var ops []string
+		for _, s := range RecentSpans() {
+			ops = append(ops, s.Operation)
+		}
+		return ops
+	}
+	for i := 0; i < 5; i++ {
+		span, _ := StartSpan(context.Background(), "op"+strconv.Itoa(i))
+		span.Finish()
+		if i == 1 {
+			if got, want := operations(), []string{"op0", "op1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v before the buffer is full, want %v", got, want)
+			}
+		}
+	}
+	if got, want := operations(), []string{"op2", "op3", "op4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want the 3 most recent spans %v", got, want)
+	}
+	closer.Close()
+	if spans := RecentSpans(); spans != nil {
+		t.Errorf("got %d spans once closed", len(spans))
+	}
+}
+
+func TestRecentReporterConcurrent(t *testing.T) {
+	rep := newRecentReporter(10)
+	tracer, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(true), rep)
+	defer closer.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				tracer.StartSpan("op").Finish()
+				rep.recent()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(rep.recent()); n != 10 {
+		t.Errorf("got %d spans, want 10", n)
+	}
+}
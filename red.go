@@ -0,0 +1,144 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"sync"
+	"time"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/prometheus/client_golang/prometheus"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// redObserver derives request, error and duration (RED) metrics per
+// operation from every span, sampled or not, as a jaeger.ContribObserver.
+//
+// Operation names are passed through sanitize to keep the cardinality of the
+// operation label bounded. They are taken when spans start, as the name a
+// span is started under is the one its sampling decision is made for, e.g.
+// the route template NewHandler renames sampled spans from, so that renaming
+// a span doesn't depend on sampling.
+type redObserver struct {
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	sanitize func(string) string
+}
+
+func newREDObserver(reg prometheus.Registerer, sanitize func(string) string, buckets []float64) (*redObserver, error) {
+	if sanitize == nil {
+		sanitize = CollapseIDs
+	}
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	o := &redObserver{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tracing_span_requests_total",
+			Help: "Number of finished spans.",
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tracing_span_errors_total",
+			Help: "Number of finished spans tagged as errors.",
+		}, []string{"operation"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tracing_span_duration_seconds",
+			Help:    "Duration of finished spans.",
+			Buckets: buckets,
+		}, []string{"operation"}),
+		sanitize: sanitize,
+	}
+	var err error
+	if o.requests, err = registerCounterVec(reg, o.requests); err != nil {
+		return nil, err
+	}
+	if o.errors, err = registerCounterVec(reg, o.errors); err != nil {
+		return nil, err
+	}
+	if err := reg.Register(o.duration); err != nil {
+		are, ok := err.(prometheus.AlreadyRegisteredError)
+		if !ok {
+			return nil, err
+		}
+		o.duration = are.ExistingCollector.(*prometheus.HistogramVec)
+	}
+	return o, nil
+}
+
+// registerCounterVec registers c with reg, returning the already registered
+// collector instead when Configure is called again.
+func registerCounterVec(reg prometheus.Registerer, c *prometheus.CounterVec) (*prometheus.CounterVec, error) {
+	if err := reg.Register(c); err != nil {
+		are, ok := err.(prometheus.AlreadyRegisteredError)
+		if !ok {
+			return nil, err
+		}
+		return are.ExistingCollector.(*prometheus.CounterVec), nil
+	}
+	return c, nil
+}
+
+// OnStartSpan implements the OnStartSpan() method of jaeger.ContribObserver.
+func (o *redObserver) OnStartSpan(sp ot.Span, operationName string, options ot.StartSpanOptions) (jaeger.ContribSpanObserver, bool) {
+	start := options.StartTime
+	if start.IsZero() {
+		start = time.Now()
+	}
+	so := &redSpanObserver{o: o, operation: operationName, start: start}
+	if v, ok := options.Tags[string(ext.Error)]; ok {
+		so.OnSetTag(string(ext.Error), v)
+	}
+	return so, true
+}
+
+type redSpanObserver struct {
+	o         *redObserver
+	operation string
+	start     time.Time
+
+	mu     sync.Mutex
+	failed bool
+}
+
+func (so *redSpanObserver) OnSetOperationName(operationName string) {}
+
+func (so *redSpanObserver) OnSetTag(key string, value interface{}) {
+	if key != string(ext.Error) {
+		return
+	}
+	failed, _ := value.(bool)
+	so.mu.Lock()
+	so.failed = failed
+	so.mu.Unlock()
+}
+
+func (so *redSpanObserver) OnFinish(options ot.FinishOptions) {
+	finish := options.FinishTime
+	if finish.IsZero() {
+		finish = time.Now()
+	}
+	so.mu.Lock()
+	failed := so.failed
+	so.mu.Unlock()
+	operation := so.o.sanitize(so.operation)
+
+	so.o.requests.WithLabelValues(operation).Inc()
+	if failed {
+		so.o.errors.WithLabelValues(operation).Inc()
+	}
+	so.o.duration.WithLabelValues(operation).Observe(finish.Sub(so.start).Seconds())
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gatheredMetric returns the metric of family name labelled with operation.
+func gatheredMetric(t *testing.T, reg *prometheus.Registry, name, operation string) *dto.Metric {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "operation" && l.GetValue() == operation {
+					return m
+				}
+			}
+		}
+	}
+	t.Fatalf("no %s metric for operation %q", name, operation)
+	return nil
+}
+
+func TestREDMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	defer configureCollector(t, &Options{REDMetrics: reg}).Close()
+
+	start := time.Now()
+	for i, failed := range []bool{false, true, false} {
+		span, _ := StartSpan(context.Background(), "GET /users/"+strconv.Itoa(i+1), ot.StartTime(start))
+		if failed {
+			ext.Error.Set(span, true)
+		}
+		span.FinishWithOptions(ot.FinishOptions{FinishTime: start.Add(100 * time.Millisecond)})
+	}
+
+	if got := gatheredMetric(t, reg, "tracing_span_requests_total", "GET /users/{id}").GetCounter().GetValue(); got != 3 {
+		t.Errorf("got %v requests, want 3", got)
+	}
+	if got := gatheredMetric(t, reg, "tracing_span_errors_total", "GET /users/{id}").GetCounter().GetValue(); got != 1 {
+		t.Errorf("got %v errors, want 1", got)
+	}
+	h := gatheredMetric(t, reg, "tracing_span_duration_seconds", "GET /users/{id}").GetHistogram()
+	if h.GetSampleCount() != 3 || h.GetSampleSum() < 0.299 || h.GetSampleSum() > 0.301 {
+		t.Errorf("got %d durations summing to %vs, want 3 of 100ms", h.GetSampleCount(), h.GetSampleSum())
+	}
+}
+
+func TestDurationBuckets(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	buckets := []float64{0.001, 0.01, 0.1}
+	defer configureRecording(t, &Options{REDMetrics: reg, DurationBuckets: buckets}).Close()
+
+	start := time.Now()
+	span, _ := StartSpan(context.Background(), "op", ot.StartTime(start))
+	span.FinishWithOptions(ot.FinishOptions{FinishTime: start.Add(5 * time.Millisecond)})
+
+	var bounds []float64
+	var counts []uint64
+	for _, b := range gatheredMetric(t, reg, "tracing_span_duration_seconds", "op").GetHistogram().GetBucket() {
+		bounds = append(bounds, b.GetUpperBound())
+		counts = append(counts, b.GetCumulativeCount())
+	}
+	if !reflect.DeepEqual(bounds, buckets) {
+		t.Errorf("got buckets %v, want %v", bounds, buckets)
+	}
+	if want := []uint64{0, 1, 1}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("got cumulative counts %v, want %v", counts, want)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-client-go/transport"
+	"github.com/uber/jaeger-client-go/transport/zipkin"
+)
+
+var (
+	// ErrRegistryWithoutCollector is returned by NewRegistry when neither
+	// ZipkinURL nor JaegerURL is set.
+	ErrRegistryWithoutCollector = errors.New("registry requires a Zipkin or Jaeger collector")
+
+	// ErrRegistryClosed is returned by Registry.Tracer once the registry is
+	// closed.
+	ErrRegistryClosed = errors.New("registry is closed")
+)
+
+// Registry hands out tracers recording spans under different service names,
+// e.g. one per tenant of a multi-tenant service, which all send their spans
+// through a single reporter.
+//
+// The registry holds one reporter queue and goroutine, and one pool of
+// connections to the collector bounded by Options.MaxIdleConns, however many
+// tracers it hands out. Each tracer adds its sampler chain, and, for a jaeger
+// collector, a buffer of the spans batched for its service until the next
+// flush, as jaeger batches are per service. Hundreds of tenants thus cost
+// memory proportional to the spans in flight, not collector connections.
+//
+// Only the collectors, sampling, propagation, process tags and the options
+// applying to every reporter are honored; the tracers are not installed as
+// the global tracer, see WithTracer to use them with the helpers of this
+// package.
+type Registry struct {
+	options  *Options
+	reporter jaeger.Reporter
+
+	mu      sync.Mutex
+	tracers map[string]registeredTracer
+	closed  bool
+}
+
+type registeredTracer struct {
+	tracer ot.Tracer
+	closer io.Closer
+}
+
+// NewRegistry returns a Registry sending spans to the collector configured
+// by the options.
+func NewRegistry(options *Options) (*Registry, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if options.ZipkinURL == "" && options.JaegerURL == "" {
+		return nil, ErrRegistryWithoutCollector
+	}
+
+	roundTripper, err := newCollectorRoundTripper(options)
+	if err != nil {
+		return nil, fmt.Errorf("could not build collector transport: %v", err)
+	}
+	if options.ReporterMaxRetries > 0 {
+		roundTripper = newRetryingRoundTripper(roundTripper, options)
+	}
+
+	var newTransport func() (jaeger.Transport, error)
+	if options.ZipkinURL != "" {
+		newTransport = func() (jaeger.Transport, error) {
+			zipkinOpts := []zipkin.HTTPOption{
+				zipkin.HTTPLogger(logger),
+				zipkin.HTTPTimeout(httpTimeout),
+				zipkin.HTTPRoundTripper(newRejectHandlingRoundTripper(roundTripper, options, splitZipkinBatch)),
+			}
+			trans, err := zipkin.NewHTTPTransport(options.ZipkinURL, zipkinOpts...)
+			if err != nil || options.FallbackZipkinURL == "" {
+				return trans, err
+			}
+			fallback, err := zipkin.NewHTTPTransport(options.FallbackZipkinURL, zipkinOpts...)
+			if err != nil {
+				return nil, err
+			}
+			return newFailoverTransport(trans, fallback), nil
+		}
+	} else {
+		newTransport = func() (jaeger.Transport, error) {
+			jaegerOpts := []transport.HTTPOption{
+				transport.HTTPTimeout(httpTimeout),
+				transport.HTTPRoundTripper(newRejectHandlingRoundTripper(roundTripper, options, splitJaegerBatch)),
+			}
+			trans := transport.NewHTTPTransport(options.JaegerURL, jaegerOpts...)
+			if options.FallbackJaegerURL == "" {
+				return trans, nil
+			}
+			return newFailoverTransport(trans, transport.NewHTTPTransport(options.FallbackJaegerURL, jaegerOpts...)), nil
+		}
+	}
+
+	current := *options
+	rep := newCollectorReporter(&current, newServiceTransport(newTransport), &collectorStats{})
+	if current.CloseTimeout > 0 {
+		rep = &timeoutReporter{Reporter: rep, timeout: current.CloseTimeout}
+	}
+	return &Registry{
+		options:  &current,
+		reporter: rep,
+		tracers:  make(map[string]registeredTracer),
+	}, nil
+}
+
+// Tracer returns the tracer recording spans under serviceName, creating it on
+// first use.
+func (r *Registry) Tracer(serviceName string) (ot.Tracer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrRegistryClosed
+	}
+	if t, ok := r.tracers[serviceName]; ok {
+		return t.tracer, nil
+	}
+
+	s, err := newSamplerChain(serviceName, r.options)
+	if err != nil {
+		return nil, err
+	}
+	opts := []jaeger.TracerOption{poolSpans}
+	propOpts, err := propagationOptions(r.options)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, propOpts...)
+	opts = append(opts, processTags(r.options)...)
+	if r.options.RandomNumberFunc != nil {
+		opts = append(opts, jaeger.TracerOptions.RandomNumber(r.options.RandomNumberFunc))
+	}
+	rep := wrapReporter(serviceName, r.options, unclosedReporter{r.reporter})
+	tracer, closer := jaeger.NewTracer(serviceName, s, rep, opts...)
+	r.tracers[serviceName] = registeredTracer{tracer: tracer, closer: closer}
+	return tracer, nil
+}
+
+// Close closes every tracer of the registry, then flushes and closes the
+// shared reporter.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	tracers := r.tracers
+	r.tracers = nil
+	r.mu.Unlock()
+
+	for _, t := range tracers {
+		t.closer.Close()
+	}
+	r.reporter.Close()
+	return nil
+}
+
+// serviceTransport sends the spans of every tracer through a transport of
+// its own, built on first use, as a jaeger transport attributes all the spans
+// it batches to the service of the first one.
+type serviceTransport struct {
+	newTransport func() (jaeger.Transport, error)
+
+	mu         sync.Mutex
+	transports map[ot.Tracer]jaeger.Transport
+}
+
+func newServiceTransport(newTransport func() (jaeger.Transport, error)) *serviceTransport {
+	return &serviceTransport{
+		newTransport: newTransport,
+		transports:   make(map[ot.Tracer]jaeger.Transport),
+	}
+}
+
+// Append implements the Append() method of jaeger.Transport.
+func (t *serviceTransport) Append(span *jaeger.Span) (int, error) {
+	t.mu.Lock()
+	trans, ok := t.transports[span.Tracer()]
+	if !ok {
+		var err error
+		if trans, err = t.newTransport(); err != nil {
+			t.mu.Unlock()
+			return 1, err
+		}
+		t.transports[span.Tracer()] = trans
+	}
+	t.mu.Unlock()
+	return trans.Append(span)
+}
+
+// Flush implements the Flush() method of jaeger.Transport.
+func (t *serviceTransport) Flush() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var flushed int
+	var firstErr error
+	for _, trans := range t.transports {
+		n, err := trans.Flush()
+		flushed += n
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return flushed, firstErr
+}
+
+// Close implements the Close() method of jaeger.Transport.
+func (t *serviceTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var firstErr error
+	for _, trans := range t.transports {
+		if err := trans.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
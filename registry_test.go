@@ -0,0 +1,106 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// batchCollector records the batches it receives, and the connections they
+// are received on.
+type batchCollector struct {
+	mu      sync.Mutex
+	batches [][]byte
+	conns   int
+}
+
+func (c *batchCollector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	c.mu.Lock()
+	c.batches = append(c.batches, body)
+	c.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (c *batchCollector) connState(conn net.Conn, state http.ConnState) {
+	if state == http.StateNew {
+		c.mu.Lock()
+		c.conns++
+		c.mu.Unlock()
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	if _, err := NewRegistry(&Options{}); err != ErrRegistryWithoutCollector {
+		t.Errorf("got %v without a collector, want ErrRegistryWithoutCollector", err)
+	}
+
+	collector := &batchCollector{}
+	server := httptest.NewUnstartedServer(collector)
+	server.Config.ConnState = collector.connState
+	server.Start()
+	defer server.Close()
+
+	registry, err := NewRegistry(&Options{
+		JaegerURL:    server.URL + "/api/traces",
+		SamplerType:  "const",
+		SamplerParam: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	services := []string{"tenant-a", "tenant-b", "tenant-c"}
+	for _, service := range services {
+		tracer, err := registry.Tracer(service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := registry.Tracer(service); again != tracer {
+			t.Errorf("%s: got a new tracer on the second call", service)
+		}
+		tracer.StartSpan(service + "-op").Finish()
+	}
+	if err := registry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.Tracer("tenant-d"); err != ErrRegistryClosed {
+		t.Errorf("got %v after Close, want ErrRegistryClosed", err)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	// jaeger batches are per service
+	if len(collector.batches) != len(services) {
+		t.Errorf("got %d batches, want %d", len(collector.batches), len(services))
+	}
+	for _, service := range services {
+		var found bool
+		for _, batch := range collector.batches {
+			found = found || bytes.Contains(batch, []byte(service+"-op")) && bytes.Contains(batch, []byte(service))
+		}
+		if !found {
+			t.Errorf("no batch carries the span of %s", service)
+		}
+	}
+	if collector.conns != 1 {
+		t.Errorf("got %d connections to the collector, want the tracers to share one", collector.conns)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/uber/jaeger-client-go/thrift"
+	j "github.com/uber/jaeger-client-go/thrift-gen/jaeger"
+	"github.com/uber/jaeger-client-go/thrift-gen/zipkincore"
+)
+
+// batchSplitter splits an encoded batch of spans into two halves, returning
+// false if the batch holds a single span.
+type batchSplitter func(body []byte) ([2][]byte, bool, error)
+
+// rejectHandlingRoundTripper handles uploads the collector rejects with a 4xx
+// status. A batch rejected as too large is split in halves which are uploaded
+// separately, recursively, so a single huge span only loses itself. Other
+// rejections, and oversized single spans, are handed to onReject, if set.
+//
+// It runs on the goroutine of the remote reporter flushing spans, like
+// retryingRoundTripper which it wraps.
+type rejectHandlingRoundTripper struct {
+	base     http.RoundTripper
+	split    batchSplitter
+	onReject func(status int, err error)
+}
+
+func newRejectHandlingRoundTripper(base http.RoundTripper, options *Options, split batchSplitter) *rejectHandlingRoundTripper {
+	return &rejectHandlingRoundTripper{
+		base:     base,
+		split:    split,
+		onReject: options.OnCollectorReject,
+	}
+}
+
+// RoundTrip implements the RoundTrip() method of http.RoundTripper.
+func (t *rejectHandlingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.roundTrip(req)
+	if err == nil && resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError && t.onReject != nil {
+		t.onReject(resp.StatusCode, fmt.Errorf("collector at %s rejected spans with status %d", req.URL.Host, resp.StatusCode))
+	}
+	return resp, err
+}
+
+func (t *rejectHandlingRoundTripper) roundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusRequestEntityTooLarge || t.split == nil || req.GetBody == nil {
+		return resp, err
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return resp, nil
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return resp, nil
+	}
+	halves, ok, err := t.split(data)
+	if err != nil {
+		glog.Warningf("Could not split batch of spans rejected as too large by %s: %v", req.URL.Host, err)
+		return resp, nil
+	}
+	if !ok {
+		glog.Warningf("Collector at %s rejected a single span as too large", req.URL.Host)
+		return resp, nil
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	// both halves are uploaded even if the first fails, and the first failure
+	// is returned
+	glog.V(1).Infof("Splitting batch of spans rejected as too large by %s", req.URL.Host)
+	var failed *http.Response
+	for _, half := range halves {
+		resp, err := t.roundTrip(withBody(req, half))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= http.StatusBadRequest && failed == nil {
+			failed = resp
+			continue
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if failed != nil {
+		return failed, nil
+	}
+	return &http.Response{
+		Status:     http.StatusText(http.StatusAccepted),
+		StatusCode: http.StatusAccepted,
+		Proto:      req.Proto,
+		ProtoMajor: req.ProtoMajor,
+		ProtoMinor: req.ProtoMinor,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}, nil
+}
+
+// withBody returns a copy of req uploading body instead.
+func withBody(req *http.Request, body []byte) *http.Request {
+	clone := cloneRequest(req)
+	clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	clone.ContentLength = int64(len(body))
+	return clone
+}
+
+// splitJaegerBatch is the batchSplitter of the thrift encoded batches
+// uploaded to Jaeger collectors.
+func splitJaegerBatch(body []byte) ([2][]byte, bool, error) {
+	var halves [2][]byte
+	buf := thrift.NewTMemoryBuffer()
+	buf.Write(body)
+	batch := j.NewBatch()
+	if err := batch.Read(thrift.NewTBinaryProtocolTransport(buf)); err != nil {
+		return halves, false, err
+	}
+	if len(batch.Spans) < 2 {
+		return halves, false, nil
+	}
+	mid := len(batch.Spans) / 2
+	for i, spans := range [][]*j.Span{batch.Spans[:mid], batch.Spans[mid:]} {
+		out := thrift.NewTMemoryBuffer()
+		half := &j.Batch{Process: batch.Process, Spans: spans}
+		if err := half.Write(thrift.NewTBinaryProtocolTransport(out)); err != nil {
+			return halves, false, err
+		}
+		halves[i] = out.Bytes()
+	}
+	return halves, true, nil
+}
+
+// splitZipkinBatch is the batchSplitter of the thrift encoded lists of spans
+// uploaded to Zipkin collectors.
+func splitZipkinBatch(body []byte) ([2][]byte, bool, error) {
+	var halves [2][]byte
+	buf := thrift.NewTMemoryBuffer()
+	buf.Write(body)
+	p := thrift.NewTBinaryProtocolTransport(buf)
+	_, size, err := p.ReadListBegin()
+	if err != nil {
+		return halves, false, err
+	}
+	if size < 2 {
+		return halves, false, nil
+	}
+	spans := make([]*zipkincore.Span, size)
+	for i := range spans {
+		spans[i] = zipkincore.NewSpan()
+		if err := spans[i].Read(p); err != nil {
+			return halves, false, err
+		}
+	}
+	mid := size / 2
+	for i, half := range [][]*zipkincore.Span{spans[:mid], spans[mid:]} {
+		out := thrift.NewTMemoryBuffer()
+		op := thrift.NewTBinaryProtocolTransport(out)
+		if err := op.WriteListBegin(thrift.STRUCT, len(half)); err != nil {
+			return halves, false, err
+		}
+		for _, s := range half {
+			if err := s.Write(op); err != nil {
+				return halves, false, err
+			}
+		}
+		if err := op.WriteListEnd(); err != nil {
+			return halves, false, err
+		}
+		halves[i] = out.Bytes()
+	}
+	return halves, true, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/uber/jaeger-client-go/thrift"
+	j "github.com/uber/jaeger-client-go/thrift-gen/jaeger"
+)
+
+// limitedCollector is a Jaeger collector rejecting batches larger than
+// maxBytes as too large, and every batch with status when set.
+type limitedCollector struct {
+	maxBytes int
+	status   int
+
+	mu         sync.Mutex
+	operations []string
+}
+
+func (c *limitedCollector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	switch {
+	case c.status != 0:
+		w.WriteHeader(c.status)
+		return
+	case len(body) > c.maxBytes:
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	buf := thrift.NewTMemoryBuffer()
+	buf.Write(body)
+	batch := j.NewBatch()
+	if err := batch.Read(thrift.NewTBinaryProtocolTransport(buf)); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	for _, span := range batch.Spans {
+		c.operations = append(c.operations, span.OperationName)
+	}
+	c.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// reportThroughCollector reports spans named operations to c, the one named
+// huge carrying large tags, and returns the statuses OnCollectorReject is
+// called with.
+func reportThroughCollector(t *testing.T, c *limitedCollector, operations []string) []int {
+	t.Helper()
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	var rejected []int
+	closer, err := Configure("svc", &Options{
+		JaegerURL:         server.URL + "/api/traces",
+		SamplerType:       "const",
+		SamplerParam:      1,
+		OnCollectorReject: func(status int, err error) { rejected = append(rejected, status) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range operations {
+		span, _ := StartSpan(context.Background(), op)
+		// jaeger truncates tag values to 256 bytes
+		for i := 0; op == "huge" && i < c.maxBytes/100; i++ {
+			span.SetTag("payload"+strconv.Itoa(i), strings.Repeat("x", 200))
+		}
+		span.Finish()
+	}
+	closer.Close()
+	return rejected
+}
+
+func TestCollectorRejectsLargeBatch(t *testing.T) {
+	c := &limitedCollector{maxBytes: 4096}
+	rejected := reportThroughCollector(t, c, []string{"a", "b", "huge", "c", "d"})
+
+	sort.Strings(c.operations)
+	if got := strings.Join(c.operations, ","); got != "a,b,c,d" {
+		t.Errorf("got spans %s, want all but the huge one", got)
+	}
+	if len(rejected) != 1 || rejected[0] != http.StatusRequestEntityTooLarge {
+		t.Errorf("got rejections %v, want one for the huge span", rejected)
+	}
+}
+
+func TestCollectorRejectsBatch(t *testing.T) {
+	c := &limitedCollector{maxBytes: 4096, status: http.StatusBadRequest}
+	rejected := reportThroughCollector(t, c, []string{"a", "b"})
+
+	if len(c.operations) != 0 {
+		t.Errorf("got spans %v", c.operations)
+	}
+	if len(rejected) != 1 || rejected[0] != http.StatusBadRequest {
+		t.Errorf("got rejections %v, want one for the batch", rejected)
+	}
+}
@@ -0,0 +1,543 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	jaeger "github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-lib/metrics"
+)
+
+// newCollectorReporter returns the reporter sending spans to a collector
+// through trans, recording its health in stats.
+func newCollectorReporter(options *Options, trans jaeger.Transport, stats *collectorStats) jaeger.Reporter {
+	m := &collectorMetrics{Factory: metrics.NullFactory, stats: stats}
+	if options.OnSpanDropped != nil {
+		m.notifier = newDropNotifier(options.OnSpanDropped)
+	}
+	var rep jaeger.Reporter
+	if options.CollectorReporter != nil {
+		rep = options.CollectorReporter(trans)
+	} else {
+		rep = jaeger.NewRemoteReporter(trans,
+			jaeger.ReporterOptions.Metrics(jaeger.NewMetrics(m, nil)),
+			jaeger.ReporterOptions.Logger(statsLogger{stats}))
+	}
+	if m.notifier != nil {
+		rep = &dropNotifyingReporter{Reporter: rep, notifier: m.notifier}
+	}
+	if options.ReportSampleRate != nil {
+		rep = newSampledReporter(rep, *options.ReportSampleRate)
+	}
+	if len(options.ExportSamplingRules) > 0 {
+		rep = newRuleSampledReporter(rep, options.ExportSamplingRules)
+	}
+	return rep
+}
+
+// priorityFlushingReporter uploads debug spans through their own transport,
+// flushing it right away, and hands other spans to the wrapped reporter to be
+// batched. See Options.FlushPrioritySpans.
+type priorityFlushingReporter struct {
+	jaeger.Reporter
+	mu    sync.Mutex
+	trans jaeger.Transport
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *priorityFlushingReporter) Report(span *jaeger.Span) {
+	if !span.SpanContext().IsDebug() {
+		r.Reporter.Report(span)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.trans.Append(span); err != nil {
+		glog.Warningf("Failed to upload debug span %q: %v", span.OperationName(), err)
+		return
+	}
+	if _, err := r.trans.Flush(); err != nil {
+		glog.Warningf("Failed to upload debug span %q: %v", span.OperationName(), err)
+	}
+}
+
+// Close implements the Close() method of jaeger.Reporter.
+func (r *priorityFlushingReporter) Close() {
+	r.Reporter.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trans.Close()
+}
+
+// wrapReporter applies the decorators configured by the options which apply
+// to every reporter. The last one applied runs first; the post-processor runs
+// last, right before rep, so that it sees and has the final say over the
+// span actually reported.
+func wrapReporter(serviceName string, options *Options, rep jaeger.Reporter) jaeger.Reporter {
+	if options.SpanPostProcessor != nil {
+		rep = &postProcessingReporter{Reporter: rep, process: options.SpanPostProcessor}
+	}
+	if options.MaxSpanBytes > 0 {
+		rep = &sizeLimitingReporter{Reporter: rep, max: options.MaxSpanBytes, drop: options.DropOversizedSpans}
+	}
+	if len(options.TagAllowlist) > 0 || options.TagRedactor != nil {
+		rep = newTagFilterReporter(serviceName, options, rep)
+	}
+	if options.OperationNamePrefix != "" {
+		rep = &prefixingReporter{Reporter: rep, prefix: options.OperationNamePrefix}
+	}
+	if options.OperationNameSanitizer != nil {
+		rep = &sanitizingReporter{Reporter: rep, sanitize: options.OperationNameSanitizer}
+	}
+	if len(options.BaggageToTagKeys) > 0 {
+		rep = &baggageTagReporter{Reporter: rep, keys: options.BaggageToTagKeys}
+	}
+	if options.TenantTagKey != "" {
+		rep = &tenantTagReporter{Reporter: rep, serviceName: serviceName, key: options.TenantTagKey}
+	}
+	return blockingTimeReporter{rep}
+}
+
+// blockingTimeReporter tags every span given to TagBlockingTime with the
+// total durations blocked before it is reported.
+type blockingTimeReporter struct {
+	jaeger.Reporter
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r blockingTimeReporter) Report(span *jaeger.Span) {
+	if atomic.LoadInt32(&blockingTimesUsed) != 0 {
+		sc := span.SpanContext()
+		b := sc.ExtendedSamplingState(blockingTraceKey{}, newBlockingTrace).(*blockingTrace)
+		b.mu.Lock()
+		totals := b.totals[sc.SpanID()]
+		delete(b.totals, sc.SpanID())
+		b.mu.Unlock()
+		for label, total := range totals {
+			span.SetTag(blockingTagPrefix+label, total)
+		}
+	}
+	r.Reporter.Report(span)
+}
+
+// tag attributing spans to a tenant of a service, see Options.TenantTagKey
+const serviceInstanceTag = "service.instance"
+
+// tenantTagReporter tags every span carrying a tenant in its baggage with the
+// service name suffixed by the tenant before it is reported.
+type tenantTagReporter struct {
+	jaeger.Reporter
+	serviceName string
+	key         string
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *tenantTagReporter) Report(span *jaeger.Span) {
+	if tenant := span.BaggageItem(r.key); tenant != "" {
+		span.SetTag(serviceInstanceTag, r.serviceName+"-"+tenant)
+	}
+	r.Reporter.Report(span)
+}
+
+// baggageTagReporter copies baggage items of every span into tags of the same
+// name before it is reported, as collectors don't index baggage. Items which
+// are not set are skipped.
+type baggageTagReporter struct {
+	jaeger.Reporter
+	keys []string
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *baggageTagReporter) Report(span *jaeger.Span) {
+	for _, key := range r.keys {
+		if value := span.BaggageItem(key); value != "" {
+			span.SetTag(key, value)
+		}
+	}
+	r.Reporter.Report(span)
+}
+
+// sizeLimitingReporter warns about, and optionally drops, spans whose
+// estimated size exceeds max bytes.
+type sizeLimitingReporter struct {
+	jaeger.Reporter
+	max  int
+	drop bool
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *sizeLimitingReporter) Report(span *jaeger.Span) {
+	if size := spanSize(span); size > r.max {
+		if r.drop {
+			glog.Warningf("Dropping span %q of about %d bytes, over the limit of %d", span.OperationName(), size, r.max)
+			return
+		}
+		glog.Warningf("Span %q is about %d bytes, over the limit of %d", span.OperationName(), size, r.max)
+	}
+	r.Reporter.Report(span)
+}
+
+// spanSize estimates the size of a span once serialized from the size of its
+// operation name, tags and log fields, ignoring the encoding's overhead.
+func spanSize(span *jaeger.Span) int {
+	size := len(span.OperationName())
+	for k, v := range span.Tags() {
+		size += len(k) + len(fmt.Sprint(v))
+	}
+	for _, l := range span.Logs() {
+		for _, f := range l.Fields {
+			size += len(f.Key()) + len(fmt.Sprint(f.Value()))
+		}
+	}
+	return size
+}
+
+// postProcessingReporter hands every span to a callback right before it is
+// reported, after the other decorators have run.
+type postProcessingReporter struct {
+	jaeger.Reporter
+	process func(*jaeger.Span)
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *postProcessingReporter) Report(span *jaeger.Span) {
+	r.process(span)
+	r.Reporter.Report(span)
+}
+
+// sanitizingReporter rewrites the operation name of every span before it is
+// reported.
+type sanitizingReporter struct {
+	jaeger.Reporter
+	sanitize func(string) string
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *sanitizingReporter) Report(span *jaeger.Span) {
+	name := span.OperationName()
+	if sanitized := r.sanitize(name); sanitized != name {
+		span.SetOperationName(sanitized)
+	}
+	r.Reporter.Report(span)
+}
+
+// prefixingReporter prepends a prefix to the operation name of every span
+// before it is reported.
+type prefixingReporter struct {
+	jaeger.Reporter
+	prefix string
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *prefixingReporter) Report(span *jaeger.Span) {
+	span.SetOperationName(r.prefix + span.OperationName())
+	r.Reporter.Report(span)
+}
+
+var (
+	uuidSegment    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	numericSegment = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// CollapseIDs is an Options.OperationNameSanitizer which replaces the
+// numeric and UUID segments of slash separated operation names with {id} and
+// {uuid}, e.g. "GET /users/42/orders" becomes "GET /users/{id}/orders".
+func CollapseIDs(name string) string {
+	segments := strings.Split(name, "/")
+	for i, seg := range segments {
+		switch {
+		case numericSegment.MatchString(seg):
+			segments[i] = "{id}"
+		case uuidSegment.MatchString(seg):
+			segments[i] = "{uuid}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// timeoutReporter bounds how long closing the wrapped reporter may take.
+// jaeger's remote reporter blocks in Close until its queue has been flushed
+// and doesn't expose a timeout of its own. It wraps every reporter of a
+// tracer at once, as a composite reporter closes them one after the other.
+type timeoutReporter struct {
+	jaeger.Reporter
+	timeout time.Duration
+}
+
+// Close implements the Close() method of jaeger.Reporter. If the wrapped
+// reporter doesn't close in time, it is left to finish in the background.
+func (r *timeoutReporter) Close() {
+	done := make(chan struct{})
+	go func() {
+		r.Reporter.Close()
+		close(done)
+	}()
+
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		glog.Warningf("Reporter did not flush within %v, giving up on remaining spans", r.timeout)
+	}
+}
+
+// same bound as jaeger's probabilistic sampler
+const maxRandomNumber = ^(uint64(1) << 63)
+
+// sampledReporter forwards a fraction of the spans it is given to the wrapped
+// reporter. The decision is made per trace, so traces are either shipped
+// whole or not at all, but it is independent of the tracer's sampler, which
+// also makes its decision on the trace ID.
+type sampledReporter struct {
+	jaeger.Reporter
+	boundary uint64
+}
+
+func newSampledReporter(rep jaeger.Reporter, rate float64) *sampledReporter {
+	return &sampledReporter{
+		Reporter: rep,
+		boundary: uint64(float64(maxRandomNumber) * rate),
+	}
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *sampledReporter) Report(span *jaeger.Span) {
+	traceID := span.SpanContext().TraceID()
+	if mix64(traceID.Low^traceID.High)&maxRandomNumber < r.boundary {
+		r.Reporter.Report(span)
+	}
+}
+
+// mix64 scrambles x with the splitmix64 finalizer so that decisions derived
+// from it don't correlate with decisions taken on x itself.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Reasons passed to Options.OnSpanDropped.
+const (
+	DropReasonQueueFull      = "queue full"
+	DropReasonTransportError = "transport error"
+)
+
+// size of the buffer between the reporter and the OnSpanDropped callback
+const dropNotifierBuffer = 1024
+
+// collectorMetrics is a metrics.Factory which feeds the remote reporter's
+// queue length and its counters of dropped and failed spans into
+// collectorStats, and into the OnSpanDropped notifier if there is one.
+type collectorMetrics struct {
+	metrics.Factory
+	stats    *collectorStats
+	notifier *dropNotifier
+}
+
+// Counter implements the Counter() method of metrics.Factory.
+func (m *collectorMetrics) Counter(options metrics.Options) metrics.Counter {
+	if options.Name == "reporter_spans" {
+		switch options.Tags["result"] {
+		case "dropped":
+			return dropCounter{m, &m.stats.dropped, DropReasonQueueFull}
+		case "err":
+			return dropCounter{m, &m.stats.failed, DropReasonTransportError}
+		}
+	}
+	return metrics.NullCounter
+}
+
+// Gauge implements the Gauge() method of metrics.Factory.
+func (m *collectorMetrics) Gauge(options metrics.Options) metrics.Gauge {
+	if options.Name == "reporter_queue_length" {
+		return queueLengthGauge{m.stats}
+	}
+	return metrics.NullGauge
+}
+
+// Namespace implements the Namespace() method of metrics.Factory.
+func (m *collectorMetrics) Namespace(scope metrics.NSOptions) metrics.Factory {
+	return m
+}
+
+// dropNotifier turns dropped and failed spans into calls to a callback. The
+// callback runs on its own goroutine so that a slow callback can't hold up
+// the reporter; when it falls too far behind, notifications are discarded.
+type dropNotifier struct {
+	reasons chan string
+	done    chan struct{}
+}
+
+func newDropNotifier(onDropped func(reason string)) *dropNotifier {
+	n := &dropNotifier{
+		reasons: make(chan string, dropNotifierBuffer),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case reason := <-n.reasons:
+				onDropped(reason)
+			case <-n.done:
+				return
+			}
+		}
+	}()
+	return n
+}
+
+func (n *dropNotifier) notify(reason string, count int64) {
+	for i := int64(0); i < count; i++ {
+		select {
+		case n.reasons <- reason:
+		default:
+			return
+		}
+	}
+}
+
+func (n *dropNotifier) stop() {
+	close(n.done)
+}
+
+type dropCounter struct {
+	m      *collectorMetrics
+	count  *int64
+	reason string
+}
+
+// Inc implements the Inc() method of metrics.Counter.
+func (c dropCounter) Inc(delta int64) {
+	atomic.AddInt64(c.count, delta)
+	if c.m.notifier != nil {
+		c.m.notifier.notify(c.reason, delta)
+	}
+}
+
+type queueLengthGauge struct {
+	stats *collectorStats
+}
+
+// Update implements the Update() method of metrics.Gauge.
+func (g queueLengthGauge) Update(value int64) {
+	atomic.StoreInt64(&g.stats.queueLength, value)
+}
+
+// dropNotifyingReporter stops its notifier once the wrapped reporter is
+// closed, as no more spans can be dropped after that.
+type dropNotifyingReporter struct {
+	jaeger.Reporter
+	notifier *dropNotifier
+}
+
+// Close implements the Close() method of jaeger.Reporter.
+func (r *dropNotifyingReporter) Close() {
+	r.Reporter.Close()
+	r.notifier.stop()
+}
+
+// number of spans queuedReporter buffers before dropping new ones
+const reporterQueueSize = 1000
+
+// queuedReporter hands spans to the wrapped reporter on a goroutine of its
+// own, so that a slow reporter doesn't hold up the others it is combined
+// with. Spans are dropped when its queue is full, and once it is closed.
+type queuedReporter struct {
+	jaeger.Reporter
+	queue       chan *jaeger.Span
+	closing     chan struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+	warnDropped sync.Once
+}
+
+func newQueuedReporter(rep jaeger.Reporter) *queuedReporter {
+	r := &queuedReporter{
+		Reporter: rep,
+		queue:    make(chan *jaeger.Span, reporterQueueSize),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// run reports the queued spans until the reporter is closed, and then the
+// spans left in the queue. Like jaeger's remote reporter, it never closes the
+// queue, so that spans reported concurrently with Close are dropped rather
+// than sent on a closed channel.
+func (r *queuedReporter) run() {
+	defer close(r.done)
+	for {
+		select {
+		case span := <-r.queue:
+			r.report(span)
+		case <-r.closing:
+			for {
+				select {
+				case span := <-r.queue:
+					r.report(span)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r *queuedReporter) report(span *jaeger.Span) {
+	r.Reporter.Report(span)
+	span.Release()
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *queuedReporter) Report(span *jaeger.Span) {
+	select {
+	case <-r.closing:
+		return
+	default:
+	}
+	span.Retain()
+	select {
+	case r.queue <- span:
+	default:
+		span.Release()
+		r.warnDropped.Do(func() {
+			glog.Warningf("Reporter queue full, dropping spans")
+		})
+	}
+}
+
+// Close implements the Close() method of jaeger.Reporter. It reports the
+// spans left in the queue before closing the wrapped reporter. Only the first
+// call has any effect.
+func (r *queuedReporter) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closing)
+		<-r.done
+		r.Reporter.Close()
+	})
+}
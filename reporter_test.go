@@ -0,0 +1,353 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/opentracing/opentracing-go/ext"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// blockingReporter blocks every Report until release is closed. Unlike
+// jaeger.InMemoryReporter, it keeps its spans once closed.
+type blockingReporter struct {
+	*jaeger.InMemoryReporter
+	release chan struct{}
+}
+
+func newBlockingReporter() blockingReporter {
+	return blockingReporter{jaeger.NewInMemoryReporter(), make(chan struct{})}
+}
+
+func (r blockingReporter) Report(span *jaeger.Span) {
+	<-r.release
+	r.InMemoryReporter.Report(span)
+}
+
+func (r blockingReporter) Close() {}
+
+// waitForSpans waits up to a second for rep to hold n spans.
+func waitForSpans(t *testing.T, rep *jaeger.InMemoryReporter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for rep.SpansSubmitted() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d spans, want %d", rep.SpansSubmitted(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueuedReporterDoesNotDelayOthers(t *testing.T) {
+	slow := newBlockingReporter()
+	fast := jaeger.NewInMemoryReporter()
+	rep := jaeger.NewCompositeReporter(newQueuedReporter(slow), newQueuedReporter(fast))
+	tracer, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(true), rep)
+	defer closer.Close()
+
+	for i := 0; i < 5; i++ {
+		tracer.StartSpan("op").Finish()
+	}
+	waitForSpans(t, fast, 5)
+	if n := slow.SpansSubmitted(); n != 0 {
+		t.Errorf("slow reporter got %d spans before being released", n)
+	}
+	close(slow.release)
+	waitForSpans(t, slow.InMemoryReporter, 5)
+}
+
+func TestQueuedReporterClose(t *testing.T) {
+	inner := newBlockingReporter()
+	close(inner.release)
+	rep := newQueuedReporter(inner)
+	tracer, _ := jaeger.NewTracer("svc", jaeger.NewConstSampler(true), rep)
+
+	tracer.StartSpan("before").Finish()
+	rep.Close()
+	if n := inner.SpansSubmitted(); n != 1 {
+		t.Errorf("got %d spans reported by Close, want 1", n)
+	}
+
+	// dropped, rather than sent on a closed queue
+	tracer.StartSpan("after").Finish()
+	rep.Close()
+	if n := inner.SpansSubmitted(); n != 1 {
+		t.Errorf("got %d spans after Close, want 1", n)
+	}
+}
+
+func TestSpanPostProcessorRunsLast(t *testing.T) {
+	var processedName string
+	tracer := configureCollector(t, &Options{
+		OperationNamePrefix: "svc.",
+		TagAllowlist:        []string{"kept"},
+		SpanPostProcessor: func(span *jaeger.Span) {
+			processedName = span.OperationName()
+			span.SetTag("duration_bucket", "fast")
+		},
+	})
+	defer tracer.Close()
+
+	span, _ := StartSpan(context.Background(), "op")
+	span.SetTag("kept", true)
+	span.SetTag("dropped", true)
+	span.Finish()
+
+	if processedName != "svc.op" {
+		t.Errorf("post-processor saw %q, before the other decorators ran", processedName)
+	}
+	tags := tracer.onlySpan(t).Tags
+	if tags["duration_bucket"] != "fast" {
+		t.Errorf("tag of the post-processor wasn't reported: %v", tags)
+	}
+	if _, ok := tags["dropped"]; ok || tags["kept"] != true {
+		t.Errorf("TagAllowlist wasn't applied: %v", tags)
+	}
+}
+
+// slowTransport blocks every Append until release is closed.
+type slowTransport struct {
+	release chan struct{}
+}
+
+func (t slowTransport) Append(span *jaeger.Span) (int, error) {
+	<-t.release
+	return 0, nil
+}
+
+func (t slowTransport) Flush() (int, error) { return 0, nil }
+
+func (t slowTransport) Close() error { return nil }
+
+func TestCloseTimeout(t *testing.T) {
+	trans := slowTransport{make(chan struct{})}
+	defer close(trans.release)
+	closeTimeout := 300 * time.Millisecond
+	closer, err := Configure("svc", &Options{
+		JaegerURL:       "http://127.0.0.1:14268/api/traces",
+		DatadogAgentURL: "http://127.0.0.1:8126",
+		SamplerType:     "const",
+		SamplerParam:    1,
+		CloseTimeout:    closeTimeout,
+		CollectorReporter: func(jaeger.Transport) jaeger.Reporter {
+			return jaeger.NewRemoteReporter(trans)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	span, _ := StartSpan(context.Background(), "op")
+	span.Finish()
+
+	// both collector reporters are stuck flushing the span
+	start := time.Now()
+	closer.Close()
+	if elapsed := time.Since(start); elapsed > closeTimeout*3/2 {
+		t.Errorf("Close took %v with a CloseTimeout of %v", elapsed, closeTimeout)
+	}
+}
+
+func TestFlushPrioritySpans(t *testing.T) {
+	var requests int32
+	collector := countingCollector(http.StatusAccepted, &requests)
+	defer collector.Close()
+	closer := configureRecording(t, &Options{JaegerURL: collector.URL, FlushPrioritySpans: true})
+
+	normal, _ := StartSpan(context.Background(), "normal")
+	normal.Finish()
+	priority, _ := StartSpan(context.Background(), "priority")
+	ext.SamplingPriority.Set(priority, 1)
+	priority.Finish()
+	// well before jaeger's flush interval of a second
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("got %d uploads once the priority span finished, want 1", n)
+	}
+
+	closer.Close()
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("got %d uploads once closed, want the batch of the normal span too", n)
+	}
+}
+
+func TestOnSpanDropped(t *testing.T) {
+	trans := slowTransport{make(chan struct{})}
+	reasons := make(chan string, 1000)
+	rep := newCollectorReporter(&Options{
+		OnSpanDropped: func(reason string) { reasons <- reason },
+	}, trans, &collectorStats{})
+	tracer, _ := jaeger.NewTracer("svc", jaeger.NewConstSampler(true), rep)
+
+	// jaeger's queue holds 100 spans, behind the one stuck in Append
+	for i := 0; i < 200; i++ {
+		tracer.StartSpan("op").Finish()
+	}
+	select {
+	case reason := <-reasons:
+		if reason != DropReasonQueueFull {
+			t.Errorf("got reason %q, want %q", reason, DropReasonQueueFull)
+		}
+	case <-time.After(time.Second):
+		t.Error("OnSpanDropped wasn't called with the queue full")
+	}
+	close(trans.release)
+	rep.Close()
+}
+
+func TestCollapseIDs(t *testing.T) {
+	for name, want := range map[string]string{
+		"GET /users/42/orders":                            "GET /users/{id}/orders",
+		"GET /items/123e4567-e89b-12d3-a456-426614174000": "GET /items/{uuid}",
+		"GET /v2/users":                                   "GET /v2/users",
+		"process":                                         "process",
+		"GET /users/42/orders/123e4567-e89b-12d3-a456-426614174000": "GET /users/{id}/orders/{uuid}",
+	} {
+		if got := CollapseIDs(name); got != want {
+			t.Errorf("CollapseIDs(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestOperationNameSanitizer(t *testing.T) {
+	tracer := configureCollector(t, &Options{OperationNameSanitizer: CollapseIDs})
+	defer tracer.Close()
+
+	span, _ := StartSpan(context.Background(), "GET /users/42")
+	span.Finish()
+	if got := tracer.onlySpan(t).Operation; got != "GET /users/{id}" {
+		t.Errorf("got operation %q, want it sanitized", got)
+	}
+}
+
+func TestOperationNamePrefix(t *testing.T) {
+	options := &Options{OperationNamePrefix: "checkout."}
+	collector := recordCollector(options)
+	defer configureRecording(t, options).Close()
+
+	span, _ := StartSpan(context.Background(), "handle")
+	span.Finish()
+	if got := onlyRecentSpan(t).Operation; got != "checkout.handle" {
+		t.Errorf("got operation %q recorded, want it prefixed", got)
+	}
+	waitForSpans(t, collector, 1)
+	if got := collector.GetSpans()[0].(*jaeger.Span).OperationName(); got != "checkout.handle" {
+		t.Errorf("got operation %q shipped, want it prefixed once", got)
+	}
+}
+
+func TestBaggageToTagKeys(t *testing.T) {
+	tracer := configureCollector(t, &Options{BaggageToTagKeys: []string{"canary", "tenant"}})
+	defer tracer.Close()
+
+	span, _ := StartSpan(context.Background(), "op")
+	span.SetBaggageItem("canary", "v2")
+	span.SetBaggageItem("other", "ignored")
+	span.Finish()
+
+	tags := tracer.onlySpan(t).Tags
+	if tags["canary"] != "v2" {
+		t.Errorf("baggage item wasn't tagged: %v", tags)
+	}
+	if _, ok := tags["tenant"]; ok {
+		t.Errorf("missing baggage item was tagged: %v", tags)
+	}
+	if _, ok := tags["other"]; ok {
+		t.Errorf("baggage item not configured was tagged: %v", tags)
+	}
+}
+
+func TestMaxSpanBytes(t *testing.T) {
+	large := strings.Repeat("x", 512)
+	for _, drop := range []bool{false, true} {
+		tracer := configureCollector(t, &Options{MaxSpanBytes: 1024, DropOversizedSpans: drop})
+		small, _ := StartSpan(context.Background(), "small")
+		small.SetTag("key", "value")
+		small.Finish()
+
+		before := glog.Stats.Warning.Lines()
+		span, _ := StartSpan(context.Background(), "large")
+		for i := 0; i < 4; i++ {
+			span.SetTag(fmt.Sprintf("tag%d", i), large)
+		}
+		span.Finish()
+		if n := glog.Stats.Warning.Lines() - before; n != 1 {
+			t.Errorf("drop=%t: got %d warnings for an oversized span, want 1", drop, n)
+		}
+
+		want := []string{"small", "large"}
+		if drop {
+			want = want[:1]
+		}
+		var got []string
+		for _, s := range tracer.spans() {
+			got = append(got, s.Operation)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("drop=%t: got spans %v reported, want %v", drop, got, want)
+		}
+	}
+}
+
+func TestTagAllowlist(t *testing.T) {
+	tracer := configureCollector(t, &Options{TagAllowlist: []string{"http.method", "http.status_code"}})
+	defer tracer.Close()
+
+	span, _ := StartSpan(context.Background(), "op")
+	span.SetTag("http.method", "GET")
+	span.SetTag("http.url", "/users?token=secret")
+	span.SetTag("user.id", 42)
+	span.SetTag("error", true)
+	span.Finish()
+
+	got := map[string]bool{}
+	for k := range tracer.onlySpan(t).Tags {
+		got[k] = true
+	}
+	want := map[string]bool{"http.method": true, "error": true, "sampler.type": true, "sampler.param": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got tags %v, want %v", got, want)
+	}
+}
+
+func TestTenantTagKey(t *testing.T) {
+	tracer := configureCollector(t, &Options{TenantTagKey: "tenant"})
+	defer tracer.Close()
+
+	root, ctx := StartSpan(context.Background(), "root")
+	root.SetBaggageItem("tenant", "acme")
+	child, _ := StartSpan(ctx, "child")
+	child.Finish()
+	root.Finish()
+	other, _ := StartSpan(context.Background(), "other")
+	other.Finish()
+
+	for _, span := range tracer.waitForSpans(t, 3) {
+		want := interface{}("svc-acme")
+		if span.Operation == "other" {
+			want = nil
+		}
+		if got := span.Tags[serviceInstanceTag]; got != want {
+			t.Errorf("%s: got %s tag %v, want %v", span.Operation, serviceInstanceTag, got, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// ReportSamplingRule keeps the given fraction of the sampled spans whose
+// operation matches a glob, where * matches any sequence of characters and ?
+// any single one, when they are handed to a single reporter. See
+// Options.ExportSamplingRules and Options.LogSamplingRules.
+type ReportSamplingRule struct {
+	Operation string  `yaml:"operation"`
+	Rate      float64 `yaml:"rate"`
+}
+
+func validateReportSamplingRules(rules []ReportSamplingRule) error {
+	for _, rule := range rules {
+		if rule.Operation == "" {
+			return errors.New("report sampling rule must have an operation")
+		}
+		if rule.Rate < 0 || rule.Rate > 1 {
+			return fmt.Errorf("report sampling rule %q has rate %v outside of [0, 1]", rule.Operation, rule.Rate)
+		}
+	}
+	return nil
+}
+
+type reportRule struct {
+	operation *regexp.Regexp
+	boundary  uint64
+}
+
+// ruleSampledReporter forwards the spans it is given to the wrapped reporter
+// at the rate of the first rule matching their operation, and every span no
+// rule matches. Like sampledReporter, decisions are made per trace and
+// independently of the tracer's sampler.
+type ruleSampledReporter struct {
+	jaeger.Reporter
+	rules []reportRule
+}
+
+func newRuleSampledReporter(rep jaeger.Reporter, rules []ReportSamplingRule) *ruleSampledReporter {
+	r := &ruleSampledReporter{Reporter: rep}
+	for _, rule := range rules {
+		r.rules = append(r.rules, reportRule{
+			operation: globRegexp(rule.Operation),
+			boundary:  uint64(float64(maxRandomNumber) * rule.Rate),
+		})
+	}
+	return r
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *ruleSampledReporter) Report(span *jaeger.Span) {
+	operation := span.OperationName()
+	for _, rule := range r.rules {
+		if rule.operation.MatchString(operation) {
+			traceID := span.SpanContext().TraceID()
+			if mix64(traceID.Low^traceID.High)&maxRandomNumber < rule.boundary {
+				r.Reporter.Report(span)
+			}
+			return
+		}
+	}
+	r.Reporter.Report(span)
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestReportSampleRate(t *testing.T) {
+	for _, rate := range []float64{0, 1} {
+		tracer := configureCollector(t, &Options{ReportSampleRate: &rate})
+
+		for i := 0; i < 10; i++ {
+			span, _ := StartSpan(context.Background(), "op")
+			if !span.Context().(jaeger.SpanContext).IsSampled() {
+				t.Errorf("rate %v: span not sampled by the tracer", rate)
+			}
+			span.Finish()
+		}
+		if got, want := len(tracer.spans()), int(rate*10); got != want {
+			t.Errorf("rate %v: got %d spans shipped, want %d", rate, got, want)
+		}
+	}
+}
+
+func TestReportSamplingRules(t *testing.T) {
+	rules := []ReportSamplingRule{{"audit.*", 1}, {"health?", 0}, {"*", 0}}
+	rep := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(true), newRuleSampledReporter(rep, rules))
+	defer closer.Close()
+	for _, op := range []string{"audit.login", "healthz", "checkout", "audit.logout"} {
+		tracer.StartSpan(op).Finish()
+	}
+	var got []string
+	for _, span := range rep.GetSpans() {
+		got = append(got, span.(*jaeger.Span).OperationName())
+	}
+	if want := []string{"audit.login", "audit.logout"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got spans %v reported, want %v", got, want)
+	}
+}
+
+func TestExportSamplingRules(t *testing.T) {
+	options := &Options{ExportSamplingRules: []ReportSamplingRule{{"audit.*", 0}}}
+	collector := recordCollector(options)
+	defer configureRecording(t, options).Close()
+
+	for _, op := range []string{"audit.login", "checkout"} {
+		span, _ := StartSpan(context.Background(), op)
+		span.Finish()
+	}
+	spans := collector.GetSpans()
+	if len(spans) != 1 || spans[0].(*jaeger.Span).OperationName() != "checkout" {
+		t.Errorf("got spans %v shipped, want only checkout", spans)
+	}
+	if n := len(RecentSpans()); n != 2 {
+		t.Errorf("got %d spans recorded in-process, want both", n)
+	}
+}
+
+func TestValidateReportSamplingRules(t *testing.T) {
+	for _, tt := range []struct {
+		rule  ReportSamplingRule
+		valid bool
+	}{
+		{ReportSamplingRule{"audit.*", 0.5}, true},
+		{ReportSamplingRule{"", 0.5}, false},
+		{ReportSamplingRule{"audit.*", 2}, false},
+		{ReportSamplingRule{"audit.*", -1}, false},
+	} {
+		options := &Options{LogSamplingRules: []ReportSamplingRule{tt.rule}}
+		if err := options.Validate(); tt.valid != (err == nil) {
+			t.Errorf("Validate() with rule %+v = %v", tt.rule, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// backoff before the first retry when Options.ReporterRetryBackoff is unset
+const defaultReporterRetryBackoff = 200 * time.Millisecond
+
+// retryingRoundTripper retries uploads to the collector which fail with a
+// network error or a 5xx status, up to maxRetries times with an exponential
+// backoff.
+//
+// It runs on the goroutine of the remote reporter flushing spans, never on
+// the goroutines finishing them. All attempts share the collector timeout.
+// Giving up is logged once until an upload succeeds again, so that a
+// collector which is down doesn't flood the logs.
+type retryingRoundTripper struct {
+	base       http.RoundTripper
+	maxRetries int
+	backoff    time.Duration
+	gaveUp     int32 // accessed atomically
+}
+
+func newRetryingRoundTripper(base http.RoundTripper, options *Options) *retryingRoundTripper {
+	backoff := options.ReporterRetryBackoff
+	if backoff == 0 {
+		backoff = defaultReporterRetryBackoff
+	}
+	return &retryingRoundTripper{
+		base:       base,
+		maxRetries: options.ReporterMaxRetries,
+		backoff:    backoff,
+	}
+}
+
+// RoundTrip implements the RoundTrip() method of http.RoundTripper.
+func (t *retryingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := t.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			if atomic.CompareAndSwapInt32(&t.gaveUp, 1, 0) {
+				glog.Infof("Uploaded spans to %s again", req.URL.Host)
+			}
+			return resp, nil
+		}
+		if attempt == t.maxRetries || req.GetBody == nil {
+			if attempt > 0 && atomic.CompareAndSwapInt32(&t.gaveUp, 0, 1) {
+				glog.Warningf("Giving up on uploading spans to %s after %d retries", req.URL.Host, attempt)
+			}
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req = cloneRequest(req)
+		req.Body = body
+	}
+}
+
+// cloneRequest returns a shallow copy of req, with its own headers.
+func cloneRequest(req *http.Request) *http.Request {
+	clone := req.WithContext(req.Context())
+	clone.Header = cloneHeader(req.Header)
+	return clone
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// statusCollector answers requests with the statuses popped from statuses,
+// and 202 once they run out.
+type statusCollector struct {
+	statuses []int
+	requests int32
+}
+
+func (c *statusCollector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt32(&c.requests, 1)
+	status := http.StatusAccepted
+	if len(c.statuses) > 0 {
+		status, c.statuses = c.statuses[0], c.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func upload(t *testing.T, rt http.RoundTripper, url string) int {
+	t.Helper()
+	req, err := http.NewRequest("POST", url, bytes.NewReader([]byte("spans")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRetryingRoundTripper(t *testing.T) {
+	collector := &statusCollector{statuses: []int{503, 503, 503, 503, 503}}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+	rt := newRetryingRoundTripper(http.DefaultTransport, &Options{
+		ReporterMaxRetries:   2,
+		ReporterRetryBackoff: time.Millisecond,
+	})
+
+	if status := upload(t, rt, server.URL); status != 503 {
+		t.Errorf("got status %d after exhausting the retries, want 503", status)
+	}
+	if n := atomic.LoadInt32(&collector.requests); n != 3 {
+		t.Errorf("got %d attempts, want the first one and 2 retries", n)
+	}
+	if atomic.LoadInt32(&rt.gaveUp) != 1 {
+		t.Error("giving up wasn't recorded")
+	}
+
+	// the last 2 failures are retried
+	if status := upload(t, rt, server.URL); status != 202 {
+		t.Errorf("got status %d, want the upload to succeed on the last retry", status)
+	}
+	if atomic.LoadInt32(&rt.gaveUp) != 0 {
+		t.Error("giving up wasn't reset by a successful upload")
+	}
+}
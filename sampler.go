@@ -0,0 +1,967 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/opentracing/opentracing-go/ext"
+	jaeger "github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-client-go/thrift-gen/sampling"
+	"github.com/uber/jaeger-client-go/utils"
+)
+
+// default sampling probabilities of the environments known to
+// Options.Environment
+var environmentSampleRates = map[string]float64{
+	"dev":     1,
+	"staging": 0.1,
+	"prod":    0.01,
+}
+
+// SampleTraceID returns whether a 'probabilistic' sampler with the given rate
+// samples the trace traceID. Decisions are derived from the trace ID alone,
+// with no local randomness, so every service sampling at the same rate makes
+// the same decision for a trace, and services at lower rates only sample
+// traces also sampled at higher ones.
+//
+// Like jaeger, it assumes the low 64 bits of trace IDs are 63 bit random
+// numbers, as jaeger generates them; traces started by tracers using all 64
+// bits are sampled at half the rate.
+func SampleTraceID(traceID jaeger.TraceID, rate float64) bool {
+	rate = math.Max(0, math.Min(rate, 1))
+	return uint64(float64(maxRandomNumber)*rate) >= traceID.Low
+}
+
+// newSampler returns the sampler selected by the options. When a sampling
+// server is configured, the sampler is controlled by the strategies it serves.
+func newSampler(serviceName string, options *Options) (jaeger.Sampler, error) {
+	s, err := newLocalSampler(options)
+	if err != nil || options.SamplingServerURL == "" {
+		return s, err
+	}
+	if options.SamplingFallbackRate != nil {
+		if s, err = jaeger.NewProbabilisticSampler(*options.SamplingFallbackRate); err != nil {
+			return nil, err
+		}
+	}
+	return jaeger.NewRemotelyControlledSampler(serviceName,
+		jaeger.SamplerOptions.SamplingServerURL(options.SamplingServerURL),
+		jaeger.SamplerOptions.InitialSampler(s),
+		jaeger.SamplerOptions.Logger(logger),
+	), nil
+}
+
+// newSamplerChain returns the sampler selected by newSampler, decorated by the
+// samplers configured by the options.
+func newSamplerChain(serviceName string, options *Options) (jaeger.Sampler, error) {
+	s, err := newSampler(serviceName, options)
+	if err != nil {
+		return nil, err
+	}
+	if options.AdaptiveThresholdPerMinute > 0 {
+		ts, err := newThresholdSampler(s, options.AdaptiveThresholdPerMinute, options.AdaptiveThrottledSampleRate)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s = ts
+	}
+	// limits the traces sampled by the throttled sampler too
+	if options.SamplingBudgets {
+		s = newBudgetSampler(s)
+	}
+	if options.FirstSpanPerOperationWindow > 0 {
+		s = newFirstSpanSampler(s, options.FirstSpanPerOperationWindow)
+	}
+	if options.AlwaysSamplePriority > 0 {
+		s = newPrioritySampler(s, options.AlwaysSamplePriority)
+	}
+	if len(options.AlwaysSamplePeers) > 0 {
+		s = newPeerSampler(s, options.AlwaysSamplePeers)
+	}
+	if options.MaxErrorSamplesPerSecond > 0 {
+		s = newErrorSampler(s, options.MaxErrorSamplesPerSecond)
+	}
+	if options.SamplingConfigFile != "" {
+		config, err := loadSamplingConfig(options.SamplingConfigFile)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		ops, err := newOperationSampler(s, config)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s = ops
+	}
+	if len(options.BaggageSamplingRules) > 0 {
+		bs, err := newBaggageSampler(s, options.BaggageSamplingRules)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s = bs
+	}
+	if len(options.SamplerRules) > 0 {
+		s = newRuleChain(s, options.SamplerRules)
+	}
+	if options.SamplerDecider != nil {
+		s = newDeciderSampler(s, options.SamplerDecider)
+	}
+
+	if options.LogSamplingDecisions {
+		s = newLoggingSampler(s)
+	}
+	return s, nil
+}
+
+// newLocalSampler returns the sampler selected by the options which don't
+// involve a sampling server. When no sampler type is configured, the
+// environment's default rate is used if there is one, and the package default
+// otherwise.
+func newLocalSampler(options *Options) (jaeger.Sampler, error) {
+	if options.LowerBoundPerSecond > 0 {
+		return newLowerBoundSampler(options), nil
+	}
+	switch options.SamplerType {
+	case "":
+		if rate, ok := options.environmentSampleRate(); ok {
+			return jaeger.NewProbabilisticSampler(rate)
+		}
+		return sampler, nil
+	case jaeger.SamplerTypeConst:
+		return jaeger.NewConstSampler(options.SamplerParam != 0), nil
+	case jaeger.SamplerTypeProbabilistic:
+		return jaeger.NewProbabilisticSampler(options.SamplerParam)
+	case jaeger.SamplerTypeRateLimiting:
+		return jaeger.NewRateLimitingSampler(options.SamplerParam), nil
+	}
+	return nil, ErrUnknownSamplerType
+}
+
+// newLowerBoundSampler returns a sampler guaranteeing every operation
+// Options.LowerBoundPerSecond sampled traces per second on top of the
+// probabilistic rate selected by the options.
+func newLowerBoundSampler(options *Options) *jaeger.PerOperationSampler {
+	rate := 1.0
+	if options.SamplerType == jaeger.SamplerTypeProbabilistic {
+		rate = options.SamplerParam
+	} else if envRate, ok := options.environmentSampleRate(); ok {
+		rate = envRate
+	}
+	return jaeger.NewPerOperationSampler(jaeger.PerOperationSamplerParams{
+		Strategies: &sampling.PerOperationSamplingStrategies{
+			DefaultSamplingProbability:       rate,
+			DefaultLowerBoundTracesPerSecond: options.LowerBoundPerSecond,
+		},
+	})
+}
+
+// tag recording which rule of the decorators in this package made a trace
+// sampled, e.g. "baggage:tenant:acme"
+const samplingRuleTag = "sampling.rule"
+
+// withRule records rule as the reason for d, if d samples the trace.
+func withRule(d jaeger.SamplingDecision, rule string) jaeger.SamplingDecision {
+	if d.Sample {
+		d.Tags = append(d.Tags, jaeger.NewTag(samplingRuleTag, rule))
+	}
+	return d
+}
+
+// samplerV2 returns s as a jaeger.SamplerV2, adapting samplers which only
+// implement the legacy IsSampled() API.
+func samplerV2(s jaeger.Sampler) jaeger.SamplerV2 {
+	if s2, ok := s.(jaeger.SamplerV2); ok {
+		return s2
+	}
+	return legacySampler{s}
+}
+
+// legacySampler makes a final decision with the wrapped sampler when a span
+// is created, which is how jaeger treats samplers predating SamplerV2.
+type legacySampler struct {
+	jaeger.Sampler
+}
+
+func (s legacySampler) String() string {
+	return describeSampler(s.Sampler)
+}
+
+func (s legacySampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	sampled, tags := s.IsSampled(span.SpanContext().TraceID(), span.OperationName())
+	return jaeger.SamplingDecision{Sample: sampled, Tags: tags}
+}
+
+func (s legacySampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	sampled, tags := s.IsSampled(span.SpanContext().TraceID(), operationName)
+	return jaeger.SamplingDecision{Sample: sampled, Tags: tags}
+}
+
+func (s legacySampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	return jaeger.SamplingDecision{Retryable: true}
+}
+
+func (s legacySampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return jaeger.SamplingDecision{Retryable: true}
+}
+
+// baggageSampler samples traces whose root span carries a baggage item
+// matched by one of its rules at that rule's rate, and defers to base for
+// every other trace.
+//
+// jaeger only consults the sampler for the root span of a trace, so rules only
+// see baggage which is present when the root span is started, e.g. baggage
+// extracted from an inbound jaeger-baggage header. Baggage set later in the
+// trace cannot change a decision which has already been made.
+type baggageSampler struct {
+	jaeger.SamplerV2Base
+	base  jaeger.SamplerV2
+	rules map[string]*jaeger.ProbabilisticSampler
+}
+
+func newBaggageSampler(base jaeger.Sampler, rules map[string]float64) (*baggageSampler, error) {
+	s := &baggageSampler{
+		base:  samplerV2(base),
+		rules: make(map[string]*jaeger.ProbabilisticSampler, len(rules)),
+	}
+	for rule, rate := range rules {
+		ps, err := jaeger.NewProbabilisticSampler(rate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate for baggage sampling rule %q: %v", rule, err)
+		}
+		s.rules[rule] = ps
+	}
+	return s, nil
+}
+
+// validateBaggageSamplingRules checks that every rule is keyed by
+// "baggageKey:value" and has a rate between 0 and 1.
+func validateBaggageSamplingRules(rules map[string]float64) error {
+	for rule, rate := range rules {
+		if i := strings.Index(rule, ":"); i <= 0 {
+			return fmt.Errorf("baggage sampling rule %q must be of the form baggageKey:value", rule)
+		}
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("baggage sampling rule %q has rate %v outside of [0, 1]", rule, rate)
+		}
+	}
+	return nil
+}
+
+// match returns the first rule matching the span's baggage and its sampler,
+// or nil if no rule matches.
+func (s *baggageSampler) match(span *jaeger.Span) (string, *jaeger.ProbabilisticSampler) {
+	var rule string
+	var ps *jaeger.ProbabilisticSampler
+	span.SpanContext().ForeachBaggageItem(func(k, v string) bool {
+		rule = k + ":" + v
+		ps = s.rules[rule]
+		return ps == nil
+	})
+	return rule, ps
+}
+
+func (s *baggageSampler) decide(span *jaeger.Span) (jaeger.SamplingDecision, bool) {
+	rule, ps := s.match(span)
+	if ps == nil {
+		return jaeger.SamplingDecision{}, false
+	}
+	sampled, tags := ps.IsSampled(span.SpanContext().TraceID(), span.OperationName())
+	return withRule(jaeger.SamplingDecision{Sample: sampled, Tags: tags}, "baggage:"+rule), true
+}
+
+// OnCreateSpan implements the OnCreateSpan() method of jaeger.SamplerV2.
+func (s *baggageSampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	if d, ok := s.decide(span); ok {
+		return d
+	}
+	return s.base.OnCreateSpan(span)
+}
+
+// OnSetOperationName implements the OnSetOperationName() method of jaeger.SamplerV2.
+func (s *baggageSampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	if d, ok := s.decide(span); ok {
+		return d
+	}
+	return s.base.OnSetOperationName(span, operationName)
+}
+
+// OnSetTag implements the OnSetTag() method of jaeger.SamplerV2.
+func (s *baggageSampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	if d, ok := s.decide(span); ok {
+		return d
+	}
+	return s.base.OnSetTag(span, key, value)
+}
+
+// OnFinishSpan implements the OnFinishSpan() method of jaeger.SamplerV2.
+func (s *baggageSampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	if d, ok := s.decide(span); ok {
+		return d
+	}
+	return s.base.OnFinishSpan(span)
+}
+
+// String describes the sampler for StatusHandler.
+func (s *baggageSampler) String() string {
+	return fmt.Sprintf("BaggageSampler(rules=%d, base=%s)", len(s.rules), describeSampler(s.base))
+}
+
+// Close implements the Close() method of jaeger.SamplerV2.
+func (s *baggageSampler) Close() {
+	s.base.Close()
+}
+
+// SamplerContext describes the trace a span is started in for
+// Options.SamplerDecider and Options.SamplerRules.
+type SamplerContext struct {
+	TraceID jaeger.TraceID
+
+	// Baggage of the span, e.g. extracted from an inbound request, or nil if
+	// it has none.
+	Baggage map[string]string
+}
+
+// deciderSampler samples traces as decided by an Options.SamplerDecider, and
+// defers to base for the traces it doesn't handle.
+type deciderSampler struct {
+	jaeger.SamplerV2Base
+	base   jaeger.SamplerV2
+	decide func(operation string, ctx SamplerContext) (sampled bool, handled bool)
+}
+
+func newDeciderSampler(base jaeger.Sampler, decide func(string, SamplerContext) (bool, bool)) *deciderSampler {
+	return &deciderSampler{base: samplerV2(base), decide: decide}
+}
+
+// samplerContext returns the SamplerContext of span.
+func samplerContext(span *jaeger.Span) SamplerContext {
+	sc := span.SpanContext()
+	ctx := SamplerContext{TraceID: sc.TraceID()}
+	sc.ForeachBaggageItem(func(k, v string) bool {
+		if ctx.Baggage == nil {
+			ctx.Baggage = make(map[string]string)
+		}
+		ctx.Baggage[k] = v
+		return true
+	})
+	return ctx
+}
+
+func (s *deciderSampler) decision(span *jaeger.Span, operation string) (jaeger.SamplingDecision, bool) {
+	sampled, handled := s.decide(operation, samplerContext(span))
+	if !handled {
+		return jaeger.SamplingDecision{}, false
+	}
+	return withRule(jaeger.SamplingDecision{Sample: sampled}, "decider"), true
+}
+
+// OnCreateSpan implements the OnCreateSpan() method of jaeger.SamplerV2.
+func (s *deciderSampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	if d, ok := s.decision(span, span.OperationName()); ok {
+		return d
+	}
+	return s.base.OnCreateSpan(span)
+}
+
+// OnSetOperationName implements the OnSetOperationName() method of jaeger.SamplerV2.
+func (s *deciderSampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	if d, ok := s.decision(span, operationName); ok {
+		return d
+	}
+	return s.base.OnSetOperationName(span, operationName)
+}
+
+// OnSetTag implements the OnSetTag() method of jaeger.SamplerV2.
+func (s *deciderSampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	return s.base.OnSetTag(span, key, value)
+}
+
+// OnFinishSpan implements the OnFinishSpan() method of jaeger.SamplerV2.
+func (s *deciderSampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return s.base.OnFinishSpan(span)
+}
+
+// String describes the sampler for StatusHandler.
+func (s *deciderSampler) String() string {
+	return fmt.Sprintf("DeciderSampler(base=%s)", describeSampler(s.base))
+}
+
+// Close implements the Close() method of jaeger.SamplerV2.
+func (s *deciderSampler) Close() {
+	s.base.Close()
+}
+
+// SamplingVerdict is the decision of a SamplerRule.
+type SamplingVerdict int
+
+const (
+	// SamplingDefer leaves the decision to the next rule.
+	SamplingDefer SamplingVerdict = iota
+	// SamplingSample samples the trace.
+	SamplingSample
+	// SamplingDrop doesn't sample the trace.
+	SamplingDrop
+)
+
+// SamplerRule decides whether a new trace is sampled, see
+// Options.SamplerRules.
+type SamplerRule func(operation string, ctx SamplerContext) SamplingVerdict
+
+// samplerChain evaluates its rules in order, and samples or drops a trace as
+// decided by the first rule which doesn't defer. It defers to base when every
+// rule does.
+type samplerChain struct {
+	jaeger.SamplerV2Base
+	base  jaeger.SamplerV2
+	rules []SamplerRule
+}
+
+func newRuleChain(base jaeger.Sampler, rules []SamplerRule) *samplerChain {
+	return &samplerChain{base: samplerV2(base), rules: rules}
+}
+
+func (s *samplerChain) decision(span *jaeger.Span, operation string) (jaeger.SamplingDecision, bool) {
+	ctx := samplerContext(span)
+	for i, rule := range s.rules {
+		switch rule(operation, ctx) {
+		case SamplingSample:
+			return withRule(jaeger.SamplingDecision{Sample: true}, "rule:"+strconv.Itoa(i)), true
+		case SamplingDrop:
+			return jaeger.SamplingDecision{}, true
+		}
+	}
+	return jaeger.SamplingDecision{}, false
+}
+
+// OnCreateSpan implements the OnCreateSpan() method of jaeger.SamplerV2.
+func (s *samplerChain) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	if d, ok := s.decision(span, span.OperationName()); ok {
+		return d
+	}
+	return s.base.OnCreateSpan(span)
+}
+
+// OnSetOperationName implements the OnSetOperationName() method of jaeger.SamplerV2.
+func (s *samplerChain) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	if d, ok := s.decision(span, operationName); ok {
+		return d
+	}
+	return s.base.OnSetOperationName(span, operationName)
+}
+
+// OnSetTag implements the OnSetTag() method of jaeger.SamplerV2.
+func (s *samplerChain) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	return s.base.OnSetTag(span, key, value)
+}
+
+// OnFinishSpan implements the OnFinishSpan() method of jaeger.SamplerV2.
+func (s *samplerChain) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return s.base.OnFinishSpan(span)
+}
+
+// String describes the sampler for StatusHandler.
+func (s *samplerChain) String() string {
+	return fmt.Sprintf("SamplerChain(rules=%d, base=%s)", len(s.rules), describeSampler(s.base))
+}
+
+// Close implements the Close() method of jaeger.SamplerV2.
+func (s *samplerChain) Close() {
+	s.base.Close()
+}
+
+// maximum number of operations tracked by firstSpanSampler
+const maxFirstSpanOperations = 2000
+
+// firstSpanSampler samples the first trace of every operation within each
+// window, and defers to base for the others. Traces sampled by base count as
+// the operation's trace for the window.
+//
+// At most maxFirstSpanOperations operations are tracked; once that many have
+// been sampled within the window, new operations are left to base.
+type firstSpanSampler struct {
+	jaeger.SamplerV2Base
+	base   jaeger.SamplerV2
+	window time.Duration
+	now    func() time.Time
+
+	mu          sync.Mutex
+	lastSampled map[string]time.Time
+}
+
+func newFirstSpanSampler(base jaeger.Sampler, window time.Duration) *firstSpanSampler {
+	return &firstSpanSampler{
+		base:        samplerV2(base),
+		window:      window,
+		now:         time.Now,
+		lastSampled: make(map[string]time.Time),
+	}
+}
+
+// decide overrides the decision of base for operation if the operation has
+// not been sampled within the window.
+func (s *firstSpanSampler) decide(operation string, d jaeger.SamplingDecision) jaeger.SamplingDecision {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last, seen := s.lastSampled[operation]
+	if !seen && !s.hasRoom(now) {
+		return d
+	}
+	if d.Sample {
+		s.lastSampled[operation] = now
+		return d
+	}
+	if seen && now.Sub(last) < s.window {
+		return d
+	}
+	s.lastSampled[operation] = now
+	return withRule(jaeger.SamplingDecision{Sample: true}, "first-span-per-operation")
+}
+
+// hasRoom returns whether another operation can be tracked, forgetting those
+// whose window has expired if the limit has been reached. s.mu must be held.
+func (s *firstSpanSampler) hasRoom(now time.Time) bool {
+	if len(s.lastSampled) < maxFirstSpanOperations {
+		return true
+	}
+	for operation, last := range s.lastSampled {
+		if now.Sub(last) >= s.window {
+			delete(s.lastSampled, operation)
+		}
+	}
+	return len(s.lastSampled) < maxFirstSpanOperations
+}
+
+// OnCreateSpan implements the OnCreateSpan() method of jaeger.SamplerV2.
+func (s *firstSpanSampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return s.decide(span.OperationName(), s.base.OnCreateSpan(span))
+}
+
+// OnSetOperationName implements the OnSetOperationName() method of jaeger.SamplerV2.
+func (s *firstSpanSampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	return s.decide(operationName, s.base.OnSetOperationName(span, operationName))
+}
+
+// OnSetTag implements the OnSetTag() method of jaeger.SamplerV2.
+func (s *firstSpanSampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	return s.base.OnSetTag(span, key, value)
+}
+
+// OnFinishSpan implements the OnFinishSpan() method of jaeger.SamplerV2.
+func (s *firstSpanSampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return s.base.OnFinishSpan(span)
+}
+
+// String describes the sampler for StatusHandler.
+func (s *firstSpanSampler) String() string {
+	return fmt.Sprintf("FirstSpanSampler(window=%v, base=%s)", s.window, describeSampler(s.base))
+}
+
+// Close implements the Close() method of jaeger.SamplerV2.
+func (s *firstSpanSampler) Close() {
+	s.base.Close()
+}
+
+// thresholdSampler defers to base for the first threshold traces started in
+// each minute, and samples the rest with the throttled sampler.
+//
+// Each trace counts once, when the sampler is first asked about it: spans
+// started under a parent whose decision other samplers left open, e.g. by
+// prioritySampler, are decided by the same sampler as their parent without
+// counting again.
+type thresholdSampler struct {
+	jaeger.SamplerV2Base
+	base      jaeger.SamplerV2
+	throttled jaeger.SamplerV2
+	threshold int
+	now       func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	count       int
+}
+
+// thresholdTrace is the state of a trace kept by thresholdSampler in its
+// extended sampling state, under thresholdTraceKey.
+type thresholdTrace struct {
+	once      sync.Once
+	throttled bool
+}
+
+type thresholdTraceKey struct{}
+
+func newThresholdTrace() interface{} {
+	return &thresholdTrace{}
+}
+
+func newThresholdSampler(base jaeger.Sampler, threshold int, throttledRate float64) (*thresholdSampler, error) {
+	throttled, err := jaeger.NewProbabilisticSampler(throttledRate)
+	if err != nil {
+		return nil, err
+	}
+	return &thresholdSampler{
+		base:      samplerV2(base),
+		throttled: throttled,
+		threshold: threshold,
+		now:       time.Now,
+	}, nil
+}
+
+// throttle records a new trace and returns whether the threshold of the current
+// minute has been passed.
+func (s *thresholdSampler) throttle() bool {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.windowStart) >= time.Minute {
+		s.windowStart = now.Truncate(time.Minute)
+		s.count = 0
+	}
+	s.count++
+	return s.count > s.threshold
+}
+
+// isThrottled returns whether the trace of span is sampled by the throttled
+// sampler, recording the trace the first time it is asked.
+func (s *thresholdSampler) isThrottled(span *jaeger.Span) bool {
+	t := span.SpanContext().ExtendedSamplingState(thresholdTraceKey{}, newThresholdTrace).(*thresholdTrace)
+	t.once.Do(func() {
+		t.throttled = s.throttle()
+	})
+	return t.throttled
+}
+
+// OnCreateSpan implements the OnCreateSpan() method of jaeger.SamplerV2.
+func (s *thresholdSampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	if s.isThrottled(span) {
+		return withRule(s.throttled.OnCreateSpan(span), "adaptive-throttled")
+	}
+	return s.base.OnCreateSpan(span)
+}
+
+// OnSetOperationName implements the OnSetOperationName() method of jaeger.SamplerV2.
+func (s *thresholdSampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	if s.isThrottled(span) {
+		return withRule(s.throttled.OnSetOperationName(span, operationName), "adaptive-throttled")
+	}
+	return s.base.OnSetOperationName(span, operationName)
+}
+
+// OnSetTag implements the OnSetTag() method of jaeger.SamplerV2.
+func (s *thresholdSampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	if s.isThrottled(span) {
+		return withRule(s.throttled.OnSetTag(span, key, value), "adaptive-throttled")
+	}
+	return s.base.OnSetTag(span, key, value)
+}
+
+// OnFinishSpan implements the OnFinishSpan() method of jaeger.SamplerV2.
+func (s *thresholdSampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	if s.isThrottled(span) {
+		return withRule(s.throttled.OnFinishSpan(span), "adaptive-throttled")
+	}
+	return s.base.OnFinishSpan(span)
+}
+
+// String describes the sampler for StatusHandler.
+func (s *thresholdSampler) String() string {
+	return fmt.Sprintf("ThresholdSampler(threshold=%d/min, throttled=%s, base=%s)",
+		s.threshold, describeSampler(s.throttled), describeSampler(s.base))
+}
+
+// Close implements the Close() method of jaeger.SamplerV2.
+func (s *thresholdSampler) Close() {
+	s.base.Close()
+	s.throttled.Close()
+}
+
+// tag evaluated by prioritySampler
+const priorityTag = "priority"
+
+// prioritySampler samples every trace with a span tagged with a priority of
+// at least its threshold, and defers to base for the others.
+//
+// jaeger applies the tags given when a span is started only after asking the
+// sampler about the span, so decisions not to sample are kept open until the
+// first span of the trace in this process finishes. Until then, the priority
+// tag is evaluated whenever it is set, whether at span creation or later.
+// Spans already sent downstream meanwhile carry the unsampled flag.
+type prioritySampler struct {
+	jaeger.SamplerV2Base
+	base      jaeger.SamplerV2
+	threshold float64
+}
+
+func newPrioritySampler(base jaeger.Sampler, threshold int) *prioritySampler {
+	return &prioritySampler{base: samplerV2(base), threshold: float64(threshold)}
+}
+
+// reopen keeps a decision not to sample open to a priority tag.
+func reopen(d jaeger.SamplingDecision) jaeger.SamplingDecision {
+	if !d.Sample {
+		d.Retryable = true
+	}
+	return d
+}
+
+// priority returns the numeric value of a priority tag.
+func priority(value interface{}) (float64, bool) {
+	if v, ok := value.(string); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return numeric(value)
+}
+
+// numeric returns the value of a tag of a numeric type as a float64.
+func numeric(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// OnCreateSpan implements the OnCreateSpan() method of jaeger.SamplerV2.
+func (s *prioritySampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return reopen(s.base.OnCreateSpan(span))
+}
+
+// OnSetOperationName implements the OnSetOperationName() method of jaeger.SamplerV2.
+func (s *prioritySampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	return reopen(s.base.OnSetOperationName(span, operationName))
+}
+
+// OnSetTag implements the OnSetTag() method of jaeger.SamplerV2.
+func (s *prioritySampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	if key == priorityTag {
+		if p, ok := priority(value); ok && p >= s.threshold {
+			return withRule(jaeger.SamplingDecision{Sample: true}, "priority")
+		}
+	}
+	return reopen(s.base.OnSetTag(span, key, value))
+}
+
+// OnFinishSpan implements the OnFinishSpan() method of jaeger.SamplerV2. Its
+// decision is final so that unsampled traces stop recording tags.
+func (s *prioritySampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	d := s.base.OnFinishSpan(span)
+	d.Retryable = false
+	return d
+}
+
+// String describes the sampler for StatusHandler.
+func (s *prioritySampler) String() string {
+	return fmt.Sprintf("PrioritySampler(threshold=%v, base=%s)", s.threshold, describeSampler(s.base))
+}
+
+// Close implements the Close() method of jaeger.SamplerV2.
+func (s *prioritySampler) Close() {
+	s.base.Close()
+}
+
+// peerSampler samples every trace with a span tagged with one of its peer
+// services, and defers to base for the others. Like prioritySampler, it keeps
+// decisions not to sample open until the first span of the trace in this
+// process finishes.
+type peerSampler struct {
+	jaeger.SamplerV2Base
+	base  jaeger.SamplerV2
+	peers map[string]bool
+}
+
+func newPeerSampler(base jaeger.Sampler, peers []string) *peerSampler {
+	s := &peerSampler{base: samplerV2(base), peers: make(map[string]bool, len(peers))}
+	for _, peer := range peers {
+		s.peers[peer] = true
+	}
+	return s
+}
+
+// OnCreateSpan implements the OnCreateSpan() method of jaeger.SamplerV2.
+func (s *peerSampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return reopen(s.base.OnCreateSpan(span))
+}
+
+// OnSetOperationName implements the OnSetOperationName() method of jaeger.SamplerV2.
+func (s *peerSampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	return reopen(s.base.OnSetOperationName(span, operationName))
+}
+
+// OnSetTag implements the OnSetTag() method of jaeger.SamplerV2.
+func (s *peerSampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	if key == string(ext.PeerService) {
+		if peer, ok := value.(string); ok && s.peers[peer] {
+			return withRule(jaeger.SamplingDecision{Sample: true}, "peer:"+peer)
+		}
+	}
+	return reopen(s.base.OnSetTag(span, key, value))
+}
+
+// OnFinishSpan implements the OnFinishSpan() method of jaeger.SamplerV2. Its
+// decision is final so that unsampled traces stop recording tags.
+func (s *peerSampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	d := s.base.OnFinishSpan(span)
+	d.Retryable = false
+	return d
+}
+
+// String describes the sampler for StatusHandler.
+func (s *peerSampler) String() string {
+	return fmt.Sprintf("PeerSampler(peers=%d, base=%s)", len(s.peers), describeSampler(s.base))
+}
+
+// Close implements the Close() method of jaeger.SamplerV2.
+func (s *peerSampler) Close() {
+	s.base.Close()
+}
+
+// errorSampler samples traces with a span tagged as failed, up to a rate of
+// maxPerSecond traces, and defers to base for the others and for failed
+// traces past that rate. Like prioritySampler, it keeps decisions not to
+// sample open until the first span of the trace in this process finishes.
+type errorSampler struct {
+	jaeger.SamplerV2Base
+	base         jaeger.SamplerV2
+	maxPerSecond float64
+	limiter      utils.RateLimiter
+}
+
+func newErrorSampler(base jaeger.Sampler, maxPerSecond float64) *errorSampler {
+	return &errorSampler{
+		base:         samplerV2(base),
+		maxPerSecond: maxPerSecond,
+		limiter:      utils.NewRateLimiter(maxPerSecond, math.Max(maxPerSecond, 1)),
+	}
+}
+
+// OnCreateSpan implements the OnCreateSpan() method of jaeger.SamplerV2.
+func (s *errorSampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return reopen(s.base.OnCreateSpan(span))
+}
+
+// OnSetOperationName implements the OnSetOperationName() method of jaeger.SamplerV2.
+func (s *errorSampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	return reopen(s.base.OnSetOperationName(span, operationName))
+}
+
+// OnSetTag implements the OnSetTag() method of jaeger.SamplerV2.
+func (s *errorSampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	if key == string(ext.Error) {
+		if failed, ok := value.(bool); ok && failed && s.limiter.CheckCredit(1) {
+			return withRule(jaeger.SamplingDecision{Sample: true}, "error")
+		}
+	}
+	return reopen(s.base.OnSetTag(span, key, value))
+}
+
+// OnFinishSpan implements the OnFinishSpan() method of jaeger.SamplerV2. Its
+// decision is final so that unsampled traces stop recording tags.
+func (s *errorSampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	d := s.base.OnFinishSpan(span)
+	d.Retryable = false
+	return d
+}
+
+// String describes the sampler for StatusHandler.
+func (s *errorSampler) String() string {
+	return fmt.Sprintf("ErrorSampler(maxPerSecond=%v, base=%s)", s.maxPerSecond, describeSampler(s.base))
+}
+
+// Close implements the Close() method of jaeger.SamplerV2.
+func (s *errorSampler) Close() {
+	s.base.Close()
+}
+
+// loggingSampler logs every final decision of the wrapped sampler.
+type loggingSampler struct {
+	jaeger.SamplerV2Base
+	base jaeger.SamplerV2
+}
+
+func newLoggingSampler(base jaeger.Sampler) *loggingSampler {
+	return &loggingSampler{base: samplerV2(base)}
+}
+
+func (s *loggingSampler) log(hook string, span *jaeger.Span, operation string, d jaeger.SamplingDecision) jaeger.SamplingDecision {
+	if d.Sample || !d.Retryable {
+		glog.Infof("Sampling decision on %s for operation %q of trace %s: sampled=%t", hook, operation, span.SpanContext().TraceID(), d.Sample)
+	}
+	return d
+}
+
+// OnCreateSpan implements the OnCreateSpan() method of jaeger.SamplerV2.
+func (s *loggingSampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return s.log("create", span, span.OperationName(), s.base.OnCreateSpan(span))
+}
+
+// OnSetOperationName implements the OnSetOperationName() method of jaeger.SamplerV2.
+func (s *loggingSampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	return s.log("set operation name", span, operationName, s.base.OnSetOperationName(span, operationName))
+}
+
+// OnSetTag implements the OnSetTag() method of jaeger.SamplerV2.
+func (s *loggingSampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	return s.log("set tag "+key, span, span.OperationName(), s.base.OnSetTag(span, key, value))
+}
+
+// OnFinishSpan implements the OnFinishSpan() method of jaeger.SamplerV2.
+func (s *loggingSampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return s.log("finish", span, span.OperationName(), s.base.OnFinishSpan(span))
+}
+
+// String describes the sampler for StatusHandler.
+func (s *loggingSampler) String() string {
+	return describeSampler(s.base)
+}
+
+// Close implements the Close() method of jaeger.SamplerV2.
+func (s *loggingSampler) Close() {
+	s.base.Close()
+}
@@ -0,0 +1,515 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/glog"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// startWithBaggage starts a root span carrying the baggage of an inbound
+// jaeger-baggage header, and returns whether it is sampled.
+func startWithBaggage(t *testing.T, baggage string) bool {
+	t.Helper()
+	header := http.Header{}
+	header.Set(jaeger.JaegerBaggageHeader, baggage)
+	parent, err := ot.GlobalTracer().Extract(ot.HTTPHeaders, ot.HTTPHeadersCarrier(header))
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	span := ot.StartSpan("op", ot.ChildOf(parent))
+	defer span.Finish()
+	return span.Context().(jaeger.SpanContext).IsSampled()
+}
+
+func TestBaggageSamplingRules(t *testing.T) {
+	tracer := configureCollector(t, &Options{
+		SamplerType:          "const",
+		SamplerParam:         0,
+		BaggageSamplingRules: map[string]float64{"tenant:acme": 1, "tenant:noisy": 0},
+	})
+	defer tracer.Close()
+
+	if !startWithBaggage(t, "tenant=acme") {
+		t.Error("trace matching a rule of rate 1 wasn't sampled")
+	}
+	if startWithBaggage(t, "tenant=noisy") {
+		t.Error("trace matching a rule of rate 0 was sampled")
+	}
+	if startWithBaggage(t, "tenant=other") {
+		t.Error("trace matching no rule wasn't left to the const sampler")
+	}
+
+	spans := tracer.spans()
+	if len(spans) != 1 || spans[0].Tags[samplingRuleTag] != "baggage:tenant:acme" {
+		t.Errorf("got spans %+v, want the one matching tenant:acme tagged with its rule", spans)
+	}
+}
+
+func TestValidateBaggageSamplingRules(t *testing.T) {
+	for rules, valid := range map[string]bool{"tenant:acme": true, "tenant": false, ":acme": false} {
+		err := validateBaggageSamplingRules(map[string]float64{rules: 0.5})
+		if valid != (err == nil) {
+			t.Errorf("validateBaggageSamplingRules(%q) = %v", rules, err)
+		}
+	}
+	if err := validateBaggageSamplingRules(map[string]float64{"tenant:acme": 2}); err == nil {
+		t.Error("rate above 1 accepted")
+	}
+}
+
+func TestEnvironmentSampleRates(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		options Options
+		want    float64
+	}{
+		{"dev", Options{Environment: "dev"}, 1},
+		{"prod", Options{Environment: "prod"}, 0.01},
+		{"overridden", Options{Environment: "prod", EnvironmentSampleRates: map[string]float64{"prod": 0.5}}, 0.5},
+		{"custom", Options{Environment: "qa", EnvironmentSampleRates: map[string]float64{"qa": 0.2}}, 0.2},
+		{"explicit sampler", Options{Environment: "prod", SamplerType: "probabilistic", SamplerParam: 0.3}, 0.3},
+	} {
+		s, err := newLocalSampler(&tt.options)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		p, ok := s.(*jaeger.ProbabilisticSampler)
+		if !ok || p.SamplingRate() != tt.want {
+			t.Errorf("%s: got sampler %v, want probability %v", tt.name, s, tt.want)
+		}
+	}
+}
+
+func TestFirstSpanSampler(t *testing.T) {
+	now := time.Now()
+	s := newFirstSpanSampler(jaeger.NewConstSampler(false), time.Minute)
+	s.now = func() time.Time { return now }
+	tracer, closer := jaeger.NewTracer("svc", s, jaeger.NewNullReporter())
+	defer closer.Close()
+
+	for i, tt := range []struct {
+		operation string
+		advance   time.Duration
+		want      bool
+	}{
+		{"a", 0, true},
+		{"a", 0, false},
+		{"b", 0, true},
+		{"a", 30 * time.Second, false},
+		{"a", 30 * time.Second, true},
+		{"b", 0, true},
+		{"b", 0, false},
+	} {
+		now = now.Add(tt.advance)
+		span := tracer.StartSpan(tt.operation)
+		if got := span.Context().(jaeger.SpanContext).IsSampled(); got != tt.want {
+			t.Errorf("%d: %s sampled=%t, want %t", i, tt.operation, got, tt.want)
+		}
+		span.Finish()
+	}
+}
+
+func TestThresholdSampler(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	s, err := newThresholdSampler(jaeger.NewConstSampler(true), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now }
+	tracer, closer := jaeger.NewTracer("svc", s, jaeger.NewNullReporter())
+	defer closer.Close()
+
+	sampledOf := func(n int) int {
+		sampled := 0
+		for i := 0; i < n; i++ {
+			span := tracer.StartSpan("op")
+			if span.Context().(jaeger.SpanContext).IsSampled() {
+				sampled++
+			}
+			span.Finish()
+			now = now.Add(time.Second)
+		}
+		return sampled
+	}
+	if got := sampledOf(10); got != 10 {
+		t.Errorf("got %d traces sampled below the threshold, want 10", got)
+	}
+	if got := sampledOf(40); got != 0 {
+		t.Errorf("got %d traces sampled past the threshold, want 0", got)
+	}
+	now = now.Truncate(time.Minute).Add(time.Minute)
+	if got := sampledOf(10); got != 10 {
+		t.Errorf("got %d traces sampled once the minute is over, want 10", got)
+	}
+}
+
+func TestThresholdSamplerCountsTraces(t *testing.T) {
+	s, err := newThresholdSampler(jaeger.NewConstSampler(false), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC) }
+	// leaves unsampled decisions open, so that children consult s again
+	tracer, closer := jaeger.NewTracer("svc", newPrioritySampler(s, 1), jaeger.NewNullReporter())
+	defer closer.Close()
+
+	for i := 0; i < 2; i++ {
+		root := tracer.StartSpan("root")
+		children := make([]ot.Span, 10)
+		var wg sync.WaitGroup
+		for j := range children {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				children[j] = tracer.StartSpan("child", ot.ChildOf(root.Context()))
+			}(j)
+		}
+		wg.Wait()
+		for _, child := range children {
+			child.Finish()
+		}
+		root.Finish()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count != 2 {
+		t.Errorf("got %d requests counted for 2 traces of 11 spans", s.count)
+	}
+}
+
+func TestPrioritySampler(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("svc", newPrioritySampler(jaeger.NewConstSampler(false), 1), jaeger.NewNullReporter())
+	defer closer.Close()
+
+	for _, tt := range []struct {
+		name   string
+		start  []ot.StartSpanOption
+		tagged interface{}
+		want   bool
+	}{
+		{"absent", nil, nil, false},
+		{"at start", []ot.StartSpanOption{ot.Tag{Key: "priority", Value: 1}}, nil, true},
+		{"set later", nil, 2, true},
+		{"string", nil, "1", true},
+		{"below threshold", []ot.StartSpanOption{ot.Tag{Key: "priority", Value: 0}}, nil, false},
+		{"not numeric", nil, "high", false},
+	} {
+		span := tracer.StartSpan("op", tt.start...)
+		if tt.tagged != nil {
+			span.SetTag("priority", tt.tagged)
+		}
+		if got := span.Context().(jaeger.SpanContext).IsSampled(); got != tt.want {
+			t.Errorf("%s: sampled=%t, want %t", tt.name, got, tt.want)
+		}
+		span.Finish()
+	}
+}
+
+func TestConsistentProbabilisticSampling(t *testing.T) {
+	options := &Options{SamplerType: "probabilistic", SamplerParam: 0.5}
+	var tracers []*jaeger.Tracer
+	for _, service := range []string{"frontend", "backend"} {
+		s, err := newSamplerChain(service, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracer, closer := jaeger.NewTracer(service, s, jaeger.NewNullReporter())
+		defer closer.Close()
+		tracers = append(tracers, tracer.(*jaeger.Tracer))
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	var sampled int
+	for i := 0; i < 100; i++ {
+		traceID := jaeger.TraceID{Low: uint64(rng.Int63())}
+		want := SampleTraceID(traceID, 0.5)
+		for _, tracer := range tracers {
+			sc := jaeger.NewSpanContext(traceID, jaeger.SpanID(traceID.Low), 0, false, nil)
+			span := tracer.StartSpan("op", jaeger.SelfRef(sc))
+			if got := span.Context().(jaeger.SpanContext).IsSampled(); got != want {
+				t.Errorf("trace %s: got sampled=%t, want %t", traceID, got, want)
+			}
+			span.Finish()
+		}
+		if want {
+			sampled++
+		}
+		if SampleTraceID(traceID, 0.1) && !want {
+			t.Errorf("trace %s sampled at 0.1 but not at 0.5", traceID)
+		}
+	}
+	if sampled == 0 || sampled == 100 {
+		t.Errorf("got %d of 100 traces sampled at 0.5", sampled)
+	}
+}
+
+func TestPeerSampler(t *testing.T) {
+	tracer := configureCollector(t, &Options{
+		SamplerType:       "probabilistic",
+		SamplerParam:      0,
+		AlwaysSamplePeers: []string{"payments"},
+	})
+	defer tracer.Close()
+
+	root, ctx := StartSpan(context.Background(), "checkout")
+	child, _ := StartSpan(ctx, "charge")
+	child.SetTag(string(ext.PeerService), "payments")
+	child.Finish()
+	root.Finish()
+	other, _ := StartSpan(context.Background(), "browse")
+	other.SetTag(string(ext.PeerService), "catalog")
+	other.Finish()
+
+	var got []string
+	for _, s := range tracer.spans() {
+		got = append(got, s.Operation)
+	}
+	if want := []string{"charge", "checkout"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got spans %v reported, want %v", got, want)
+	}
+}
+
+func TestSamplerDecider(t *testing.T) {
+	decider := func(operation string, ctx SamplerContext) (bool, bool) {
+		switch operation {
+		case "beta":
+			return true, true
+		case "internal":
+			return false, true
+		}
+		return false, false
+	}
+	for _, base := range []bool{false, true} {
+		param := 0.0
+		if base {
+			param = 1
+		}
+		closer := configureRecording(t, &Options{SamplerType: "const", SamplerParam: param, SamplerDecider: decider})
+		for operation, want := range map[string]bool{"beta": true, "internal": false, "other": base} {
+			span, ctx := StartSpan(context.Background(), operation)
+			if got := IsSampled(ctx); got != want {
+				t.Errorf("base=%t: %s sampled=%t, want %t", base, operation, got, want)
+			}
+			span.Finish()
+		}
+		closer.Close()
+	}
+}
+
+func TestMaxErrorSamplesPerSecond(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("svc", newErrorSampler(jaeger.NewConstSampler(false), 5), jaeger.NewNullReporter())
+	defer closer.Close()
+
+	var sampled int
+	for i := 0; i < 20; i++ {
+		span := tracer.StartSpan("op")
+		ext.Error.Set(span, true)
+		if span.Context().(jaeger.SpanContext).IsSampled() {
+			sampled++
+		}
+		span.Finish()
+	}
+	if sampled != 5 {
+		t.Errorf("got %d of 20 failed traces sampled, want the 5 of the bucket", sampled)
+	}
+	span := tracer.StartSpan("op")
+	span.SetTag("error", false)
+	if span.Context().(jaeger.SpanContext).IsSampled() {
+		t.Error("sampled a trace which didn't fail")
+	}
+	span.Finish()
+}
+
+func TestSamplerRules(t *testing.T) {
+	var evaluated []string
+	rules := []SamplerRule{
+		func(operation string, ctx SamplerContext) SamplingVerdict {
+			evaluated = append(evaluated, "drop healthz")
+			if operation == "healthz" {
+				return SamplingDrop
+			}
+			return SamplingDefer
+		},
+		func(operation string, ctx SamplerContext) SamplingVerdict {
+			evaluated = append(evaluated, "sample health*")
+			if strings.HasPrefix(operation, "health") {
+				return SamplingSample
+			}
+			return SamplingDefer
+		},
+	}
+	for _, base := range []bool{false, true} {
+		param := 0.0
+		if base {
+			param = 1
+		}
+		closer := configureRecording(t, &Options{SamplerType: "const", SamplerParam: param, SamplerRules: rules})
+		for _, tt := range []struct {
+			operation string
+			want      bool
+			evaluated int
+		}{
+			{"healthz", false, 1},
+			{"healthcheck", true, 2},
+			{"checkout", base, 2},
+		} {
+			evaluated = nil
+			span, ctx := StartSpan(context.Background(), tt.operation)
+			if got := IsSampled(ctx); got != tt.want {
+				t.Errorf("base=%t: %s sampled=%t, want %t", base, tt.operation, got, tt.want)
+			}
+			if len(evaluated) != tt.evaluated {
+				t.Errorf("base=%t: %s evaluated rules %v, want the first %d", base, tt.operation, evaluated, tt.evaluated)
+			}
+			span.Finish()
+		}
+		if got := waitForRecentSpans(t, 1)[0].Tags[samplingRuleTag]; got != "rule:1" {
+			t.Errorf("base=%t: got %s tag %v, want rule:1", base, samplingRuleTag, got)
+		}
+		closer.Close()
+	}
+}
+
+func TestSamplingRuleTag(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		options Options
+		tags    ot.Tags
+		want    interface{}
+	}{
+		{"base sampler", Options{}, nil, nil},
+		{"first span", Options{FirstSpanPerOperationWindow: time.Minute}, nil, "first-span-per-operation"},
+		{"priority", Options{AlwaysSamplePriority: 1}, ot.Tags{"priority": 1}, "priority"},
+		{"error", Options{MaxErrorSamplesPerSecond: 10}, ot.Tags{"error": true}, "error"},
+	} {
+		options := tt.options
+		if tt.want != nil {
+			// left to the decorator under test
+			options.SamplerType = "const"
+		}
+		tracer := configureCollector(t, &options)
+		span, _ := StartSpan(context.Background(), "op")
+		for k, v := range tt.tags {
+			span.SetTag(k, v)
+		}
+		span.Finish()
+		if got := tracer.onlySpan(t).Tags[samplingRuleTag]; got != tt.want {
+			t.Errorf("%s: got %s tag %v, want %v", tt.name, samplingRuleTag, got, tt.want)
+		}
+		tracer.Close()
+	}
+}
+
+// decisionLines returns the number of info lines logged while starting and
+// finishing n root spans, each with a child.
+func decisionLines(n int) int64 {
+	before := glog.Stats.Info.Lines()
+	for i := 0; i < n; i++ {
+		root, ctx := StartSpan(context.Background(), "root")
+		child, _ := StartSpan(ctx, "child")
+		child.Finish()
+		root.Finish()
+	}
+	return glog.Stats.Info.Lines() - before
+}
+
+func TestLogSamplingDecisions(t *testing.T) {
+	closer := configureCollector(t, &Options{LogSamplingDecisions: true})
+	if n := decisionLines(3); n != 3 {
+		t.Errorf("got %d lines logged for 3 sampled roots, want 3", n)
+	}
+	closer.Close()
+
+	closer = configureCollector(t, &Options{})
+	defer closer.Close()
+	if n := decisionLines(3); n != 0 {
+		t.Errorf("got %d lines logged with LogSamplingDecisions unset, want none", n)
+	}
+}
+
+func TestSamplingFallbackRate(t *testing.T) {
+	var online int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&online) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"strategyType": "PROBABILISTIC", "probabilisticSampling": {"samplingRate": 0.5}}`))
+	}))
+	defer server.Close()
+
+	rate := 0.25
+	s, err := newSampler("svc", &Options{SamplingServerURL: server.URL, SamplingFallbackRate: &rate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := s.(*jaeger.RemotelyControlledSampler)
+	defer remote.Close()
+	samplingRate := func() float64 {
+		p, ok := remote.Sampler().(*jaeger.ProbabilisticSampler)
+		if !ok {
+			t.Fatalf("got sampler %v, want a probabilistic one", remote.Sampler())
+		}
+		return p.SamplingRate()
+	}
+
+	remote.UpdateSampler()
+	if got := samplingRate(); got != rate {
+		t.Errorf("got rate %v with the sampling server offline, want the fallback %v", got, rate)
+	}
+	atomic.StoreInt32(&online, 1)
+	remote.UpdateSampler()
+	if got := samplingRate(); got != 0.5 {
+		t.Errorf("got rate %v with the sampling server online, want the one served", got)
+	}
+}
+
+func TestLowerBoundPerSecond(t *testing.T) {
+	defer configureCollector(t, &Options{
+		SamplerType:         "probabilistic",
+		SamplerParam:        0,
+		LowerBoundPerSecond: 1,
+	}).Close()
+
+	for _, operation := range []string{"rare", "other"} {
+		sampled := 0
+		for i := 0; i < 10; i++ {
+			span, _ := StartSpan(context.Background(), operation)
+			if span.Context().(jaeger.SpanContext).IsSampled() {
+				sampled++
+			}
+			span.Finish()
+		}
+		// a rate of 1/s leaves no room for a second trace within the test
+		if sampled != 1 {
+			t.Errorf("%s: got %d traces sampled, want the guaranteed one", operation, sampled)
+		}
+	}
+}
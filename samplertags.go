@@ -0,0 +1,104 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"strings"
+	"sync"
+
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// prefix of the tags jaeger records the sampling decision of a trace in,
+// e.g. sampler.type and sampler.param
+const samplerTagPrefix = "sampler."
+
+// samplerTagReporter copies the sampler tags which jaeger sets on the root
+// span of a trace onto the other spans of the trace started in this process.
+//
+// It doubles as a jaeger.ContribObserver to pick up the tags of root spans
+// when they are started, as their children are usually reported first. Spans
+// reported after their root has finished, and traces whose root is in
+// another process, are left alone.
+type samplerTagReporter struct {
+	jaeger.Reporter
+
+	mu   sync.Mutex
+	tags map[jaeger.TraceID]ot.Tags
+}
+
+func newSamplerTagReporter(rep jaeger.Reporter) *samplerTagReporter {
+	return &samplerTagReporter{
+		Reporter: rep,
+		tags:     make(map[jaeger.TraceID]ot.Tags),
+	}
+}
+
+// OnStartSpan implements the OnStartSpan() method of jaeger.ContribObserver.
+func (r *samplerTagReporter) OnStartSpan(sp ot.Span, operationName string, options ot.StartSpanOptions) (jaeger.ContribSpanObserver, bool) {
+	span, ok := sp.(*jaeger.Span)
+	if !ok {
+		return nil, false
+	}
+	sc := span.SpanContext()
+	if sc.ParentID() != 0 || !sc.IsSampled() {
+		return nil, false
+	}
+
+	tags := ot.Tags{}
+	for k, v := range span.Tags() {
+		if strings.HasPrefix(k, samplerTagPrefix) {
+			tags[k] = v
+		}
+	}
+	if len(tags) == 0 {
+		return nil, false
+	}
+	r.mu.Lock()
+	r.tags[sc.TraceID()] = tags
+	r.mu.Unlock()
+	return samplerTagSpanObserver{r, sc.TraceID()}, true
+}
+
+type samplerTagSpanObserver struct {
+	r       *samplerTagReporter
+	traceID jaeger.TraceID
+}
+
+func (samplerTagSpanObserver) OnSetOperationName(operationName string) {}
+
+func (samplerTagSpanObserver) OnSetTag(key string, value interface{}) {}
+
+// OnFinish forgets the tags of the trace once its root span finishes.
+func (o samplerTagSpanObserver) OnFinish(options ot.FinishOptions) {
+	o.r.mu.Lock()
+	delete(o.r.tags, o.traceID)
+	o.r.mu.Unlock()
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *samplerTagReporter) Report(span *jaeger.Span) {
+	sc := span.SpanContext()
+	if sc.ParentID() != 0 {
+		r.mu.Lock()
+		tags := r.tags[sc.TraceID()]
+		r.mu.Unlock()
+		for k, v := range tags {
+			span.SetTag(k, v)
+		}
+	}
+	r.Reporter.Report(span)
+}
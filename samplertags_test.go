@@ -0,0 +1,52 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPropagateSamplerTags(t *testing.T) {
+	tracer := configureCollector(t, &Options{PropagateSamplerTags: true})
+	defer tracer.Close()
+
+	root, ctx := StartSpan(context.Background(), "root")
+	child, _ := StartSpan(ctx, "child")
+	child.Finish()
+	root.Finish()
+
+	for _, span := range tracer.waitForSpans(t, 2) {
+		if span.Tags["sampler.type"] != "const" || span.Tags["sampler.param"] != true {
+			t.Errorf("%s: got tags %v, want the sampler tags of the root", span.Operation, span.Tags)
+		}
+	}
+}
+
+func TestSamplerTagsOnlyOnRootByDefault(t *testing.T) {
+	tracer := configureCollector(t, &Options{})
+	defer tracer.Close()
+
+	root, ctx := StartSpan(context.Background(), "root")
+	child, _ := StartSpan(ctx, "child")
+	child.Finish()
+	root.Finish()
+
+	for _, span := range tracer.waitForSpans(t, 2) {
+		if _, ok := span.Tags["sampler.type"]; ok != (span.Operation == "root") {
+			t.Errorf("%s: got tags %v", span.Operation, span.Tags)
+		}
+	}
+}
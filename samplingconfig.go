@@ -0,0 +1,149 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	jaeger "github.com/uber/jaeger-client-go"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// samplingConfig is the format of Options.SamplingConfigFile, e.g.
+//
+//	operations:
+//	- operation: "GET /healthz"
+//	  rate: 0
+//	- operation: "GET /api/*"
+//	  rate: 0.5
+//
+// JSON documents of the same shape are accepted too.
+type samplingConfig struct {
+	Operations []operationSamplingRule `yaml:"operations"`
+}
+
+type operationSamplingRule struct {
+	// Glob matched against the whole operation name, where * matches any
+	// sequence of characters and ? any single character.
+	Operation string `yaml:"operation"`
+
+	// Sampling probability of traces whose root span matches.
+	Rate float64 `yaml:"rate"`
+}
+
+// loadSamplingConfig reads and validates a sampling config file.
+func loadSamplingConfig(file string) (*samplingConfig, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := &samplingConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("could not parse sampling config %s: %v", file, err)
+	}
+	for i, rule := range config.Operations {
+		if rule.Operation == "" {
+			return nil, fmt.Errorf("sampling config %s: rule %d has no operation", file, i)
+		}
+		if rule.Rate < 0 || rule.Rate > 1 {
+			return nil, fmt.Errorf("sampling config %s: rule %q has rate %v outside of [0, 1]", file, rule.Operation, rule.Rate)
+		}
+	}
+	return config, nil
+}
+
+// globRegexp compiles a glob of operationSamplingRule into a regexp.
+func globRegexp(glob string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.Replace(pattern, `\*`, `.*`, -1)
+	pattern = strings.Replace(pattern, `\?`, `.`, -1)
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+type operationRule struct {
+	glob      string
+	operation *regexp.Regexp
+	sampler   *jaeger.ProbabilisticSampler
+}
+
+// operationSampler samples traces whose root span's operation matches one of
+// its rules at the rate of the first matching rule, and defers to base for
+// every other trace.
+type operationSampler struct {
+	jaeger.SamplerV2Base
+	base  jaeger.SamplerV2
+	rules []operationRule
+}
+
+func newOperationSampler(base jaeger.Sampler, config *samplingConfig) (*operationSampler, error) {
+	s := &operationSampler{base: samplerV2(base)}
+	for _, rule := range config.Operations {
+		ps, err := jaeger.NewProbabilisticSampler(rule.Rate)
+		if err != nil {
+			return nil, err
+		}
+		s.rules = append(s.rules, operationRule{rule.Operation, globRegexp(rule.Operation), ps})
+	}
+	return s, nil
+}
+
+func (s *operationSampler) decide(span *jaeger.Span, operation string) (jaeger.SamplingDecision, bool) {
+	for _, rule := range s.rules {
+		if rule.operation.MatchString(operation) {
+			sampled, tags := rule.sampler.IsSampled(span.SpanContext().TraceID(), operation)
+			return withRule(jaeger.SamplingDecision{Sample: sampled, Tags: tags}, "operation:"+rule.glob), true
+		}
+	}
+	return jaeger.SamplingDecision{}, false
+}
+
+// OnCreateSpan implements the OnCreateSpan() method of jaeger.SamplerV2.
+func (s *operationSampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	if d, ok := s.decide(span, span.OperationName()); ok {
+		return d
+	}
+	return s.base.OnCreateSpan(span)
+}
+
+// OnSetOperationName implements the OnSetOperationName() method of jaeger.SamplerV2.
+func (s *operationSampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	if d, ok := s.decide(span, operationName); ok {
+		return d
+	}
+	return s.base.OnSetOperationName(span, operationName)
+}
+
+// OnSetTag implements the OnSetTag() method of jaeger.SamplerV2.
+func (s *operationSampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	return s.base.OnSetTag(span, key, value)
+}
+
+// OnFinishSpan implements the OnFinishSpan() method of jaeger.SamplerV2.
+func (s *operationSampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return s.base.OnFinishSpan(span)
+}
+
+// String describes the sampler for StatusHandler.
+func (s *operationSampler) String() string {
+	return fmt.Sprintf("OperationSampler(rules=%d, base=%s)", len(s.rules), describeSampler(s.base))
+}
+
+// Close implements the Close() method of jaeger.SamplerV2.
+func (s *operationSampler) Close() {
+	s.base.Close()
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// writeTemp writes content to a file of dir and returns its path.
+func writeTemp(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSamplingConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sampling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{
+		"yaml": "operations:\n- operation: \"GET /healthz\"\n  rate: 0\n",
+		"json": `{"operations": [{"operation": "GET /healthz", "rate": 0}]}`,
+	} {
+		config, err := loadSamplingConfig(writeTemp(t, dir, name, content))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(config.Operations) != 1 || config.Operations[0].Operation != "GET /healthz" {
+			t.Errorf("%s: got %+v", name, config)
+		}
+	}
+
+	for name, content := range map[string]string{
+		"malformed":    "operations: [",
+		"unknown":      "operations:\n- operation: a\n  ratio: 1\n",
+		"no operation": "operations:\n- rate: 1\n",
+		"rate":         "operations:\n- operation: a\n  rate: 2\n",
+	} {
+		if _, err := loadSamplingConfig(writeTemp(t, dir, "invalid", content)); err == nil {
+			t.Errorf("%s: loaded an invalid config", name)
+		}
+	}
+	if _, err := loadSamplingConfig(filepath.Join(dir, "missing")); err == nil {
+		t.Error("loaded a missing file")
+	}
+}
+
+func TestOperationSampler(t *testing.T) {
+	s, err := newOperationSampler(jaeger.NewConstSampler(true), &samplingConfig{
+		Operations: []operationSamplingRule{
+			{Operation: "GET /healthz", Rate: 0},
+			{Operation: "GET /api/*", Rate: 1},
+			{Operation: "GET /api/internal", Rate: 0},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer, closer := jaeger.NewTracer("svc", s, jaeger.NewNullReporter())
+	defer closer.Close()
+
+	for operation, want := range map[string]bool{
+		"GET /healthz":      false,
+		"GET /healthz/deep": true,
+		"GET /api/users":    true,
+		"GET /api/internal": true, // the first matching rule wins
+		"POST /orders":      true,
+	} {
+		span := tracer.StartSpan(operation)
+		if got := span.Context().(jaeger.SpanContext).IsSampled(); got != want {
+			t.Errorf("%s: sampled=%t, want %t", operation, got, want)
+		}
+		span.Finish()
+	}
+}
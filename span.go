@@ -0,0 +1,425 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// Enabled returns whether spans started through this package are recorded,
+// i.e. whether a tracer other than the no-op one is installed as the global
+// tracer and Quiesce hasn't been called. The helpers of this package check it
+// before doing any work, and instrumentation can use it to skip building
+// expensive tags or operation names.
+//
+// Contexts carrying a tracer stored by WithTracer are checked against that
+// tracer instead.
+func Enabled() bool {
+	return tracerFor(context.Background()) != nil
+}
+
+type tracerKey struct{}
+
+// WithTracer returns a copy of ctx in which the helpers of this package, such
+// as StartSpan, record spans with tracer rather than the global tracer, e.g.
+// to attribute a subtree of a request's work to another service.
+//
+// The tracer is looked up in the context first and the global tracer is only
+// used when the context carries none.
+func WithTracer(ctx context.Context, tracer ot.Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, tracer)
+}
+
+// tracerFor returns the tracer recording spans started from ctx: the one
+// stored by WithTracer, if any, and the global tracer otherwise. It returns
+// nil if spans are not recorded, because the tracer is a no-op one or Quiesce
+// has been called.
+func tracerFor(ctx context.Context) ot.Tracer {
+	if isQuiescing() {
+		return nil
+	}
+	tracer, ok := ctx.Value(tracerKey{}).(ot.Tracer)
+	if !ok {
+		tracer = ot.GlobalTracer()
+	}
+	if _, isNoop := tracer.(ot.NoopTracer); isNoop {
+		return nil
+	}
+	return tracer
+}
+
+// propagatorFor returns the tracer whose propagation format carries the trace
+// context of ctx across processes: the one stored by WithTracer, if any, and
+// the global tracer otherwise. Unlike tracerFor, it never returns nil.
+func propagatorFor(ctx context.Context) ot.Tracer {
+	if tracer, ok := ctx.Value(tracerKey{}).(ot.Tracer); ok {
+		return tracer
+	}
+	return ot.GlobalTracer()
+}
+
+// StartSpan starts a span named operation as a child of the span active in
+// ctx, if any, and returns it along with a context carrying it.
+//
+// When tracing is not Enabled, a no-op span and ctx itself are returned.
+func StartSpan(ctx context.Context, operation string, opts ...ot.StartSpanOption) (ot.Span, context.Context) {
+	return startSpan(ctx, operation, 1, opts)
+}
+
+// startSpan implements StartSpan for the helpers of this package, depth being
+// the number of their frames between startSpan and the instrumented code.
+func startSpan(ctx context.Context, operation string, depth int, opts []ot.StartSpanOption) (ot.Span, context.Context) {
+	tracer := tracerFor(ctx)
+	if tracer == nil {
+		return ot.NoopTracer{}.StartSpan(operation), ctx
+	}
+	if parent := ot.SpanFromContext(ctx); parent != nil {
+		opts = append(opts, ot.ChildOf(parent.Context()))
+	}
+	ctx, opts = applyForcedSampling(ctx, opts)
+	span := tracer.StartSpan(operation, opts...)
+	tagCaller(span, depth)
+	ctx = ot.ContextWithSpan(ctx, span)
+	if currentOptions().TagDeadlines {
+		TagDeadline(ctx)
+	}
+	return span, ctx
+}
+
+// tag recording the time left before the deadline of a span's context
+const deadlineRemainingTag = "deadline.remaining_ms"
+
+// TagDeadline tags the span active in ctx with the milliseconds left before
+// the deadline of ctx, negative once it has passed, e.g. to diagnose
+// cascading timeouts. It does nothing if ctx carries no span or no deadline.
+// The helpers of this package call it as they start spans when enabled by
+// Options.TagDeadlines.
+func TagDeadline(ctx context.Context) {
+	span := ot.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		span.SetTag(deadlineRemainingTag, int64(time.Until(deadline)/time.Millisecond))
+	}
+}
+
+// tags recording where a span was started, see Options.TagCallerInfo
+const (
+	codeFilepathTag = "code.filepath"
+	codeLinenoTag   = "code.lineno"
+)
+
+// tagCaller tags span with the file and line of the instrumented code which
+// started it, if enabled by Options.TagCallerInfo and span is sampled. depth
+// is the number of frames of the helpers of this package between the caller
+// of tagCaller and the instrumented code.
+func tagCaller(span ot.Span, depth int) {
+	if !currentOptions().TagCallerInfo {
+		return
+	}
+	if js, ok := span.(*jaeger.Span); !ok || !js.SpanContext().IsSampled() {
+		return
+	}
+	if _, file, line, ok := runtime.Caller(depth + 2); ok {
+		span.SetTag(codeFilepathTag, file)
+		span.SetTag(codeLinenoTag, line)
+	}
+}
+
+type forceSampleKey struct{}
+
+// ForceSampleContext returns a copy of ctx in which the next span started by
+// the helpers of this package, such as StartSpan or NewHandler, forces the
+// sampling decision of its trace to sampled or not, e.g. for middleware
+// knowing whether a request is interesting before its span is started. The
+// decision is applied through the sampling.priority tag, so it holds for the
+// whole trace in this process and is propagated downstream. Contexts returned
+// by the helpers no longer carry it.
+func ForceSampleContext(ctx context.Context, sampled bool) context.Context {
+	return context.WithValue(ctx, forceSampleKey{}, sampled)
+}
+
+// applyForcedSampling appends the option forcing the sampling decision stored
+// in ctx by ForceSampleContext to opts, if any, and returns ctx without it.
+func applyForcedSampling(ctx context.Context, opts []ot.StartSpanOption) (context.Context, []ot.StartSpanOption) {
+	sampled, ok := ctx.Value(forceSampleKey{}).(bool)
+	if !ok {
+		return ctx, opts
+	}
+	priority := uint16(0)
+	if sampled {
+		priority = 1
+	}
+	opts = append(opts, ot.Tag{Key: string(ext.SamplingPriority), Value: priority})
+	return context.WithValue(ctx, forceSampleKey{}, nil), opts
+}
+
+// IsSampled returns whether the span active in ctx is sampled, i.e. recorded.
+// It returns false if ctx carries no span or a span not started by a jaeger
+// tracer.
+func IsSampled(ctx context.Context) bool {
+	span, ok := ot.SpanFromContext(ctx).(*jaeger.Span)
+	return ok && span.SpanContext().IsSampled()
+}
+
+// StartSpanIfSampled is StartSpan for hot code paths: a child span is only
+// started if the span active in ctx IsSampled, and the returned bool reports
+// whether it was. Otherwise a no-op span and ctx itself are returned without
+// allocating, and no trace is ever started from a ctx carrying no span.
+func StartSpanIfSampled(ctx context.Context, operation string, opts ...ot.StartSpanOption) (ot.Span, context.Context, bool) {
+	if !IsSampled(ctx) {
+		return noopSpan, ctx, false
+	}
+	span, ctx := startSpan(ctx, operation, 1, opts)
+	return span, ctx, true
+}
+
+// noopSpan is returned in place of spans which aren't started.
+var noopSpan = ot.NoopTracer{}.StartSpan("")
+
+// StartSpanAt is StartSpan for a span which started at startTime rather than
+// now, e.g. when reconstructing historical traces from a log. Pair it with
+// FinishAt.
+func StartSpanAt(ctx context.Context, operation string, startTime time.Time, opts ...ot.StartSpanOption) (ot.Span, context.Context) {
+	return startSpan(ctx, operation, 1, append(opts, ot.StartTime(startTime)))
+}
+
+// FinishAt finishes span as of finishTime rather than now.
+func FinishAt(span ot.Span, finishTime time.Time) {
+	span.FinishWithOptions(ot.FinishOptions{FinishTime: finishTime})
+}
+
+// TagBlockingTime adds d to the duration tagged as blocked.<label> on the span
+// active in ctx, e.g. to break the time spent in an operation down into time
+// blocked on I/O and on locks without starting a span for each wait. Repeated
+// calls for the same label sum their durations, and the total is tagged once
+// when the span is reported by the tracer installed by Configure. It does
+// nothing if ctx carries no sampled span started by a jaeger tracer.
+func TagBlockingTime(ctx context.Context, label string, d time.Duration) {
+	span, ok := ot.SpanFromContext(ctx).(*jaeger.Span)
+	if !ok || !span.SpanContext().IsSampled() {
+		return
+	}
+	if atomic.LoadInt32(&blockingTimesUsed) == 0 {
+		atomic.StoreInt32(&blockingTimesUsed, 1)
+	}
+	sc := span.SpanContext()
+	b := sc.ExtendedSamplingState(blockingTraceKey{}, newBlockingTrace).(*blockingTrace)
+	b.mu.Lock()
+	totals := b.totals[sc.SpanID()]
+	if totals == nil {
+		totals = make(map[string]time.Duration)
+		b.totals[sc.SpanID()] = totals
+	}
+	totals[label] += d
+	b.mu.Unlock()
+}
+
+// blockingTagPrefix prefixes the tags set by TagBlockingTime.
+const blockingTagPrefix = "blocked."
+
+// blockingTrace holds the durations given to TagBlockingTime for the spans of
+// a trace, by span and label. It is kept in the extended sampling state the
+// spans of the trace share in this process, so that it goes away with them
+// whether or not blockingTimeReporter ever sees them.
+type blockingTrace struct {
+	mu     sync.Mutex
+	totals map[jaeger.SpanID]map[string]time.Duration
+}
+
+type blockingTraceKey struct{}
+
+func newBlockingTrace() interface{} {
+	return &blockingTrace{totals: make(map[jaeger.SpanID]map[string]time.Duration)}
+}
+
+// blockingTimesUsed is set by the first call to TagBlockingTime, so that
+// blockingTimeReporter doesn't look up durations of spans until then.
+var blockingTimesUsed int32
+
+// StartSpanWithTraceID starts a root span named operation in the trace whose
+// ID is given in hex, e.g. one found in a log line, rather than in a new
+// trace. It is meant for replay and test tooling stitching spans to traces
+// discovered after the fact.
+//
+// An error is returned if traceIDHex is not a valid trace ID or the tracer
+// recording spans is not a jaeger one. When tracing is not Enabled, a no-op
+// span is returned.
+func StartSpanWithTraceID(operation, traceIDHex string) (ot.Span, error) {
+	traceID, err := jaeger.TraceIDFromString(traceIDHex)
+	if err != nil || !traceID.IsValid() {
+		return nil, fmt.Errorf("invalid trace ID %q", traceIDHex)
+	}
+	tracer := tracerFor(context.Background())
+	if tracer == nil {
+		return ot.NoopTracer{}.StartSpan(operation), nil
+	}
+	if _, ok := tracer.(*jaeger.Tracer); !ok {
+		return nil, fmt.Errorf("can't start a span in trace %s with a %T", traceIDHex, tracer)
+	}
+	var spanID uint64
+	for spanID == 0 {
+		if err := binary.Read(rand.Reader, binary.BigEndian, &spanID); err != nil {
+			return nil, fmt.Errorf("could not generate a span ID: %v", err)
+		}
+	}
+	sc := jaeger.NewSpanContext(traceID, jaeger.SpanID(spanID), 0, false, nil)
+	return tracer.StartSpan(operation, jaeger.SelfRef(sc)), nil
+}
+
+// WithSpan runs fn with a context carrying a new span named operation,
+// finishing the span when fn returns. If fn returns an error, the span is
+// tagged as failed and the error is logged to it.
+func WithSpan(ctx context.Context, operation string, fn func(context.Context) error, opts ...ot.StartSpanOption) error {
+	if tracerFor(ctx) == nil {
+		return fn(ctx)
+	}
+	span, ctx := startSpan(ctx, operation, 1, opts)
+	defer span.Finish()
+
+	err := fn(ctx)
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(otlog.Error(err))
+	}
+	return err
+}
+
+// GoWithSpan runs fn in a new goroutine with a context carrying a new span
+// named operation, which follows from the span active in ctx, if any. The
+// span is finished when fn returns. A panic in fn is recovered and recorded on
+// the span rather than crashing the process.
+func GoWithSpan(ctx context.Context, operation string, fn func(context.Context)) {
+	var span ot.Span
+	if tracer := tracerFor(ctx); tracer == nil {
+		span = ot.NoopTracer{}.StartSpan(operation)
+	} else {
+		var opts []ot.StartSpanOption
+		if parent := ot.SpanFromContext(ctx); parent != nil {
+			opts = append(opts, ot.FollowsFrom(parent.Context()))
+		}
+		ctx, opts = applyForcedSampling(ctx, opts)
+		span = tracer.StartSpan(operation, opts...)
+		tagCaller(span, 0)
+	}
+	ctx = ot.ContextWithSpan(ctx, span)
+	if currentOptions().TagDeadlines {
+		TagDeadline(ctx)
+	}
+
+	go func() {
+		defer span.Finish()
+		defer func() {
+			if r := recover(); r != nil {
+				glog.Errorf("Recovered from panic in %s: %v", operation, r)
+				ext.Error.Set(span, true)
+				span.LogFields(otlog.String("event", "panic"), otlog.Object("panic", r))
+			}
+		}()
+		fn(ctx)
+	}()
+}
+
+// DetachContext returns a context carrying the same values, and so the same
+// active span, as ctx but none of its cancellation or deadline. It is meant
+// for work which outlives the request that started it but should still be
+// part of its trace.
+//
+// The caller is responsible for finishing any span started from the returned
+// context.
+func DetachContext(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// WithTags returns a StartSpanOption which sets all of tags on a span when it
+// is started, rather than through separate SetTag calls afterwards.
+func WithTags(tags map[string]interface{}) ot.StartSpanOption {
+	return ot.Tags(tags)
+}
+
+// SpanFromContext returns the span active in ctx, without starting one, and
+// whether there is one. It returns false if ctx carries no span.
+func SpanFromContext(ctx context.Context) (ot.Span, bool) {
+	span := ot.SpanFromContext(ctx)
+	return span, span != nil
+}
+
+// SetBaggage sets a baggage item on the span active in ctx. Baggage is
+// propagated to every descendant of that span, including remote ones.
+//
+// It does nothing if ctx carries no span.
+func SetBaggage(ctx context.Context, key, value string) {
+	if span := ot.SpanFromContext(ctx); span != nil {
+		span.SetBaggageItem(key, value)
+	}
+}
+
+// GetBaggage returns the value of the baggage item key on the span active in
+// ctx, or an empty string if ctx carries no span or the item is not set.
+func GetBaggage(ctx context.Context, key string) string {
+	if span := ot.SpanFromContext(ctx); span != nil {
+		return span.BaggageItem(key)
+	}
+	return ""
+}
+
+// LogEvent records a log on the span active in ctx with an "event" field set
+// to event, followed by fields in key order.
+//
+// It does nothing if ctx carries no span.
+func LogEvent(ctx context.Context, event string, fields map[string]interface{}) {
+	span := ot.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kv := make([]interface{}, 0, 2+2*len(keys))
+	kv = append(kv, "event", event)
+	for _, k := range keys {
+		kv = append(kv, k, fields[k])
+	}
+	span.LogKV(kv...)
+}
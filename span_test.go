@@ -0,0 +1,510 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestTagBlockingTime(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(true), wrapReporter("svc", &Options{}, reporter))
+	defer closer.Close()
+	span := tracer.StartSpan("op")
+	ctx := ot.ContextWithSpan(context.Background(), span)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			TagBlockingTime(ctx, "io", time.Millisecond)
+		}()
+	}
+	wg.Wait()
+	TagBlockingTime(ctx, "lock", time.Second)
+	span.Finish()
+
+	reported := reporter.GetSpans()[0].(*jaeger.Span)
+	count := make(map[string]int)
+	for _, tag := range jaeger.BuildJaegerThrift(reported).Tags {
+		count[tag.Key]++
+	}
+	for key, want := range map[string]time.Duration{"blocked.io": 10 * time.Millisecond, "blocked.lock": time.Second} {
+		if got := reported.Tags()[key]; got != want || count[key] != 1 {
+			t.Errorf("got %d %s tags, the last being %v, want a single %v", count[key], key, got, want)
+		}
+	}
+}
+
+func TestTagBlockingTimePerSpan(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(true), wrapReporter("svc", &Options{}, reporter))
+	defer closer.Close()
+	parent := tracer.StartSpan("parent")
+	child := tracer.StartSpan("child", ot.ChildOf(parent.Context()))
+
+	TagBlockingTime(ot.ContextWithSpan(context.Background(), parent), "io", time.Second)
+	TagBlockingTime(ot.ContextWithSpan(context.Background(), child), "io", time.Millisecond)
+	child.Finish()
+	parent.Finish()
+
+	for _, s := range reporter.GetSpans() {
+		span := s.(*jaeger.Span)
+		want := time.Second
+		if span.OperationName() == "child" {
+			want = time.Millisecond
+		}
+		if got := span.Tags()["blocked.io"]; got != want {
+			t.Errorf("%s: got blocked.io %v, want %v", span.OperationName(), got, want)
+		}
+	}
+}
+
+func TestTagDeadlines(t *testing.T) {
+	defer configureRecording(t, &Options{TagDeadlines: true}).Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	span, _ := StartSpan(ctx, "with deadline")
+	span.Finish()
+	none, _ := StartSpan(context.Background(), "without deadline")
+	none.Finish()
+
+	for _, recorded := range waitForRecentSpans(t, 2) {
+		remaining, ok := recorded.Tags[deadlineRemainingTag].(int64)
+		if recorded.Operation == "without deadline" {
+			if ok {
+				t.Errorf("got %s tag %d without a deadline", deadlineRemainingTag, remaining)
+			}
+			continue
+		}
+		if !ok || remaining > 60000 || remaining < 59000 {
+			t.Errorf("got %s tag %v, want about a minute", deadlineRemainingTag, recorded.Tags[deadlineRemainingTag])
+		}
+	}
+
+	// negative once the deadline passed
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	span, _ = StartSpan(ctx, "late")
+	if remaining := span.(*jaeger.Span).Tags()[deadlineRemainingTag].(int64); remaining > -1000 {
+		t.Errorf("got %s tag %d past the deadline", deadlineRemainingTag, remaining)
+	}
+	span.Finish()
+}
+
+func TestTagCallerInfo(t *testing.T) {
+	tracer := configureCollector(t, &Options{TagCallerInfo: true})
+	_, file, line, _ := runtime.Caller(0)
+	span, _ := StartSpan(context.Background(), "start")
+	span.Finish()
+	wantLines := map[string]int{"start": line + 1}
+
+	_, _, line, _ = runtime.Caller(0)
+	WithSpan(context.Background(), "with", func(context.Context) error { return nil })
+	wantLines["with"] = line + 1
+
+	done := make(chan struct{})
+	_, _, line, _ = runtime.Caller(0)
+	GoWithSpan(context.Background(), "go", func(context.Context) { close(done) })
+	wantLines["go"] = line + 1
+	<-done
+
+	for _, recorded := range tracer.waitForSpans(t, len(wantLines)) {
+		if got := recorded.Tags[codeFilepathTag]; got != file {
+			t.Errorf("%s: got %s %v, want %s", recorded.Operation, codeFilepathTag, got, file)
+		}
+		if got, want := recorded.Tags[codeLinenoTag], int64(wantLines[recorded.Operation]); got != want {
+			t.Errorf("%s: got %s %v, want %d", recorded.Operation, codeLinenoTag, got, want)
+		}
+	}
+	tracer.Close()
+
+	for _, options := range []*Options{{}, {TagCallerInfo: true, SamplerType: "const", SamplerParam: 0}} {
+		tracer := configureCollector(t, options)
+		span, _ := StartSpan(context.Background(), "start")
+		if tags := span.(*jaeger.Span).Tags(); tags[codeFilepathTag] != nil {
+			t.Errorf("%+v: got tags %v", options, tags)
+		}
+		span.Finish()
+		tracer.Close()
+	}
+}
+
+func TestSpanFromContext(t *testing.T) {
+	if span, ok := SpanFromContext(context.Background()); ok || span != nil {
+		t.Errorf("got span %v from an empty context", span)
+	}
+	want := ot.NoopTracer{}.StartSpan("op")
+	if span, ok := SpanFromContext(ot.ContextWithSpan(context.Background(), want)); !ok || span != want {
+		t.Errorf("got span %v, %t, want %v", span, ok, want)
+	}
+}
+
+func TestBaggage(t *testing.T) {
+	defer configureCollector(t, &Options{}).Close()
+
+	parent, ctx := ot.StartSpanFromContext(context.Background(), "parent")
+	defer parent.Finish()
+	SetBaggage(ctx, "tenant", "acme")
+	child, childCtx := ot.StartSpanFromContext(ctx, "child")
+	defer child.Finish()
+
+	if got := GetBaggage(childCtx, "tenant"); got != "acme" {
+		t.Errorf("got baggage %q in the child context, want acme", got)
+	}
+	if got := GetBaggage(childCtx, "missing"); got != "" {
+		t.Errorf("got baggage %q for an unset item", got)
+	}
+
+	// no-ops without a span
+	SetBaggage(context.Background(), "tenant", "acme")
+	if got := GetBaggage(context.Background(), "tenant"); got != "" {
+		t.Errorf("got baggage %q without a span", got)
+	}
+}
+
+func TestWithTags(t *testing.T) {
+	tracer := configureCollector(t, &Options{})
+	defer tracer.Close()
+
+	tags := map[string]interface{}{"component": "db", "db.rows": int64(3)}
+	span, _ := StartSpan(context.Background(), "started", WithTags(tags))
+	span.Finish()
+	WithSpan(context.Background(), "with", func(context.Context) error { return nil }, WithTags(tags))
+
+	spans := tracer.spans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	for _, span := range spans {
+		for k, v := range tags {
+			if span.Tags[k] != v {
+				t.Errorf("span %q has tag %s=%v, want %v", span.Operation, k, span.Tags[k], v)
+			}
+		}
+	}
+}
+
+func TestGoWithSpan(t *testing.T) {
+	tracer := configureCollector(t, &Options{})
+	defer tracer.Close()
+
+	parent, ctx := StartSpan(context.Background(), "parent")
+	refs := make(chan []ot.SpanReference, 1)
+	GoWithSpan(ctx, "async", func(ctx context.Context) {
+		refs <- ot.SpanFromContext(ctx).(*jaeger.Span).References()
+	})
+	got := <-refs
+	parent.Finish()
+
+	want := parent.Context().(jaeger.SpanContext)
+	if len(got) != 1 || got[0].Type != ot.FollowsFromRef ||
+		got[0].ReferencedContext.(jaeger.SpanContext).SpanID() != want.SpanID() {
+		t.Errorf("got references %+v, want following from %v", got, want)
+	}
+	for _, span := range tracer.waitForSpans(t, 2) {
+		if span.Operation == "async" && span.ParentID != want.SpanID().String() {
+			t.Errorf("async span has parent %q, want %q", span.ParentID, want.SpanID())
+		}
+	}
+}
+
+func TestGoWithSpanRecoversPanic(t *testing.T) {
+	tracer := configureCollector(t, &Options{})
+	defer tracer.Close()
+
+	GoWithSpan(context.Background(), "async", func(ctx context.Context) {
+		panic("boom")
+	})
+	if span := tracer.waitForSpans(t, 1)[0]; span.Tags["error"] != true {
+		t.Errorf("panic not tagged on the span: %v", span.Tags)
+	}
+}
+
+func TestDetachContext(t *testing.T) {
+	defer configureCollector(t, &Options{}).Close()
+
+	span, ctx := StartSpan(context.Background(), "request")
+	defer span.Finish()
+	ctx, cancel := context.WithTimeout(ctx, time.Hour)
+	cancel()
+
+	detached := DetachContext(ctx)
+	if detached.Err() != nil || detached.Done() != nil {
+		t.Error("detached context is cancelled with its parent")
+	}
+	if _, ok := detached.Deadline(); ok {
+		t.Error("detached context has the deadline of its parent")
+	}
+	if got := ot.SpanFromContext(detached); got != span {
+		t.Errorf("got span %v, want the span of the parent", got)
+	}
+}
+
+func TestLogEvent(t *testing.T) {
+	defer configureCollector(t, &Options{}).Close()
+
+	LogEvent(context.Background(), "ignored", nil) // no span, no-op
+	span, ctx := StartSpan(context.Background(), "op")
+	LogEvent(ctx, "cache-miss", map[string]interface{}{"key": "user:42", "attempt": 2})
+	span.Finish()
+
+	logs := span.(*jaeger.Span).Logs()
+	if len(logs) != 1 {
+		t.Fatalf("got %d logs, want 1", len(logs))
+	}
+	got := map[string]interface{}{}
+	for _, f := range logs[0].Fields {
+		got[f.Key()] = f.Value()
+	}
+	want := map[string]interface{}{"event": "cache-miss", "key": "user:42", "attempt": 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got log fields %v, want %v", got, want)
+	}
+	if logs[0].Timestamp.IsZero() {
+		t.Error("log isn't timestamped")
+	}
+}
+
+func noopWork(ctx context.Context) error { return nil }
+
+func TestDisabledHelpersDoNotAllocate(t *testing.T) {
+	ot.SetGlobalTracer(ot.NoopTracer{})
+	ctx := context.Background()
+	for name, f := range map[string]func(){
+		"Enabled":   func() { Enabled() },
+		"StartSpan": func() { StartSpan(ctx, "op") },
+		"WithSpan":  func() { WithSpan(ctx, "op", noopWork) },
+	} {
+		if allocs := testing.AllocsPerRun(100, f); allocs != 0 {
+			t.Errorf("%s allocates %v times per call with tracing disabled", name, allocs)
+		}
+	}
+}
+
+func BenchmarkEnabled(b *testing.B) {
+	ot.SetGlobalTracer(ot.NoopTracer{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Enabled()
+	}
+}
+
+func BenchmarkStartSpanDisabled(b *testing.B) {
+	ot.SetGlobalTracer(ot.NoopTracer{})
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		span, _ := StartSpan(ctx, "op")
+		span.Finish()
+	}
+}
+
+func BenchmarkWithSpanDisabled(b *testing.B) {
+	ot.SetGlobalTracer(ot.NoopTracer{})
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		WithSpan(ctx, "op", noopWork)
+	}
+}
+
+func BenchmarkWithSpanEnabled(b *testing.B) {
+	tracer, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	ot.SetGlobalTracer(tracer)
+	defer ot.SetGlobalTracer(ot.NoopTracer{})
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		WithSpan(ctx, "op", noopWork)
+	}
+}
+
+func TestWithTracer(t *testing.T) {
+	tracer := configureCollector(t, &Options{})
+	defer tracer.Close()
+	tenant, closer := jaeger.NewTracer("tenant", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+
+	ctx := WithTracer(context.Background(), tenant)
+	span, _ := StartSpan(ctx, "op")
+	span.Finish()
+	if got := span.(*jaeger.Span).Tracer(); got != tenant {
+		t.Errorf("span started by %v, want the tracer of the context", got)
+	}
+	if n := len(tracer.spans()); n != 0 {
+		t.Errorf("got %d spans recorded by the global tracer, want none", n)
+	}
+
+	// the tracer of the context is preferred even when tracing is disabled
+	ot.SetGlobalTracer(ot.NoopTracer{})
+	span, _ = StartSpan(ctx, "op")
+	defer span.Finish()
+	if !isJaegerSpan(span) {
+		t.Error("span not recorded by the tracer of the context")
+	}
+}
+
+func TestStartSpanWithTraceID(t *testing.T) {
+	tracer := configureCollector(t, &Options{})
+	defer tracer.Close()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	span, err := StartSpanWithTraceID("replay", traceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	span.Finish()
+	sc := span.Context().(jaeger.SpanContext)
+	if got := fmt.Sprintf("%016x%016x", sc.TraceID().High, sc.TraceID().Low); got != traceID {
+		t.Errorf("got trace ID %s, want %s", got, traceID)
+	}
+	if sc.SpanID() == 0 || sc.ParentID() != 0 {
+		t.Errorf("got span ID %v and parent %v, want a root span", sc.SpanID(), sc.ParentID())
+	}
+	if got := tracer.onlySpan(t).TraceID; got != sc.TraceID().String() {
+		t.Errorf("got trace ID %s reported", got)
+	}
+
+	for _, invalid := range []string{"", "not-hex", "0"} {
+		if _, err := StartSpanWithTraceID("replay", invalid); err == nil {
+			t.Errorf("started a span in trace %q", invalid)
+		}
+	}
+}
+
+func TestStartSpanAtFinishAt(t *testing.T) {
+	tracer := configureCollector(t, &Options{})
+	defer tracer.Close()
+
+	start := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	span, _ := StartSpanAt(context.Background(), "replayed", start)
+	FinishAt(span, start.Add(42*time.Second))
+
+	recorded := tracer.onlySpan(t)
+	if !recorded.Start.Equal(start) {
+		t.Errorf("got start %v, want %v", recorded.Start, start)
+	}
+	if recorded.Duration != 42*time.Second {
+		t.Errorf("got duration %v, want 42s", recorded.Duration)
+	}
+}
+
+func TestStartSpanIfSampled(t *testing.T) {
+	for _, sampled := range []bool{true, false} {
+		tracer, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(sampled), jaeger.NewNullReporter())
+		parent := tracer.StartSpan("parent")
+		ctx := ot.ContextWithSpan(context.Background(), parent)
+		ot.SetGlobalTracer(tracer)
+
+		span, childCtx, started := StartSpanIfSampled(ctx, "child")
+		if started != sampled || isJaegerSpan(span) != sampled {
+			t.Errorf("sampled=%t: got span %v started %t", sampled, span, started)
+		}
+		if !sampled && childCtx != ctx {
+			t.Errorf("sampled=%t: got a new context", sampled)
+		}
+		span.Finish()
+		parent.Finish()
+		ot.SetGlobalTracer(ot.NoopTracer{})
+		closer.Close()
+	}
+
+	if _, _, started := StartSpanIfSampled(context.Background(), "root"); started {
+		t.Error("started a trace from a context carrying no span")
+	}
+}
+
+func TestForceSampleContext(t *testing.T) {
+	for _, tt := range []struct {
+		base, force bool
+	}{
+		{false, true},
+		{true, false},
+	} {
+		param := 0.0
+		if tt.base {
+			param = 1
+		}
+		closer := configureCollector(t, &Options{SamplerType: "const", SamplerParam: param})
+		span, ctx := StartSpan(ForceSampleContext(context.Background(), tt.force), "forced")
+		if got := IsSampled(ctx); got != tt.force {
+			t.Errorf("base=%t force=%t: got sampled=%t", tt.base, tt.force, got)
+		}
+		if ctx.Value(forceSampleKey{}) != nil {
+			t.Errorf("base=%t force=%t: returned context still forces the decision", tt.base, tt.force)
+		}
+		child, childCtx := StartSpan(ctx, "child")
+		if got := IsSampled(childCtx); got != tt.force {
+			t.Errorf("base=%t force=%t: got child sampled=%t", tt.base, tt.force, got)
+		}
+		child.Finish()
+		span.Finish()
+
+		other, otherCtx := StartSpan(context.Background(), "other")
+		if got := IsSampled(otherCtx); got != tt.base {
+			t.Errorf("base=%t force=%t: got unrelated span sampled=%t", tt.base, tt.force, got)
+		}
+		other.Finish()
+		closer.Close()
+	}
+}
+
+// unsampledContext returns a context carrying an unsampled span, with the
+// tracer which started it installed as the global tracer.
+func unsampledContext() (context.Context, func()) {
+	tracer, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(false), jaeger.NewNullReporter())
+	ot.SetGlobalTracer(tracer)
+	parent := tracer.StartSpan("parent")
+	return ot.ContextWithSpan(context.Background(), parent), func() {
+		parent.Finish()
+		ot.SetGlobalTracer(ot.NoopTracer{})
+		closer.Close()
+	}
+}
+
+func BenchmarkStartSpanIfSampledUnsampled(b *testing.B) {
+	ctx, done := unsampledContext()
+	defer done()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		span, _, _ := StartSpanIfSampled(ctx, "child")
+		span.Finish()
+	}
+}
+
+func BenchmarkStartSpanUnsampled(b *testing.B) {
+	ctx, done := unsampledContext()
+	defer done()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		span, _ := StartSpan(ctx, "child")
+		span.Finish()
+	}
+}
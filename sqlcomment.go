@@ -0,0 +1,42 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// SQLComment returns a sqlcommenter comment carrying the W3C traceparent of
+// the span active in ctx, e.g. /*traceparent='00-...-...-01'*/, for appending
+// to SQL statements so that databases can correlate queries with traces.
+//
+// It returns an empty string if ctx carries no span of a jaeger tracer or the
+// span is not sampled, as there is no trace to correlate with.
+func SQLComment(ctx context.Context) string {
+	span := ot.SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	sc, ok := span.Context().(jaeger.SpanContext)
+	if !ok || !sc.IsValid() || !sc.IsSampled() {
+		return ""
+	}
+	return fmt.Sprintf("/*%s='%s'*/", traceparentHeader, url.QueryEscape(formatTraceparent(sc)))
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+var sqlComment = regexp.MustCompile(`^/\*traceparent='00-([0-9a-f]{32})-([0-9a-f]{16})-01'\*/$`)
+
+func TestSQLComment(t *testing.T) {
+	defer configureCollector(t, &Options{}).Close()
+
+	if got := SQLComment(context.Background()); got != "" {
+		t.Errorf("got %q without a span, want none", got)
+	}
+
+	span, ctx := StartSpan(context.Background(), "query")
+	defer span.Finish()
+	sc := span.Context().(jaeger.SpanContext)
+	comment := SQLComment(ctx)
+	m := sqlComment.FindStringSubmatch(comment)
+	if m == nil {
+		t.Fatalf("got invalid comment %q", comment)
+	}
+	if want := fmt.Sprintf("%016x%016x", sc.TraceID().High, sc.TraceID().Low); m[1] != want {
+		t.Errorf("got trace ID %s, want %s", m[1], want)
+	}
+	if want := fmt.Sprintf("%016x", uint64(sc.SpanID())); m[2] != want {
+		t.Errorf("got span ID %s, want %s", m[2], want)
+	}
+}
+
+func TestSQLCommentUnsampled(t *testing.T) {
+	defer configureCollector(t, &Options{SamplerType: "const", SamplerParam: 0}).Close()
+
+	span, ctx := StartSpan(context.Background(), "query")
+	defer span.Finish()
+	if got := SQLComment(ctx); got != "" {
+		t.Errorf("got %q for an unsampled span, want none", got)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+)
+
+// collectorStats records the health of the reporter sending spans to a
+// collector.
+type collectorStats struct {
+	queueLength int64
+	dropped     int64
+	failed      int64
+
+	mu        sync.Mutex
+	lastError string
+}
+
+func (s *collectorStats) setLastError(msg string) {
+	s.mu.Lock()
+	s.lastError = msg
+	s.mu.Unlock()
+}
+
+func (s *collectorStats) getLastError() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastError
+}
+
+// statsLogger is the logger of the remote reporter, which reports its failures
+// to send spans only through its logger.
+type statsLogger struct {
+	stats *collectorStats
+}
+
+// Error implements the Error() method of log.Logger.
+func (l statsLogger) Error(msg string) {
+	l.stats.setLastError(msg)
+	glog.Error(msg)
+}
+
+// Infof implements the Infof() method of log.Logger.
+func (statsLogger) Infof(msg string, args ...interface{}) {
+	glog.Infof(msg, args...)
+}
+
+// Status describes the tracer installed by Configure.
+type Status struct {
+	Enabled         bool     `json:"enabled"`
+	Backends        []string `json:"backends"`
+	Sampler         string   `json:"sampler,omitempty"`
+	QueueLength     int64    `json:"queue_length"`
+	DroppedSpans    int64    `json:"dropped_spans"`
+	FailedSpans     int64    `json:"failed_spans"`
+	LastReportError string   `json:"last_report_error,omitempty"`
+}
+
+// backends lists the destinations spans are reported to.
+func (o *Options) backends() []string {
+	backends := []string{}
+	if o.ZipkinURL != "" {
+		backends = append(backends, "zipkin")
+	}
+	if o.JaegerURL != "" {
+		backends = append(backends, "jaeger")
+	}
+	if o.DatadogAgentURL != "" {
+		backends = append(backends, "datadog")
+	}
+	if o.LogTraceSpans {
+		backends = append(backends, "log")
+	}
+	if o.ConsoleExporter {
+		backends = append(backends, "console")
+	}
+	if o.OpenCensusExporter != nil {
+		backends = append(backends, "opencensus")
+	}
+	if o.RecentSpanBufferSize > 0 {
+		backends = append(backends, "recent")
+	}
+	if o.OTLPFile != "" {
+		backends = append(backends, "otlp-file")
+	}
+	if o.OTLPEndpoint != "" {
+		backends = append(backends, "otlp")
+	}
+	return backends
+}
+
+// describeSampler returns a human readable description of a sampler.
+func describeSampler(s interface{}) string {
+	if stringer, ok := s.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%T", s)
+}
+
+// currentStatus returns the status of the tracer installed by Configure.
+func currentStatus() Status {
+	activeMu.Lock()
+	h := active
+	activeMu.Unlock()
+
+	if h == nil {
+		return Status{Backends: []string{}}
+	}
+	status := Status{
+		Enabled:  true,
+		Backends: h.backends,
+		Sampler:  h.samplerDescription,
+	}
+	if h.stats != nil {
+		status.QueueLength = atomic.LoadInt64(&h.stats.queueLength)
+		status.DroppedSpans = atomic.LoadInt64(&h.stats.dropped)
+		status.FailedSpans = atomic.LoadInt64(&h.stats.failed)
+		status.LastReportError = h.stats.getLastError()
+	}
+	return status
+}
+
+// StatusHandler returns a handler serving the Status of the tracer installed
+// by Configure as JSON, e.g. for mounting at /debug/tracing. Every request
+// reflects the tracer installed at that time.
+func StatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(currentStatus()); err != nil {
+			glog.Errorf("Could not write tracing status: %v", err)
+		}
+	}
+}
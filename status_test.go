@@ -0,0 +1,59 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func getStatus(t *testing.T) Status {
+	t.Helper()
+	w := httptest.NewRecorder()
+	StatusHandler()(w, httptest.NewRequest("GET", "/debug/tracing", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got content type %q", ct)
+	}
+	var status Status
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("invalid status %q: %v", w.Body.String(), err)
+	}
+	return status
+}
+
+func TestStatusHandler(t *testing.T) {
+	options := &Options{}
+	recordCollector(options)
+	closer := configureRecording(t, options)
+
+	status := getStatus(t)
+	if !status.Enabled {
+		t.Error("tracing reported disabled")
+	}
+	if want := []string{"jaeger", "recent"}; !reflect.DeepEqual(status.Backends, want) {
+		t.Errorf("got backends %v, want %v", status.Backends, want)
+	}
+	if !strings.Contains(status.Sampler, "ConstSampler") {
+		t.Errorf("got sampler %q, want the const sampler", status.Sampler)
+	}
+
+	closer.Close()
+	if status := getStatus(t); status.Enabled || len(status.Backends) != 0 {
+		t.Errorf("got %+v once closed", status)
+	}
+}
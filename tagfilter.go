@@ -0,0 +1,201 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// tagFilterReporter strips the tags of every span which are not in
+// Options.TagAllowlist, and passes the others through Options.TagRedactor,
+// before it is reported. The error tag and the tags recording the sampling
+// decision are always kept as is.
+//
+// As jaeger spans can't have tags removed, spans with tags to strip or redact
+// are rebuilt with the same identity, timing, references and logs by a tracer
+// of its own, which reports them to the wrapped reporter.
+type tagFilterReporter struct {
+	jaeger.Reporter
+	allowed map[string]bool
+	redact  func(key, value string) (string, bool)
+
+	tracer ot.Tracer
+	closer io.Closer
+}
+
+func newTagFilterReporter(serviceName string, options *Options, rep jaeger.Reporter) *tagFilterReporter {
+	var allowed map[string]bool
+	if len(options.TagAllowlist) > 0 {
+		allowed = make(map[string]bool, len(options.TagAllowlist))
+		for _, key := range options.TagAllowlist {
+			allowed[key] = true
+		}
+	}
+	opts := append([]jaeger.TracerOption{poolSpans}, processTags(options)...)
+	tracer, closer := jaeger.NewTracer(serviceName, keepSampler{}, unclosedReporter{rep}, opts...)
+	return &tagFilterReporter{
+		Reporter: rep,
+		allowed:  allowed,
+		redact:   options.TagRedactor,
+		tracer:   tracer,
+		closer:   closer,
+	}
+}
+
+// reserved returns whether the tag key is always forwarded as is.
+func reserved(key string) bool {
+	return key == string(ext.Error) ||
+		key == string(ext.SamplingPriority) ||
+		key == priorityTag ||
+		strings.HasPrefix(key, samplerTagPrefix) ||
+		strings.HasPrefix(key, "sampling.")
+}
+
+// filter returns the tags forwarded in place of tags, and whether they
+// differ.
+func (r *tagFilterReporter) filter(tags map[string]interface{}) (ot.Tags, bool) {
+	kept := make(ot.Tags, len(tags))
+	changed := false
+	for k, v := range tags {
+		switch {
+		case reserved(k):
+			kept[k] = v
+		case r.allowed != nil && !r.allowed[k]:
+			changed = true
+		case r.redact != nil:
+			value := fmt.Sprint(v)
+			redacted, ok := r.redact(k, value)
+			if !ok {
+				changed = true
+			} else if redacted != value {
+				kept[k] = redacted
+				changed = true
+			} else {
+				kept[k] = v
+			}
+		default:
+			kept[k] = v
+		}
+	}
+	return kept, changed
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r *tagFilterReporter) Report(span *jaeger.Span) {
+	kept, changed := r.filter(span.Tags())
+	if !changed {
+		r.Reporter.Report(span)
+		return
+	}
+
+	// a fresh span context, so that the sampling state shared by the spans of
+	// the trace is left alone
+	sc := span.SpanContext()
+	var baggage map[string]string
+	sc.ForeachBaggageItem(func(k, v string) bool {
+		if baggage == nil {
+			baggage = make(map[string]string)
+		}
+		baggage[k] = v
+		return true
+	})
+	sc = jaeger.NewSpanContext(sc.TraceID(), sc.SpanID(), sc.ParentID(), true, baggage)
+
+	opts := []ot.StartSpanOption{
+		jaeger.SelfRef(sc),
+		ot.StartTime(span.StartTime()),
+		kept,
+	}
+	for _, ref := range span.References() {
+		opts = append(opts, ref)
+	}
+	rebuilt := r.tracer.StartSpan(span.OperationName(), opts...)
+	rebuilt.FinishWithOptions(ot.FinishOptions{
+		FinishTime: span.StartTime().Add(span.Duration()),
+		LogRecords: span.Logs(),
+	})
+}
+
+// Close implements the Close() method of jaeger.Reporter.
+func (r *tagFilterReporter) Close() {
+	r.closer.Close()
+	r.Reporter.Close()
+}
+
+// keepSampler samples every span without tagging it, for rebuilding spans
+// which were already sampled.
+type keepSampler struct {
+	jaeger.SamplerV2Base
+}
+
+// OnCreateSpan implements the OnCreateSpan() method of jaeger.SamplerV2.
+func (keepSampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return jaeger.SamplingDecision{Sample: true}
+}
+
+// OnSetOperationName implements the OnSetOperationName() method of
+// jaeger.SamplerV2.
+func (keepSampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	return jaeger.SamplingDecision{Sample: true}
+}
+
+// OnSetTag implements the OnSetTag() method of jaeger.SamplerV2.
+func (keepSampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	return jaeger.SamplingDecision{Sample: true}
+}
+
+// OnFinishSpan implements the OnFinishSpan() method of jaeger.SamplerV2.
+func (keepSampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return jaeger.SamplingDecision{Sample: true}
+}
+
+// Close implements the Close() method of jaeger.SamplerV2.
+func (keepSampler) Close() {}
+
+// unclosedReporter shields the wrapped reporter from being closed, for
+// reporters shared with another owner.
+type unclosedReporter struct {
+	jaeger.Reporter
+}
+
+// Close implements the Close() method of jaeger.Reporter.
+func (unclosedReporter) Close() {}
+
+// NewTagRedactor returns an Options.TagRedactor masking the values of the
+// tags whose keys are in denylist and, unless allowlist is empty, of those
+// whose keys are not in allowlist. Masked tags are kept, so the backend
+// still shows that a value was set.
+func NewTagRedactor(allowlist, denylist []string) func(key, value string) (string, bool) {
+	allowed := make(map[string]bool, len(allowlist))
+	for _, key := range allowlist {
+		allowed[key] = true
+	}
+	denied := make(map[string]bool, len(denylist))
+	for _, key := range denylist {
+		denied[key] = true
+	}
+	return func(key, value string) (string, bool) {
+		if denied[key] || (len(allowed) > 0 && !allowed[key]) {
+			return redactedValue, true
+		}
+		return value, true
+	}
+}
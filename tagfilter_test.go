@@ -0,0 +1,101 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestTagRedactor(t *testing.T) {
+	var seen []string
+	options := &Options{
+		TagRedactor: func(key, value string) (string, bool) {
+			seen = append(seen, key)
+			switch {
+			case key == "user.email":
+				return "", false
+			case strings.HasPrefix(value, "Bearer "):
+				return "Bearer " + redactedValue, true
+			}
+			return value, true
+		},
+	}
+	collector := recordCollector(options)
+	defer configureRecording(t, options).Close()
+
+	root, ctx := StartSpan(context.Background(), "root")
+	span, ctx := StartSpan(ctx, "login")
+	span.SetTag("user.email", "jane@example.com")
+	span.SetTag("http.authorization", "Bearer abc123")
+	span.SetTag("http.method", "POST")
+	ext.Error.Set(span, true)
+	LogEvent(ctx, "checked", nil)
+	span.Finish()
+	root.Finish()
+	waitForSpans(t, collector, 2)
+
+	want := span.Context().(jaeger.SpanContext)
+	reported := collector.GetSpans()[0].(*jaeger.Span)
+	tags := reported.Tags()
+	if _, ok := tags["user.email"]; ok {
+		t.Errorf("dropped tag was reported: %v", tags)
+	}
+	if got := tags["http.authorization"]; got != "Bearer "+redactedValue {
+		t.Errorf("got http.authorization %v, want it redacted", got)
+	}
+	if tags["http.method"] != "POST" || tags["error"] != true {
+		t.Errorf("got tags %v, want the others kept", tags)
+	}
+	for _, key := range seen {
+		if key == "error" {
+			t.Error("error tag passed to the redactor")
+		}
+	}
+
+	sc := reported.SpanContext()
+	if sc.TraceID() != want.TraceID() || sc.SpanID() != want.SpanID() || sc.ParentID() != want.ParentID() {
+		t.Errorf("got span %v reported, want %v", sc, want)
+	}
+	if reported.OperationName() != "login" || reported.Duration() != span.(*jaeger.Span).Duration() || len(reported.Logs()) != 1 {
+		t.Errorf("got span %s lasting %v with logs %v", reported.OperationName(), reported.Duration(), reported.Logs())
+	}
+	if refs := reported.References(); len(refs) != 1 || refs[0].Type != ot.ChildOfRef {
+		t.Errorf("got references %v, want the parent", refs)
+	}
+}
+
+func TestNewTagRedactor(t *testing.T) {
+	for _, tt := range []struct {
+		allowlist, denylist []string
+		key, want           string
+	}{
+		{nil, []string{"user.email"}, "user.email", redactedValue},
+		{nil, []string{"user.email"}, "http.method", "value"},
+		{[]string{"http.method"}, nil, "http.method", "value"},
+		{[]string{"http.method"}, nil, "user.id", redactedValue},
+		{[]string{"user.email"}, []string{"user.email"}, "user.email", redactedValue},
+	} {
+		got, ok := NewTagRedactor(tt.allowlist, tt.denylist)(tt.key, "value")
+		if !ok || got != tt.want {
+			t.Errorf("allow %v, deny %v: got %s=%q, %t, want %q", tt.allowlist, tt.denylist, tt.key, got, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// defaults of Options.MaxIdleConns and Options.IdleConnTimeout
+const (
+	defaultMaxIdleConns    = 10
+	defaultIdleConnTimeout = 90 * time.Second
+)
+
+// newCollectorRoundTripper returns the http.RoundTripper used to talk to the
+// collector. Unlike http.DefaultTransport, it keeps as many idle connections
+// to the collector as Options.MaxIdleConns allows, since all of its traffic
+// goes to that one host.
+func newCollectorRoundTripper(options *Options) (http.RoundTripper, error) {
+	maxIdleConns := options.MaxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+	idleConnTimeout := options.IdleConnTimeout
+	if idleConnTimeout == 0 {
+		idleConnTimeout = defaultIdleConnTimeout
+	}
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	tlsConfig, err := collectorTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tlsConfig
+
+	return t, nil
+}
+
+// collectorTLSConfig returns the TLS configuration of the connections to the
+// collector, or nil when the options configure no client certificate or CA.
+func collectorTLSConfig(options *Options) (*tls.Config, error) {
+	if options.TLSCertFile == "" && options.TLSCAFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if options.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.TLSCertFile, options.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if options.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(options.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + options.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
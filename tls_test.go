@@ -0,0 +1,45 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCollectorRoundTripperIdleConns(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		options     Options
+		wantConns   int
+		wantTimeout time.Duration
+	}{
+		{"defaults", Options{}, defaultMaxIdleConns, defaultIdleConnTimeout},
+		{"configured", Options{MaxIdleConns: 7, IdleConnTimeout: time.Minute}, 7, time.Minute},
+	} {
+		rt, err := newCollectorRoundTripper(&tt.options)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		tr := rt.(*http.Transport)
+		if tr.MaxIdleConns != tt.wantConns || tr.MaxIdleConnsPerHost != tt.wantConns {
+			t.Errorf("%s: got %d idle conns, %d per host, want %d", tt.name, tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tt.wantConns)
+		}
+		if tr.IdleConnTimeout != tt.wantTimeout {
+			t.Errorf("%s: got idle timeout %v, want %v", tt.name, tr.IdleConnTimeout, tt.wantTimeout)
+		}
+	}
+}
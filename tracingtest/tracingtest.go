@@ -0,0 +1,133 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracingtest provides tracers for tests which assert on the spans
+// produced by instrumented code.
+//
+// Nothing in this package is suitable for production use.
+package tracingtest
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	tracing "github.com/aspenmesh/tracing-go"
+	"github.com/golang/glog"
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// NewTracer returns a tracer which samples every span and records finished
+// spans in the returned reporter.
+//
+// Trace and span IDs are drawn from a random source seeded with seed, so two
+// tracers created with the same seed generate the same sequence of IDs and
+// tests can compare them against golden values. Predictable IDs defeat the
+// purpose of random trace IDs; never use this outside of tests.
+func NewTracer(serviceName string, seed int64) (ot.Tracer, *jaeger.InMemoryReporter, io.Closer) {
+	return newTracer(serviceName, jaeger.TracerOptions.RandomNumber(seededRandomNumber(seed)))
+}
+
+// NewTracerWithClock returns a tracer which samples every span, records
+// finished spans in the returned reporter and takes span start and finish
+// times from clock, so that tests can assert on exact span durations.
+func NewTracerWithClock(serviceName string, clock Clock) (ot.Tracer, *jaeger.InMemoryReporter, io.Closer) {
+	return newTracer(serviceName, jaeger.TracerOptions.TimeNow(clock.Now))
+}
+
+func newTracer(serviceName string, opts ...jaeger.TracerOption) (ot.Tracer, *jaeger.InMemoryReporter, io.Closer) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer(serviceName, jaeger.NewConstSampler(true), reporter, opts...)
+	return tracer, reporter, closer
+}
+
+// ConfigureSync is tracing.Configure, except that spans are sent to the
+// collectors synchronously: every span is flushed to its collector before
+// Finish returns, so that tests asserting that spans reached a mock collector
+// don't race against the reporter's flush interval.
+func ConfigureSync(serviceName string, options *tracing.Options) (io.Closer, error) {
+	if options == nil {
+		return tracing.Configure(serviceName, nil)
+	}
+	syncOptions := *options
+	syncOptions.CollectorReporter = func(trans jaeger.Transport) jaeger.Reporter {
+		return syncReporter{trans}
+	}
+	return tracing.Configure(serviceName, &syncOptions)
+}
+
+// syncReporter sends every span to a collector as soon as it is reported.
+type syncReporter struct {
+	trans jaeger.Transport
+}
+
+// Report implements the Report() method of jaeger.Reporter.
+func (r syncReporter) Report(span *jaeger.Span) {
+	if _, err := r.trans.Append(span); err != nil {
+		glog.Errorf("Could not append span to the collector transport: %v", err)
+		return
+	}
+	if _, err := r.trans.Flush(); err != nil {
+		glog.Errorf("Could not flush span to the collector: %v", err)
+	}
+}
+
+// Close implements the Close() method of jaeger.Reporter.
+func (r syncReporter) Close() {
+	r.trans.Close()
+}
+
+// Clock is a source of the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// FakeClock is a Clock whose time only changes when it is advanced.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now implements the Now() method of Clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// seededRandomNumber returns a goroutine safe generator of uint64s seeded with
+// seed.
+func seededRandomNumber(seed int64) func() uint64 {
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(seed))
+	return func() uint64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return r.Uint64()
+	}
+}
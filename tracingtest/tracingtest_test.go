@@ -0,0 +1,95 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracingtest
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aspenmesh/tracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func firstTraceID(seed int64) jaeger.TraceID {
+	tracer, _, closer := NewTracer("svc", seed)
+	defer closer.Close()
+	return tracer.StartSpan("op").Context().(jaeger.SpanContext).TraceID()
+}
+
+func TestNewTracerSeed(t *testing.T) {
+	first, second := firstTraceID(42), firstTraceID(42)
+	if first != second {
+		t.Errorf("tracers seeded alike generated trace IDs %v and %v", first, second)
+	}
+	if other := firstTraceID(43); other == first {
+		t.Errorf("tracers seeded differently both generated trace ID %v", first)
+	}
+}
+
+func TestNewTracerWithClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	tracer, reporter, closer := NewTracerWithClock("svc", clock)
+	defer closer.Close()
+
+	span := tracer.StartSpan("op")
+	clock.Advance(1500 * time.Millisecond)
+	span.Finish()
+
+	spans := reporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if got := spans[0].(*jaeger.Span).Duration(); got != 1500*time.Millisecond {
+		t.Errorf("got duration %v, want 1.5s", got)
+	}
+}
+
+func TestConfigureSync(t *testing.T) {
+	var mu sync.Mutex
+	var uploads [][]byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		uploads = append(uploads, body)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer collector.Close()
+
+	closer, err := ConfigureSync("svc", &tracing.Options{
+		JaegerURL:    collector.URL,
+		SamplerType:  "const",
+		SamplerParam: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	span, _ := tracing.StartSpan(context.Background(), "sync-op")
+	span.Finish()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(uploads) != 1 || !bytes.Contains(uploads[0], []byte("sync-op")) {
+		t.Errorf("got uploads %q right after Finish, want the span", uploads)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/golang/glog"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+)
+
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+// NewTransport returns an http.RoundTripper which continues the trace found in
+// each outbound request's context.
+//
+// A client span named by the request method and host is started for every
+// request, its context is injected into the outgoing headers and it is
+// finished once the response has been received. If base is nil,
+// http.DefaultTransport is used to perform the request.
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &tracingTransport{base: base}
+}
+
+// RoundTrip implements the RoundTrip() method of http.RoundTripper.
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if tracerFor(req.Context()) == nil {
+		return t.base.RoundTrip(req)
+	}
+	span, ctx := StartSpan(req.Context(), req.Method+" "+req.URL.Host, ext.SpanKindRPCClient)
+	defer span.Finish()
+	ext.HTTPMethod.Set(span, req.Method)
+	ext.HTTPUrl.Set(span, urlTag(req.URL, currentOptions()))
+
+	// A RoundTripper must not modify the request it was handed, so the
+	// headers are injected into a copy.
+	outReq := req.WithContext(ctx)
+	outReq.Header = cloneHeader(req.Header)
+	carrier := ot.HTTPHeadersCarrier(outReq.Header)
+	if err := span.Tracer().Inject(span.Context(), ot.HTTPHeaders, carrier); err != nil {
+		glog.Warningf("Could not inject span context into request to %s: %v", req.URL.Host, err)
+	}
+
+	resp, err := t.base.RoundTrip(outReq)
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(otlog.Error(err))
+		return nil, err
+	}
+	ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		ext.Error.Set(span, true)
+	}
+	return resp, nil
+}
+
+// redactedValue replaces the values of query parameters which aren't
+// allowlisted when Options.URLTagRedactValues is set, and the values of tags
+// masked by NewTagRedactor.
+const redactedValue = "REDACTED"
+
+// urlTag returns the value of the http.url tag for u. When the options have a
+// URLTagParamAllowlist, query parameters which aren't on it are dropped, or
+// have their values redacted if URLTagRedactValues is set.
+func urlTag(u *url.URL, options *Options) string {
+	if len(options.URLTagParamAllowlist) == 0 || u.RawQuery == "" {
+		return u.String()
+	}
+
+	allowed := make(map[string]bool, len(options.URLTagParamAllowlist))
+	for _, p := range options.URLTagParamAllowlist {
+		allowed[p] = true
+	}
+	query := u.Query()
+	for k := range query {
+		if allowed[k] {
+			continue
+		}
+		if options.URLTagRedactValues {
+			query[k] = []string{redactedValue}
+		} else {
+			delete(query, k)
+		}
+	}
+
+	tagged := *u
+	tagged.RawQuery = query.Encode()
+	return tagged.String()
+}
+
+func cloneHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
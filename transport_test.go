@@ -0,0 +1,126 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestTransport(t *testing.T) {
+	tracer := configureCollector(t, &Options{})
+	defer tracer.Close()
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+
+	parent := ot.StartSpan("parent")
+	req, _ := http.NewRequest("GET", server.URL+"/path", nil)
+	resp, err := NewTransport(nil).RoundTrip(req.WithContext(ot.ContextWithSpan(context.Background(), parent)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	parent.Finish()
+
+	if len(req.Header) != 0 {
+		t.Errorf("the request given to RoundTrip was modified: %v", req.Header)
+	}
+	spans := tracer.spans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want the client and parent ones", len(spans))
+	}
+	client := spans[0]
+	u, _ := url.Parse(server.URL)
+	if client.Operation != "GET "+u.Host || client.ParentID != spans[1].SpanID {
+		t.Errorf("got client span %+v", client)
+	}
+	if got := client.Tags["http.status_code"]; got != int64(http.StatusTeapot) {
+		t.Errorf("got status tag %v, want 418", got)
+	}
+
+	sc, err := jaeger.ContextFromString(received.Get("Uber-Trace-Id"))
+	if err != nil {
+		t.Fatalf("no span context received: %v", err)
+	}
+	if sc.TraceID().String() != client.TraceID || sc.SpanID().String() != client.SpanID || !sc.IsSampled() {
+		t.Errorf("got span context %v, want the one of client span %+v", sc, client)
+	}
+}
+
+func TestTransportWithoutTracer(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := NewTransport(nil).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := received.Get("Uber-Trace-Id") + received.Get("X-B3-TraceId"); got != "" {
+		t.Errorf("got trace headers %v without a tracer", received)
+	}
+}
+
+func TestURLTag(t *testing.T) {
+	u, _ := url.Parse("https://api.example.com/items?page=2&token=secret&cursor=abc&sort=name")
+	for _, tt := range []struct {
+		name    string
+		options Options
+		want    string
+	}{
+		{"no allowlist", Options{}, u.String()},
+		{"dropped", Options{URLTagParamAllowlist: []string{"page", "sort"}},
+			"https://api.example.com/items?page=2&sort=name"},
+		{"redacted", Options{URLTagParamAllowlist: []string{"page", "sort"}, URLTagRedactValues: true},
+			"https://api.example.com/items?cursor=REDACTED&page=2&sort=name&token=REDACTED"},
+	} {
+		if got := urlTag(u, &tt.options); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTransportTagsAllowlistedURL(t *testing.T) {
+	tracer := configureCollector(t, &Options{URLTagParamAllowlist: []string{"page"}})
+	defer tracer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/items?page=2&token=secret", nil)
+	resp, err := NewTransport(nil).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := tracer.onlySpan(t).Tags["http.url"], server.URL+"/items?page=2"; got != want {
+		t.Errorf("got http.url %q, want %q", got, want)
+	}
+}
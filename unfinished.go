@@ -0,0 +1,102 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	ot "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// unfinishedObserver counts the spans started but not yet finished per
+// operation, for Options.DebugUnfinishedSpans.
+type unfinishedObserver struct {
+	mu   sync.Mutex
+	open map[string]int
+}
+
+func newUnfinishedObserver() *unfinishedObserver {
+	return &unfinishedObserver{open: make(map[string]int)}
+}
+
+// OnStartSpan implements the OnStartSpan() method of jaeger.ContribObserver.
+func (o *unfinishedObserver) OnStartSpan(sp ot.Span, operationName string, options ot.StartSpanOptions) (jaeger.ContribSpanObserver, bool) {
+	o.mu.Lock()
+	o.open[operationName]++
+	o.mu.Unlock()
+	return &unfinishedSpanObserver{o: o, operation: operationName}, true
+}
+
+func (o *unfinishedObserver) finish(operationName string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.open[operationName]--; o.open[operationName] == 0 {
+		delete(o.open, operationName)
+	}
+}
+
+// warn logs the operations which have spans still open, if any.
+func (o *unfinishedObserver) warn() {
+	o.mu.Lock()
+	ops := make([]string, 0, len(o.open))
+	for op, n := range o.open {
+		ops = append(ops, op+" ("+strconv.Itoa(n)+")")
+	}
+	o.mu.Unlock()
+	if len(ops) == 0 {
+		return
+	}
+	sort.Strings(ops)
+	glog.Warningf("Spans were never finished for operations: %s", strings.Join(ops, ", "))
+}
+
+type unfinishedSpanObserver struct {
+	o *unfinishedObserver
+
+	mu        sync.Mutex
+	operation string
+	finished  bool // Finish may be called more than once
+}
+
+func (s *unfinishedSpanObserver) OnSetOperationName(operationName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		s.operation = operationName
+		return
+	}
+	s.o.finish(s.operation)
+	s.o.mu.Lock()
+	s.o.open[operationName]++
+	s.o.mu.Unlock()
+	s.operation = operationName
+}
+
+func (*unfinishedSpanObserver) OnSetTag(key string, value interface{}) {}
+
+func (s *unfinishedSpanObserver) OnFinish(options ot.FinishOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return
+	}
+	s.finished = true
+	s.o.finish(s.operation)
+}
@@ -0,0 +1,47 @@
+// Copyright 2018 Aspen Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"reflect"
+	"testing"
+
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestUnfinishedObserver(t *testing.T) {
+	o := newUnfinishedObserver()
+	tracer, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(true), jaeger.NewNullReporter(),
+		jaeger.TracerOptions.ContribObserver(o))
+	defer closer.Close()
+
+	tracer.StartSpan("leaked")
+	leaked := tracer.StartSpan("leaked")
+	renamed := tracer.StartSpan("op")
+	renamed.SetOperationName("renamed")
+	finished := tracer.StartSpan("leaked")
+	finished.Finish()
+	// repeated calls must not make up for the leaked spans
+	finished.Finish()
+	finished.SetOperationName("other")
+	leaked.SetTag("k", "v")
+
+	want := map[string]int{"leaked": 2, "renamed": 1}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !reflect.DeepEqual(o.open, want) {
+		t.Errorf("got open spans %v, want %v", o.open, want)
+	}
+}